@@ -0,0 +1,597 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Huuancao/sentinel/pkg/cert"
+	"github.com/olekukonko/tablewriter"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const defaultHTTPTimeout = 30
+
+var (
+	portsFlag      []int
+	portRangesFlag []string
+	excludeFlag    []string
+	outputFlag     string
+)
+
+// returns the scan configuration, the --ports flag overrides the configured ports and
+// the --exclude flag adds to the configured exclusions (certs.exclude). The connections
+// are paced by certs.ratelimit, the jitter is given in milliseconds.
+func getScanConfig() (cert.ScanConfig, error) {
+	ports := portsFlag
+	if len(ports) == 0 {
+		ports = viper.GetIntSlice("certs.ports")
+	}
+	scanConfig, err := cert.NewScanConfig(ports, viper.GetInt("certs.workers"), viper.GetInt("certs.timeout"))
+	if err != nil {
+		return scanConfig, err
+	}
+	scanConfig.StartTLS, err = cert.StartTLSPorts(viper.GetStringMapString("certs.starttls"))
+	if err != nil {
+		return scanConfig, err
+	}
+	scanConfig.Exclusions, err = cert.ParseExclusions(append(viper.GetStringSlice("certs.exclude"), excludeFlag...))
+	if err != nil {
+		return scanConfig, err
+	}
+	scanConfig.Limiter = cert.NewRateLimiter(viper.GetInt("certs.ratelimit.rate"), viper.GetInt("certs.ratelimit.hostrate"),
+		time.Duration(viper.GetInt("certs.ratelimit.jitter"))*time.Millisecond)
+	scanConfig.ClientCertificates, err = getClientCertificates()
+	return scanConfig, err
+}
+
+// returns the client certificates presented to the networks and domains configured in
+// certs.clientcerts
+func getClientCertificates() (cert.ClientCertificates, error) {
+	configured := []struct {
+		Cert     string
+		Key      string
+		Networks []string
+		Domains  []string
+	}{}
+	if err := viper.UnmarshalKey("certs.clientcerts", &configured); err != nil {
+		return nil, errors.Wrap(err, "invalid certs.clientcerts")
+	}
+	clients := cert.ClientCertificates{}
+	for _, client := range configured {
+		loaded, err := cert.LoadClientCertificate(client.Cert, client.Key, client.Networks, client.Domains)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, loaded)
+	}
+	return clients, nil
+}
+
+// returns the ports of the --port-ranges flag, or of certs.discovery.ranges unless
+// --ports is given, nil when no range is configured. The port ranges are only scanned
+// on the subnets.
+func getDiscoveryPorts() ([]int, error) {
+	ranges := portRangesFlag
+	if len(ranges) == 0 && len(portsFlag) == 0 {
+		ranges = viper.GetStringSlice("certs.discovery.ranges")
+	}
+	if len(ranges) == 0 {
+		return nil, nil
+	}
+	return cert.ParsePortRanges(ranges)
+}
+
+// returns the ports probed by the subnet scans, the discovered port ranges when some are
+// configured and the ports of the scan configuration otherwise
+func getSubnetPorts(scanConfig cert.ScanConfig) ([]int, error) {
+	ports, err := getDiscoveryPorts()
+	if err != nil || len(ports) != 0 {
+		return ports, err
+	}
+	return scanConfig.Ports, nil
+}
+
+// returns the TCP connect scanner run before the TLS probes (certs.discovery, timeout
+// in milliseconds) and the ports it scans, nil when no port range is configured.
+// It shares the exclusions and the rate limits of the scan configuration.
+func getPortScanner(scanConfig cert.ScanConfig) (*cert.PortScanner, []int, error) {
+	ports, err := getDiscoveryPorts()
+	if err != nil || len(ports) == 0 {
+		return nil, nil, err
+	}
+	portScanner := cert.NewPortScanner(viper.GetInt("certs.discovery.workers"), viper.GetInt("certs.discovery.rate"),
+		time.Duration(viper.GetInt("certs.discovery.timeout"))*time.Millisecond)
+	portScanner.Exclusions, portScanner.Limiter = scanConfig.Exclusions, scanConfig.Limiter
+	return portScanner, ports, nil
+}
+
+// returns the output format of the check commands, --output or certs.output, a table
+// when none is configured
+func getOutputFormat() (string, error) {
+	format := outputFlag
+	if format == "" {
+		format = viper.GetString("certs.output")
+	}
+	if format == "" || format == "table" {
+		return "table", nil
+	}
+	for _, known := range cert.ReportFormats {
+		if format == known {
+			return format, nil
+		}
+	}
+	return "", errors.Errorf("unknown output format %s, expected table, %s", format, strings.Join(cert.ReportFormats, ", "))
+}
+
+// writes the report in a machine readable format and returns the exit code of the summary
+func writeReport(w io.Writer, format string, report *cert.Report, summary *cert.Summary) int {
+	report.SetSummary(summary)
+	if err := cert.WriteReport(w, format, report); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot write report: %s\n", err)
+		return cert.ExitUnknown
+	}
+	return summary.ExitCode()
+}
+
+// returns the port of the result followed by the negotiated STARTTLS protocol if any
+func formatPort(result cert.Result) string {
+	if result.Protocol != "" {
+		return fmt.Sprintf("%d (%s)", result.Port, result.Protocol)
+	}
+	return fmt.Sprintf("%d", result.Port)
+}
+
+// returns the server name of the result, marked when the endpoint falls back to its
+// default certificate for it
+func formatServerName(result cert.Result) string {
+	if result.Fallback {
+		return result.ServerName + " (fallback)"
+	}
+	return result.ServerName
+}
+
+// returns an HTTP client with the configured timeout (certs.httptimeout, in seconds)
+func getHTTPClient() *http.Client {
+	timeout := viper.GetInt("certs.httptimeout")
+	if timeout <= 0 {
+		timeout = defaultHTTPTimeout
+	}
+	return &http.Client{Timeout: time.Duration(timeout) * time.Second}
+}
+
+// returns the configured DNS resolver (certs.resolver) or the system one
+func getResolver() (cert.HostResolver, error) {
+	server := viper.GetString("certs.resolver")
+	if server == "" {
+		return net.DefaultResolver, nil
+	}
+	return cert.NewDNSResolver(server, time.Duration(viper.GetInt("certs.resolvertimeout"))*time.Second)
+}
+
+// gathers the successful probes, failed handshakes are logged, unreachable endpoints and
+// the ones speaking another protocol than TLS only in verbose mode
+func collectResults(logger *logrus.Logger, resultChan <-chan cert.Result) []cert.Result {
+	results := []cert.Result{}
+	for result := range resultChan {
+		if result.Err != nil {
+			if result.Reachable && !cert.IsNotTLS(result.Err) {
+				logger.Infof("%s", result.Err)
+			} else {
+				logger.Debugf("%s", result.Err)
+			}
+			continue
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// sorts the results by host, port and SNI to get a stable output
+func sortResults(results []cert.Result) {
+	sort.Slice(results, func(i int, j int) bool {
+		if results[i].Host != results[j].Host {
+			return results[i].Host < results[j].Host
+		}
+		if results[i].Port != results[j].Port {
+			return results[i].Port < results[j].Port
+		}
+		return results[i].ServerName < results[j].ServerName
+	})
+}
+
+// returns the expiry thresholds (certs.warningdays and certs.criticaldays)
+func getExpiryConfig() (cert.ExpiryConfig, error) {
+	return cert.NewExpiryConfig(viper.GetInt("certs.warningdays"), viper.GetInt("certs.criticaldays"))
+}
+
+// returns the checkers evaluating the harvested endpoints
+func getCheckers() ([]cert.Checker, error) {
+	checkers, err := getOfflineCheckers()
+	if err != nil {
+		return nil, err
+	}
+	// revocation is checked unless explicitly disabled
+	if !viper.IsSet("certs.revocation.enabled") || viper.GetBool("certs.revocation.enabled") {
+		checkers = append(checkers, getRevocationChecker())
+	}
+	return checkers, nil
+}
+
+// returns the checkers that do not need any network access
+func getOfflineCheckers() ([]cert.Checker, error) {
+	validator, err := getChainValidator()
+	if err != nil {
+		return nil, err
+	}
+	checkers := []cert.Checker{validator}
+	// the cryptographic hygiene is audited unless explicitly disabled
+	if !viper.IsSet("certs.hygiene.enabled") || viper.GetBool("certs.hygiene.enabled") {
+		hygiene, err := getHygieneChecker()
+		if err != nil {
+			return nil, err
+		}
+		checkers = append(checkers, hygiene)
+	}
+	policy, err := getPolicyChecker()
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		checkers = append(checkers, policy)
+	}
+	return checkers, nil
+}
+
+// returns the checker of the rules of certs.policy.rules, nil when there is none
+func getPolicyChecker() (*cert.PolicyChecker, error) {
+	rules := []cert.PolicyRule{}
+	if err := viper.UnmarshalKey("certs.policy.rules", &rules); err != nil {
+		return nil, errors.Wrap(err, "invalid certs.policy.rules")
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	return cert.NewPolicyChecker(rules)
+}
+
+// returns the hygiene checker with the configured thresholds and rule severities (certs.hygiene)
+func getHygieneChecker() (*cert.HygieneChecker, error) {
+	return cert.NewHygieneChecker(
+		viper.GetInt("certs.hygiene.minrsabits"),
+		viper.GetInt("certs.hygiene.minecbits"),
+		viper.GetInt("certs.hygiene.maxvaliditydays"),
+		viper.GetStringMapString("certs.hygiene.rules"),
+	)
+}
+
+// returns the revocation checker, certs.revocation.ocspurl and certs.revocation.crlurl
+// replace the responders and distribution points of the certificates
+func getRevocationChecker() *cert.RevocationChecker {
+	return &cert.RevocationChecker{
+		Client:   getHTTPClient(),
+		OCSPURL:  viper.GetString("certs.revocation.ocspurl"),
+		CRLURL:   viper.GetString("certs.revocation.crlurl"),
+		CacheDir: viper.GetString("certs.revocation.cachedir"),
+	}
+}
+
+// returns the chain validator trusting the configured CA bundles (certs.trust.cabundles)
+func getChainValidator() (*cert.ChainValidator, error) {
+	// the system trust store is used unless explicitly disabled
+	useSystemPool := !viper.IsSet("certs.trust.system") || viper.GetBool("certs.trust.system")
+	return cert.NewChainValidator(useSystemPool, viper.GetStringSlice("certs.trust.cabundles"))
+}
+
+// records the certificates of the results in the inventory (certs.inventory.path) with
+// a snapshot of the scan and logs the changes since the previous snapshot of the same
+// scope, nothing is recorded when no path is configured. The notifications are sent
+// afterwards, they require the inventory for their deduplication.
+func recordInventory(logger *logrus.Logger, scope string, results []cert.Result) {
+	path := viper.GetString("certs.inventory.path")
+	if path == "" {
+		// the notifications are deduplicated with the state kept in the inventory
+		if notifiers, err := getNotifiers(); err != nil || len(notifiers) != 0 {
+			logger.Errorf("notifications are configured in certs.notify but not sent without certs.inventory.path")
+		}
+		return
+	}
+	inventory, err := cert.OpenInventory(path)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	now := time.Now()
+	events, err := recordSnapshot(inventory, path, scope, results, now)
+	// the inventory is not kept locked while the notifications are delivered
+	inventory.Close()
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	for _, event := range events {
+		logChangeEvent(logger, event)
+	}
+
+	sendNotifications(logger, path, results, events, now)
+}
+
+// records the certificates of the results and a snapshot of the scan in the inventory,
+// returns the changes since the previous snapshot of the same scope
+func recordSnapshot(inventory *cert.Inventory, path string, scope string, results []cert.Result, now time.Time) ([]cert.ChangeEvent, error) {
+	if err := inventory.Record(results, now); err != nil {
+		return nil, errors.Wrapf(err, "cannot record the certificates in inventory %s", path)
+	}
+	snapshots, err := inventory.Snapshots(scope)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read the snapshots of inventory %s", path)
+	}
+	snapshot := cert.NewSnapshot(scope, results, now)
+	events := []cert.ChangeEvent{}
+	if len(snapshots) != 0 {
+		events = cert.DiffSnapshots(snapshots[len(snapshots)-1], snapshot)
+	}
+	if err := inventory.SaveSnapshot(snapshot, viper.GetInt("certs.inventory.snapshots")); err != nil {
+		return events, errors.Wrapf(err, "cannot save the snapshot in inventory %s", path)
+	}
+	return events, nil
+}
+
+// returns the configured notifiers (certs.notify.webhook, slack and smtp)
+func getNotifiers() ([]cert.Notifier, error) {
+	notifiers := []cert.Notifier{}
+	if url := viper.GetString("certs.notify.webhook.url"); url != "" {
+		notifiers = append(notifiers, &cert.WebhookNotifier{
+			URL:     url,
+			Client:  getHTTPClient(),
+			Headers: viper.GetStringMapString("certs.notify.webhook.headers"),
+		})
+	}
+	if url := viper.GetString("certs.notify.slack.url"); url != "" {
+		notifiers = append(notifiers, &cert.SlackNotifier{
+			URL:      url,
+			Client:   getHTTPClient(),
+			Channel:  viper.GetString("certs.notify.slack.channel"),
+			Username: viper.GetString("certs.notify.slack.username"),
+		})
+	}
+	if addr := viper.GetString("certs.notify.smtp.addr"); addr != "" {
+		notifier, err := cert.NewSMTPNotifier(addr,
+			viper.GetString("certs.notify.smtp.from"),
+			viper.GetStringSlice("certs.notify.smtp.to"),
+			viper.GetString("certs.notify.smtp.username"),
+			viper.GetString("certs.notify.smtp.password"),
+			viper.GetString("certs.notify.smtp.subject"),
+			viper.GetString("certs.notify.smtp.body"))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid SMTP notifications")
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	return notifiers, nil
+}
+
+// notifies the certificates crossing the expiry thresholds and the changes of the
+// types of certs.notify.changes. The delivered notifications are recorded in the
+// inventory and not sent again unless their status gets more severe or, with
+// certs.notify.renotify (in hours), once the delay is over. The inventory at path is
+// only opened to read and record the delivered notifications, other commands can
+// read it during the delivery.
+func sendNotifications(logger *logrus.Logger, path string, results []cert.Result, events []cert.ChangeEvent, now time.Time) {
+	notifiers, err := getNotifiers()
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	if len(notifiers) == 0 {
+		return
+	}
+	expiry, err := getExpiryConfig()
+	if err != nil {
+		logger.Errorf("cannot create expiry config: %s", err)
+		return
+	}
+	changes := cert.DefaultNotifiedChanges
+	if viper.IsSet("certs.notify.changes") {
+		changes = viper.GetStringSlice("certs.notify.changes")
+	}
+
+	notifications := append(cert.ExpiryNotifications(results, expiry, now), cert.ChangeNotifications(events, changes)...)
+	inventory, err := cert.OpenInventory(path)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	pending, err := inventory.PendingNotifications(notifications, now, time.Duration(viper.GetInt("certs.notify.renotify"))*time.Hour)
+	inventory.Close()
+	if err != nil {
+		logger.Errorf("cannot deduplicate the notifications: %s", err)
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	// the notifications are sent again on the next scan unless every notifier succeeded
+	delivered := true
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(context.Background(), pending); err != nil {
+			logger.Errorf("%s", err)
+			delivered = false
+		}
+	}
+	if !delivered {
+		return
+	}
+	logger.Infof("Sent %d certificate notifications", len(pending))
+	inventory, err = cert.OpenInventory(path)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	defer inventory.Close()
+	if err := inventory.MarkNotified(pending, now); err != nil {
+		logger.Errorf("cannot record the notifications: %s", err)
+	}
+}
+
+// logs the change with its details as structured fields
+func logChangeEvent(logger *logrus.Logger, event cert.ChangeEvent) {
+	logger.WithFields(logrus.Fields{
+		"event":                event.Type,
+		"host":                 event.Host,
+		"port":                 event.Port,
+		"sni":                  event.ServerName,
+		"fingerprint":          event.Fingerprint,
+		"previous_fingerprint": event.PreviousFingerprint,
+		"issuer":               event.Issuer,
+		"previous_issuer":      event.PreviousIssuer,
+	}).Warnf("%s", event.Message)
+}
+
+// returns the scope of a scan, only the snapshots of the same scope are compared
+func scanScope(subnets []string, domains []string, ports []int) string {
+	sortedSubnets := append([]string{}, subnets...)
+	sort.Strings(sortedSubnets)
+	sortedDomains := append([]string{}, domains...)
+	sort.Strings(sortedDomains)
+
+	scope := []string{}
+	if len(sortedSubnets) != 0 {
+		scope = append(scope, "subnets="+strings.Join(sortedSubnets, ","))
+	}
+	if len(sortedDomains) != 0 {
+		scope = append(scope, "domains="+strings.Join(sortedDomains, ","))
+	}
+	// the discovered port ranges are shortened, e.g. 443 587 8000-8999
+	return strings.Join(append(scope, "ports="+strings.Replace(cert.FormatPortRanges(ports), ",", " ", -1)), " ")
+}
+
+// prints the plugin summary line followed by the harvested certificates and the
+// findings of the checkers, or the report in the given format, and returns the
+// Nagios/Icinga exit code
+func reportResults(w io.Writer, format string, results []cert.Result, expiry cert.ExpiryConfig, checkers []cert.Checker) int {
+	now := time.Now()
+	cert.RunCheckers(results, checkers, now)
+
+	summary := cert.NewSummary(expiry)
+	for _, result := range results {
+		for _, c := range result.Chain {
+			summary.Add(expiry.Evaluate(c, now), cert.DaysLeft(c, now))
+		}
+		for _, finding := range result.Findings {
+			summary.AddFinding(finding)
+		}
+	}
+
+	if format != "table" {
+		report := cert.NewReport(expiry, now)
+		for _, result := range results {
+			report.AddResult(result)
+		}
+		return writeReport(w, format, report, summary)
+	}
+
+	fmt.Fprintln(w, summary.String())
+	renderResults(w, results, expiry, now)
+	if summary.Findings != 0 {
+		renderFindings(w, results)
+	}
+	renderClientAuth(w, results)
+	return summary.ExitCode()
+}
+
+// displays the harvested certificates, one row per certificate of each chain
+func renderResults(w io.Writer, results []cert.Result, expiry cert.ExpiryConfig, now time.Time) {
+	table := tablewriter.NewWriter(w)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeader([]string{"Host", "Port", "SNI", "Depth", "Subject", "Issuer", "Not After", "Days Left", "Status", "Revocation"})
+
+	for _, result := range results {
+		for depth, c := range result.Chain {
+			table.Append([]string{
+				result.Host,
+				formatPort(result),
+				formatServerName(result),
+				fmt.Sprintf("%d", depth),
+				c.Subject.CommonName,
+				c.Issuer.CommonName,
+				c.NotAfter.UTC().Format("2006-01-02 15:04:05"),
+				fmt.Sprintf("%d", cert.DaysLeft(c, now)),
+				expiry.Evaluate(c, now).String(),
+				cert.RevocationState(result.Findings, depth),
+			})
+		}
+	}
+
+	table.Render()
+}
+
+// displays the endpoints requesting a client certificate and the CAs they advertise,
+// nothing when none requests one
+func renderClientAuth(w io.Writer, results []cert.Result) {
+	table := tablewriter.NewWriter(w)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeader([]string{"Host", "Port", "SNI", "Client Auth", "Client Certificate", "Advertised CAs"})
+
+	for _, result := range results {
+		if result.ClientAuth == "" {
+			continue
+		}
+		presented := "none"
+		if result.ClientCertPresented {
+			presented = "presented"
+		}
+		table.Append([]string{
+			result.Host,
+			formatPort(result),
+			formatServerName(result),
+			result.ClientAuth,
+			presented,
+			strings.Join(result.ClientCAs, "\n"),
+		})
+	}
+
+	if table.NumLines() != 0 {
+		table.Render()
+	}
+}
+
+// displays the findings of the checkers, one row per finding, OK findings are omitted
+func renderFindings(w io.Writer, results []cert.Result) {
+	table := tablewriter.NewWriter(w)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeader([]string{"Host", "Port", "SNI", "Depth", "Check", "Finding", "Status", "Message"})
+
+	for _, result := range results {
+		for _, finding := range result.Findings {
+			if finding.Status == cert.StatusOK {
+				continue
+			}
+			depth := ""
+			if finding.Depth >= 0 {
+				depth = fmt.Sprintf("%d", finding.Depth)
+			}
+			table.Append([]string{
+				result.Host,
+				formatPort(result),
+				formatServerName(result),
+				depth,
+				finding.Check,
+				finding.ID,
+				finding.Status.String(),
+				finding.Message,
+			})
+		}
+	}
+
+	table.Render()
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Huuancao/sentinel/pkg/cert"
+	"github.com/Huuancao/sentinel/pkg/config"
+	"github.com/olekukonko/tablewriter"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	blastRadiusMinEndpoints  int
+	blastRadiusExpiresWithin int
+	blastRadiusSeenWithin    int
+	blastRadiusExport        string
+)
+
+var blastRadiusCertCmd = &cobra.Command{
+	Use:   "certBlastRadius",
+	Short: "Report the endpoints sharing a certificate or a private key.",
+	Long: `Report the endpoints sharing a certificate or a private key.
+
+The leaf certificates recorded in the inventory (certs.inventory.path) by certSubnetCheck,
+certSubdomainCheck and certMonitor are grouped by fingerprint and by public key (SHA-256
+of the SPKI), and the groups presented by several endpoints are listed with all of them:
+the endpoints to touch when the certificate expires or its key is compromised. A key is
+listed when it is shared by several certificates, e.g. renewed without a new key.
+
+The wildcard certificates presented by at least certs.blastradius.wildcardhosts hosts
+are highlighted as broad wildcards and listed first.
+
+The groups can be exported as JSON or CSV with --export.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		reportBlastRadius()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(blastRadiusCertCmd)
+	//Flags
+	blastRadiusCertCmd.Flags().IntVarP(&blastRadiusMinEndpoints, "min-endpoints", "", 2, "Only list the certificates and keys shared by at least the given number of endpoints")
+	blastRadiusCertCmd.Flags().IntVarP(&blastRadiusExpiresWithin, "expires-within", "", 0, "Only list the certificates and keys expiring within the given number of days")
+	blastRadiusCertCmd.Flags().IntVarP(&blastRadiusSeenWithin, "seen-within", "", 0, "Only count the endpoints seen within the given number of days")
+	blastRadiusCertCmd.Flags().StringVarP(&blastRadiusExport, "export", "", "", "Export the groups as json or csv instead of a table")
+}
+
+func reportBlastRadius() {
+	logger, err := config.GetLogger(verbose)
+	if err != nil {
+		fmt.Printf("Cannot get logger: %s\n", err)
+		os.Exit(1)
+	}
+
+	path := viper.GetString("certs.inventory.path")
+	if path == "" {
+		fmt.Println("No inventory configured in certs.inventory.path!")
+		os.Exit(1)
+	}
+
+	now := time.Now()
+	options := cert.BlastRadiusOptions{
+		MinEndpoints:       blastRadiusMinEndpoints,
+		BroadWildcardHosts: viper.GetInt("certs.blastradius.wildcardhosts"),
+	}
+	if blastRadiusExpiresWithin > 0 {
+		options.ExpiresBefore = now.AddDate(0, 0, blastRadiusExpiresWithin)
+	}
+	if blastRadiusSeenWithin > 0 {
+		options.SeenSince = now.AddDate(0, 0, -blastRadiusSeenWithin)
+	}
+
+	inventory, err := cert.OpenInventory(path)
+	if err != nil {
+		logger.Fatalf("%s\n", err)
+		os.Exit(1)
+	}
+	defer inventory.Close()
+
+	records, err := inventory.List(cert.InventoryFilter{})
+	if err != nil {
+		logger.Fatalf("cannot list inventory %s: %s\n", path, err)
+		os.Exit(1)
+	}
+	groups, err := cert.BlastRadius(records, options)
+	if err != nil {
+		logger.Fatalf("cannot group inventory %s: %s\n", path, err)
+		os.Exit(1)
+	}
+
+	if err := exportBlastRadius(os.Stdout, groups, blastRadiusExport); err != nil {
+		logger.Fatalf("cannot export blast radius: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// writes the groups in the given format, a table when empty
+func exportBlastRadius(w io.Writer, groups []*cert.BlastRadiusGroup, format string) error {
+	switch format {
+	case "":
+		renderBlastRadius(w, groups)
+		return nil
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(groups)
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"kind", "id", "key_type", "wildcard", "broad_wildcard", "subjects", "sans", "certificates", "not_after", "hosts", "endpoints"})
+		for _, group := range groups {
+			writer.Write([]string{
+				group.Kind,
+				group.ID,
+				group.KeyType,
+				strconv.FormatBool(group.Wildcard),
+				strconv.FormatBool(group.BroadWildcard),
+				strings.Join(group.Subjects, " | "),
+				strings.Join(group.SANs, " "),
+				strings.Join(group.Certificates, " "),
+				group.NotAfter.UTC().Format(time.RFC3339),
+				strconv.Itoa(len(group.Hosts)),
+				strings.Join(blastRadiusEndpoints(group), " "),
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return errors.Errorf("unknown export format %s, expected json or csv", format)
+}
+
+// returns the endpoints of the group
+func blastRadiusEndpoints(group *cert.BlastRadiusGroup) []string {
+	endpoints := []string{}
+	for _, endpoint := range group.Endpoints {
+		endpoints = append(endpoints, endpoint.String())
+	}
+	return endpoints
+}
+
+// displays the groups, one row per certificate or key
+func renderBlastRadius(w io.Writer, groups []*cert.BlastRadiusGroup) {
+	table := tablewriter.NewWriter(w)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeader([]string{"Kind", "ID", "Subject", "Wildcard", "Not After", "Hosts", "Endpoints"})
+
+	for _, group := range groups {
+		wildcard := ""
+		if group.BroadWildcard {
+			wildcard = "BROAD"
+		} else if group.Wildcard {
+			wildcard = "yes"
+		}
+		table.Append([]string{
+			group.Kind,
+			group.ID[:16],
+			strings.Join(group.Subjects, "\n"),
+			wildcard,
+			group.NotAfter.UTC().Format("2006-01-02 15:04:05"),
+			strconv.Itoa(len(group.Hosts)),
+			strings.Join(blastRadiusEndpoints(group), "\n"),
+		})
+	}
+
+	table.Render()
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Huuancao/sentinel/pkg/cert"
+	"github.com/Huuancao/sentinel/pkg/config"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const defaultCalendarPath = "/calendar.ics"
+
+var (
+	calendarFile          string
+	calendarExpiresWithin int
+	calendarCAs           bool
+)
+
+var calendarCertCmd = &cobra.Command{
+	Use:   "certCalendar",
+	Short: "Export the expiry dates of the certificates of the inventory as an iCalendar feed.",
+	Long: `Export the expiry dates of the certificates of the inventory as an iCalendar feed.
+
+Every leaf certificate recorded in the inventory (certs.inventory.path) becomes an
+event at its expiry date, with the endpoints presenting it in the description and
+reminder alarms certs.warningdays and certs.criticaldays before. The CA certificates
+are added with --cas or certs.calendar.cas.
+
+The feed is written to the standard output or to --file. certMonitor also serves it
+on its metrics socket at certs.calendar.path when certs.calendar.serve is set, so that
+a shared calendar can subscribe to it through a reverse proxy.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		exportCalendar()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(calendarCertCmd)
+	//Flags
+	calendarCertCmd.Flags().StringVarP(&calendarFile, "file", "", "", "Write the feed to the given file instead of the standard output")
+	calendarCertCmd.Flags().IntVarP(&calendarExpiresWithin, "expires-within", "", 0, "Only export the certificates expiring within the given number of days")
+	calendarCertCmd.Flags().BoolVarP(&calendarCAs, "cas", "", false, "Also export the CA certificates (default certs.calendar.cas)")
+}
+
+func exportCalendar() {
+	logger, err := config.GetLogger(verbose)
+	if err != nil {
+		fmt.Printf("Cannot get logger: %s\n", err)
+		os.Exit(1)
+	}
+
+	if viper.GetString("certs.inventory.path") == "" {
+		fmt.Println("No inventory configured in certs.inventory.path!")
+		os.Exit(1)
+	}
+
+	filter := cert.InventoryFilter{}
+	if calendarExpiresWithin > 0 {
+		filter.ExpiresBefore = time.Now().AddDate(0, 0, calendarExpiresWithin)
+	}
+	calendar := &bytes.Buffer{}
+	if err := writeCalendar(calendar, filter, calendarCAs || viper.GetBool("certs.calendar.cas")); err != nil {
+		logger.Fatalf("cannot export calendar: %s\n", err)
+		os.Exit(1)
+	}
+
+	if calendarFile == "" {
+		os.Stdout.Write(calendar.Bytes())
+		return
+	}
+	if err := ioutil.WriteFile(calendarFile, calendar.Bytes(), 0644); err != nil {
+		logger.Fatalf("cannot write calendar %s: %s\n", calendarFile, err)
+		os.Exit(1)
+	}
+}
+
+// writes the calendar of the certificates of the inventory selected by the filter, the
+// leaves only unless includeCAs is set
+func writeCalendar(w io.Writer, filter cert.InventoryFilter, includeCAs bool) error {
+	expiry, err := getExpiryConfig()
+	if err != nil {
+		return err
+	}
+	inventory, err := cert.OpenInventory(viper.GetString("certs.inventory.path"))
+	if err != nil {
+		return err
+	}
+	defer inventory.Close()
+
+	records, err := inventory.List(filter)
+	if err != nil {
+		return err
+	}
+	selected := []*cert.InventoryRecord{}
+	for _, record := range records {
+		if includeCAs || record.IsLeaf() {
+			selected = append(selected, record)
+		}
+	}
+	return cert.WriteCalendar(w, selected, expiry, time.Now())
+}
+
+// returns the handler serving the calendar feed of the inventory
+func calendarHandler(logger *logrus.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calendar := &bytes.Buffer{}
+		if err := writeCalendar(calendar, cert.InventoryFilter{}, viper.GetBool("certs.calendar.cas")); err != nil {
+			logger.Errorf("cannot export calendar: %s", err)
+			http.Error(w, "calendar unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Write(calendar.Bytes())
+	})
+}
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Huuancao/sentinel/pkg/cert"
+	"github.com/Huuancao/sentinel/pkg/config"
+	"github.com/olekukonko/tablewriter"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	diffFrom   uint64
+	diffTo     uint64
+	diffScope  string
+	diffList   bool
+	diffExport string
+)
+
+var diffCertCmd = &cobra.Command{
+	Use:   "certDiff",
+	Short: "Compare two certificate scans recorded in the inventory.",
+	Long: `Compare two certificate scans recorded in the inventory.
+
+Every scan of certSubnetCheck, certSubdomainCheck and certMonitor is recorded as a
+snapshot in the inventory (certs.inventory.path). Two snapshots are compared endpoint
+by endpoint and the following events are reported:
+  new-certificate  a certificate that was not presented before appeared
+  rotated          the endpoint presents another certificate
+  issuer-changed   the new certificate of the endpoint has another issuer
+  disappeared      a certificate is no longer presented by any endpoint
+  tls-stopped      the endpoint no longer answers TLS
+
+By default the last snapshot is compared to the previous one of the same scope,
+--list displays the recorded snapshots and their identifiers.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		diffSnapshots()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(diffCertCmd)
+	//Flags
+	diffCertCmd.Flags().Uint64VarP(&diffFrom, "from", "", 0, "Identifier of the older snapshot (default the one preceding --to in its scope)")
+	diffCertCmd.Flags().Uint64VarP(&diffTo, "to", "", 0, "Identifier of the newer snapshot (default the last one)")
+	diffCertCmd.Flags().StringVarP(&diffScope, "scope", "", "", "Only consider the snapshots of the given scope")
+	diffCertCmd.Flags().BoolVarP(&diffList, "list", "", false, "List the recorded snapshots")
+	diffCertCmd.Flags().StringVarP(&diffExport, "export", "", "", "Export the events as json instead of a table")
+}
+
+func diffSnapshots() {
+	logger, err := config.GetLogger(verbose)
+	if err != nil {
+		fmt.Printf("Cannot get logger: %s\n", err)
+		os.Exit(1)
+	}
+
+	path := viper.GetString("certs.inventory.path")
+	if path == "" {
+		fmt.Println("No inventory configured in certs.inventory.path!")
+		os.Exit(1)
+	}
+
+	inventory, err := cert.OpenInventory(path)
+	if err != nil {
+		logger.Fatalf("%s\n", err)
+		os.Exit(1)
+	}
+	defer inventory.Close()
+
+	if diffList {
+		snapshots, err := inventory.Snapshots(diffScope)
+		if err != nil {
+			logger.Fatalf("cannot list snapshots: %s\n", err)
+			os.Exit(1)
+		}
+		renderSnapshots(os.Stdout, snapshots)
+		return
+	}
+
+	previous, current, err := selectSnapshots(inventory, diffFrom, diffTo, diffScope)
+	if err != nil {
+		logger.Fatalf("%s\n", err)
+		os.Exit(1)
+	}
+	logger.Debugf("Comparing snapshot %d (%s) to snapshot %d (%s)", previous.ID, previous.Time, current.ID, current.Time)
+
+	events := cert.DiffSnapshots(previous, current)
+	switch diffExport {
+	case "":
+		renderEvents(os.Stdout, events)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(events); err != nil {
+			logger.Fatalf("cannot export the changes: %s\n", err)
+			os.Exit(1)
+		}
+	default:
+		logger.Fatalf("unknown export format %s, expected json\n", diffExport)
+		os.Exit(1)
+	}
+}
+
+// returns the snapshots to compare, the last one and its predecessor in the same
+// scope when the identifiers are not given
+func selectSnapshots(inventory *cert.Inventory, from uint64, to uint64, scope string) (*cert.Snapshot, *cert.Snapshot, error) {
+	var current *cert.Snapshot
+	if to != 0 {
+		snapshot, err := inventory.Snapshot(to)
+		if err != nil {
+			return nil, nil, err
+		}
+		current = snapshot
+	} else {
+		snapshots, err := inventory.Snapshots(scope)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(snapshots) == 0 {
+			return nil, nil, errors.New("no snapshot recorded")
+		}
+		current = snapshots[len(snapshots)-1]
+	}
+
+	if from != 0 {
+		previous, err := inventory.Snapshot(from)
+		return previous, current, err
+	}
+	snapshots, err := inventory.Snapshots(current.Scope)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].ID < current.ID {
+			return snapshots[i], current, nil
+		}
+	}
+	return nil, nil, errors.Errorf("no snapshot precedes snapshot %d in scope %s", current.ID, current.Scope)
+}
+
+// displays the snapshots, one row per snapshot
+func renderSnapshots(w io.Writer, snapshots []*cert.Snapshot) {
+	table := tablewriter.NewWriter(w)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeader([]string{"ID", "Time", "Scope", "Endpoints"})
+
+	for _, snapshot := range snapshots {
+		table.Append([]string{
+			fmt.Sprintf("%d", snapshot.ID),
+			snapshot.Time.UTC().Format("2006-01-02 15:04:05"),
+			snapshot.Scope,
+			fmt.Sprintf("%d", len(snapshot.Endpoints)),
+		})
+	}
+
+	table.Render()
+}
+
+// displays the changes, one row per event
+func renderEvents(w io.Writer, events []cert.ChangeEvent) {
+	table := tablewriter.NewWriter(w)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeader([]string{"Host", "Port", "SNI", "Event", "Message"})
+
+	for _, event := range events {
+		table.Append([]string{
+			event.Host,
+			fmt.Sprintf("%d", event.Port),
+			event.ServerName,
+			event.Type,
+			event.Message,
+		})
+	}
+
+	table.Render()
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Huuancao/sentinel/pkg/cert"
+	"github.com/Huuancao/sentinel/pkg/config"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// environment variable holding an additional keystore password
+const keystorePasswordEnv = "SENTINEL_KEYSTORE_PASSWORD"
+
+var (
+	paths []string
+)
+
+var checkFileCertCmd = &cobra.Command{
+	Use:   "certFileCheck",
+	Short: "Check the validity of all certificates stored in the given files and directories.",
+	Long: `Check the validity of all certificates stored in the given files and directories.
+
+The directories are walked recursively and every PEM, DER, PKCS#7, PKCS#12 and Java
+KeyStore (JKS or JCEKS) file is parsed. The PKCS#12 files are opened with the passwords
+of certs.files.passwords and of the SENTINEL_KEYSTORE_PASSWORD environment variable.
+
+See sentinel help certs for the behaviours shared by the certificate commands.
+
+You may provide multiple paths, certs.files.paths is used when none is given.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		checkFileCert()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(checkFileCertCmd)
+	//Flags
+	checkFileCertCmd.Flags().StringVarP(&outputFlag, "output", "", "", "Output format: table, json, ndjson, csv, html or junit (default certs.output)")
+	checkFileCertCmd.Flags().StringSliceVarP(&paths, "paths", "", []string{}, "Files and directories to scan for certificates (default certs.files.paths)")
+}
+
+func checkFileCert() {
+	logger, err := config.GetLogger(verbose)
+	if err != nil {
+		fmt.Printf("Cannot get logger: %s\n", err)
+		os.Exit(1)
+	}
+
+	if len(paths) == 0 {
+		paths = viper.GetStringSlice("certs.files.paths")
+	}
+	if len(paths) == 0 {
+		fmt.Println("You have to provide at least one path!")
+		os.Exit(1)
+	}
+	logger.Debugf("Provided paths: %v", paths)
+
+	format, err := getOutputFormat()
+	if err != nil {
+		logger.Fatalf("cannot get output format: %s\n", err)
+		os.Exit(1)
+	}
+
+	expiryConfig, err := getExpiryConfig()
+	if err != nil {
+		logger.Fatalf("cannot create expiry config: %s\n", err)
+		os.Exit(1)
+	}
+
+	passwords := viper.GetStringSlice("certs.files.passwords")
+	if password := os.Getenv(keystorePasswordEnv); password != "" {
+		passwords = append(passwords, password)
+	}
+
+	certificates, errs := cert.ScanFiles(paths, passwords)
+	for _, err := range errs {
+		logger.Errorf("%s", err)
+	}
+	os.Exit(reportFiles(os.Stdout, format, certificates, expiryConfig))
+}
+
+// prints the plugin summary line followed by the certificates found in the files and
+// returns the Nagios/Icinga exit code
+func reportFiles(w io.Writer, format string, certificates []cert.FileCertificate, expiry cert.ExpiryConfig) int {
+	now := time.Now()
+	summary := cert.NewSummary(expiry)
+	for _, file := range certificates {
+		summary.Add(expiry.Evaluate(file.Certificate, now), cert.DaysLeft(file.Certificate, now))
+	}
+
+	if format != "table" {
+		report := cert.NewReport(expiry, now)
+		for _, file := range certificates {
+			name := file.Path
+			if file.Alias != "" {
+				name += " (" + file.Alias + ")"
+			}
+			report.AddEndpoint(name, []*x509.Certificate{file.Certificate}, nil).Path = file.Path
+		}
+		return writeReport(w, format, report, summary)
+	}
+
+	fmt.Fprintln(w, summary.String())
+	renderFiles(w, certificates, expiry, now)
+	return summary.ExitCode()
+}
+
+// displays the certificates found in the files, one row per certificate
+func renderFiles(w io.Writer, certificates []cert.FileCertificate, expiry cert.ExpiryConfig, now time.Time) {
+	table := tablewriter.NewWriter(w)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeader([]string{"Path", "Format", "Alias", "Subject", "SANs", "Issuer", "Not After", "Days Left", "Key", "Status"})
+
+	for _, file := range certificates {
+		c := file.Certificate
+		sans := append([]string{}, c.DNSNames...)
+		for _, ip := range c.IPAddresses {
+			sans = append(sans, ip.String())
+		}
+		sans = append(sans, c.EmailAddresses...)
+		table.Append([]string{
+			file.Path,
+			file.Format,
+			file.Alias,
+			c.Subject.CommonName,
+			strings.Join(sans, "\n"),
+			c.Issuer.CommonName,
+			c.NotAfter.UTC().Format("2006-01-02 15:04:05"),
+			fmt.Sprintf("%d", cert.DaysLeft(c, now)),
+			cert.KeyType(c),
+			expiry.Evaluate(c, now).String(),
+		})
+	}
+
+	table.Render()
+}
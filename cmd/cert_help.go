@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// help topic describing the behaviours shared by the certificate commands, shown by
+// sentinel help certs
+var certCmd = &cobra.Command{
+	Use:   "certs",
+	Short: "Checks, reports and notifications shared by the certificate commands.",
+	Long: `Checks, reports and notifications shared by the certificate commands.
+
+Expiry (all checks)
+Every certificate is classified as OK, WARNING, CRITICAL or EXPIRED according to
+certs.warningdays and certs.criticaldays. The first output line and the exit code
+follow the Nagios/Icinga plugin conventions.
+
+Output (all checks)
+With --output, the scan is reported as JSON, NDJSON (one endpoint per line), CSV (one
+row per certificate), a self-contained HTML page grouped by expiry bucket or JUnit XML
+(one testcase per endpoint, failed unless OK) instead, with the same exit code.
+
+Handshake (certSubnetCheck, certSubdomainCheck)
+Connections to STARTTLS ports (SMTP, IMAP, POP3, FTP, LDAP, XMPP, PostgreSQL and MySQL)
+are upgraded before the handshake, the ports are mapped to protocols in certs.starttls.
+The endpoints requesting a client certificate are listed with the CAs they advertise,
+the client certificates of certs.clientcerts are presented to the networks and domains
+they are configured for. The server certificates of the endpoints requiring a client
+certificate are reported even when the handshake fails without one.
+
+Trust (certSubnetCheck, certSubdomainCheck, certManifestCheck)
+The presented chains are verified against the system trust store and the CA bundles
+of certs.trust.cabundles, their problems are reported as findings.
+
+Revocation (certSubnetCheck, certSubdomainCheck)
+The revocation status of every certificate is checked with the stapled OCSP response,
+the OCSP responder or the CRLs (certs.revocation), revoked certificates are CRITICAL.
+
+Hygiene (certSubnetCheck, certSubdomainCheck, certManifestCheck)
+Weak keys and signatures, long validity periods, missing SANs, deprecated TLS versions
+and weak cipher suites are flagged with the severities configured in certs.hygiene.
+
+Policy (certSubnetCheck, certSubdomainCheck, certManifestCheck)
+The leaves are evaluated against the rules of the certificate policy (certs.policy.rules):
+allowed issuers, key types, maximum validity, wildcards forbidden in some zones and
+SANs covering the hostname. The violations are reported with the id of the rule.
+
+Inventory and notifications (certSubnetCheck, certSubdomainCheck, certMonitor)
+The harvested certificates are recorded in the inventory (certs.inventory.path),
+see certInventory, with a snapshot of the scan. The changes since the previous scan
+are logged, see certDiff. The certificates crossing the expiry thresholds and the
+changes are notified by webhook, Slack/Mattermost or email as configured in
+certs.notify, each notification is sent once. The notifications require the inventory.
+
+Renewal (certSubnetCheck, certSubdomainCheck)
+When certs.renew.enabled is set, the certificates of certs.renew.certificates found
+expiring are renewed from the ACME directory of certs.renew.directory (a local Pebble
+with certs.renew.cabundle) with the HTTP-01 or DNS-01 challenge, written to their
+paths and followed by their hook command.`,
+}
+
+func init() {
+	RootCmd.AddCommand(certCmd)
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Huuancao/sentinel/pkg/cert"
+	"github.com/Huuancao/sentinel/pkg/config"
+	"github.com/olekukonko/tablewriter"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	inventoryFilter        cert.InventoryFilter
+	inventoryExpiresWithin int
+	inventorySeenWithin    int
+	inventoryExport        string
+)
+
+var inventoryCertCmd = &cobra.Command{
+	Use:   "certInventory",
+	Short: "List the certificates recorded in the inventory.",
+	Long: `List the certificates recorded in the inventory.
+
+Every certificate harvested by certSubnetCheck, certSubdomainCheck and certMonitor is
+recorded by fingerprint in the inventory (certs.inventory.path) with the dates it was
+first and last seen and the endpoints that presented it.
+
+The records can be filtered and exported as JSON, CSV or PEM with --export.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		listInventory()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(inventoryCertCmd)
+	//Flags
+	inventoryCertCmd.Flags().StringVarP(&inventoryFilter.Fingerprint, "fingerprint", "", "", "Only list the certificates whose SHA-256 fingerprint starts with the given prefix")
+	inventoryCertCmd.Flags().StringVarP(&inventoryFilter.Subject, "subject", "", "", "Only list the certificates whose subject contains the given string")
+	inventoryCertCmd.Flags().StringVarP(&inventoryFilter.Issuer, "issuer", "", "", "Only list the certificates whose issuer contains the given string")
+	inventoryCertCmd.Flags().StringVarP(&inventoryFilter.Host, "host", "", "", "Only list the certificates presented by the given host or SNI")
+	inventoryCertCmd.Flags().IntVarP(&inventoryExpiresWithin, "expires-within", "", 0, "Only list the certificates expiring within the given number of days")
+	inventoryCertCmd.Flags().IntVarP(&inventorySeenWithin, "seen-within", "", 0, "Only list the certificates seen within the given number of days")
+	inventoryCertCmd.Flags().StringVarP(&inventoryExport, "export", "", "", "Export the certificates as json, csv or pem instead of a table")
+}
+
+func listInventory() {
+	logger, err := config.GetLogger(verbose)
+	if err != nil {
+		fmt.Printf("Cannot get logger: %s\n", err)
+		os.Exit(1)
+	}
+
+	path := viper.GetString("certs.inventory.path")
+	if path == "" {
+		fmt.Println("No inventory configured in certs.inventory.path!")
+		os.Exit(1)
+	}
+
+	now := time.Now()
+	if inventoryExpiresWithin > 0 {
+		inventoryFilter.ExpiresBefore = now.AddDate(0, 0, inventoryExpiresWithin)
+	}
+	if inventorySeenWithin > 0 {
+		inventoryFilter.SeenSince = now.AddDate(0, 0, -inventorySeenWithin)
+	}
+
+	inventory, err := cert.OpenInventory(path)
+	if err != nil {
+		logger.Fatalf("%s\n", err)
+		os.Exit(1)
+	}
+	defer inventory.Close()
+
+	records, err := inventory.List(inventoryFilter)
+	if err != nil {
+		logger.Fatalf("cannot list inventory %s: %s\n", path, err)
+		os.Exit(1)
+	}
+
+	if err := exportInventory(os.Stdout, records, inventoryExport); err != nil {
+		logger.Fatalf("cannot export inventory: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// writes the records in the given format, a table when empty
+func exportInventory(w io.Writer, records []*cert.InventoryRecord, format string) error {
+	switch format {
+	case "":
+		renderInventory(w, records)
+		return nil
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"fingerprint", "subject", "issuer", "serial", "sans", "not_before", "not_after", "first_seen", "last_seen", "endpoints"})
+		for _, record := range records {
+			writer.Write([]string{
+				record.Fingerprint,
+				record.Subject,
+				record.Issuer,
+				record.Serial,
+				strings.Join(record.SANs, " "),
+				record.NotBefore.UTC().Format(time.RFC3339),
+				record.NotAfter.UTC().Format(time.RFC3339),
+				record.FirstSeen.UTC().Format(time.RFC3339),
+				record.LastSeen.UTC().Format(time.RFC3339),
+				strings.Join(inventoryEndpoints(record), " "),
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	case "pem":
+		for _, record := range records {
+			if err := pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: record.Raw}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return errors.Errorf("unknown export format %s, expected json, csv or pem", format)
+}
+
+// returns the endpoints that presented the certificate
+func inventoryEndpoints(record *cert.InventoryRecord) []string {
+	endpoints := []string{}
+	for _, endpoint := range record.Endpoints {
+		endpoints = append(endpoints, endpoint.String())
+	}
+	return endpoints
+}
+
+// displays the records, one row per certificate
+func renderInventory(w io.Writer, records []*cert.InventoryRecord) {
+	table := tablewriter.NewWriter(w)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeader([]string{"Fingerprint", "Subject", "Issuer", "Not After", "First Seen", "Last Seen", "Endpoints"})
+
+	for _, record := range records {
+		table.Append([]string{
+			record.Fingerprint[:16],
+			record.Subject,
+			record.Issuer,
+			record.NotAfter.UTC().Format("2006-01-02 15:04:05"),
+			record.FirstSeen.UTC().Format("2006-01-02 15:04:05"),
+			record.LastSeen.UTC().Format("2006-01-02 15:04:05"),
+			strings.Join(inventoryEndpoints(record), "\n"),
+		})
+	}
+
+	table.Render()
+}
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Huuancao/sentinel/pkg/cert"
+	"github.com/Huuancao/sentinel/pkg/config"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	manifestPaths []string
+)
+
+var checkManifestCertCmd = &cobra.Command{
+	Use:   "certManifestCheck",
+	Short: "Check the validity of all certificates stored in Kubernetes manifests.",
+	Long: `Check the validity of all certificates stored in Kubernetes manifests.
+
+The YAML and JSON manifests of the given files and directories, e.g. a GitOps repository
+or kubectl get secret -o yaml dumps, are parsed offline. The certificates of the tls.crt
+and ca.crt entries of the Secrets are extracted, the cert-manager Certificates are
+matched with their Secret and their requested names checked against its certificate.
+The Certificates whose Secret is not part of the manifests are reported with the expiry
+of their status, if any.
+
+The revocation of the tls.crt entries is not checked since it requires network access.
+
+See sentinel help certs for the behaviours shared by the certificate commands.
+
+You may provide multiple paths, certs.kubernetes.paths is used when none is given.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		checkManifestCert()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(checkManifestCertCmd)
+	//Flags
+	checkManifestCertCmd.Flags().StringVarP(&outputFlag, "output", "", "", "Output format: table, json, ndjson, csv, html or junit (default certs.output)")
+	checkManifestCertCmd.Flags().StringSliceVarP(&manifestPaths, "paths", "", []string{}, "Manifest files and directories to scan for certificates (default certs.kubernetes.paths)")
+}
+
+func checkManifestCert() {
+	logger, err := config.GetLogger(verbose)
+	if err != nil {
+		fmt.Printf("Cannot get logger: %s\n", err)
+		os.Exit(1)
+	}
+
+	if len(manifestPaths) == 0 {
+		manifestPaths = viper.GetStringSlice("certs.kubernetes.paths")
+	}
+	if len(manifestPaths) == 0 {
+		fmt.Println("You have to provide at least one path!")
+		os.Exit(1)
+	}
+	logger.Debugf("Provided paths: %v", manifestPaths)
+
+	format, err := getOutputFormat()
+	if err != nil {
+		logger.Fatalf("cannot get output format: %s\n", err)
+		os.Exit(1)
+	}
+
+	expiryConfig, err := getExpiryConfig()
+	if err != nil {
+		logger.Fatalf("cannot create expiry config: %s\n", err)
+		os.Exit(1)
+	}
+
+	checkers, err := getOfflineCheckers()
+	if err != nil {
+		logger.Fatalf("cannot create certificate checkers: %s\n", err)
+		os.Exit(1)
+	}
+
+	entries, errs := cert.ScanManifests(manifestPaths)
+	for _, err := range errs {
+		logger.Errorf("%s", err)
+	}
+	os.Exit(reportManifests(os.Stdout, format, entries, expiryConfig, checkers))
+}
+
+// prints the plugin summary line followed by the certificates of the manifests and the
+// findings and returns the Nagios/Icinga exit code
+func reportManifests(w io.Writer, format string, entries []*cert.ManifestEntry, expiry cert.ExpiryConfig, checkers []cert.Checker) int {
+	now := time.Now()
+	summary := cert.NewSummary(expiry)
+	for _, entry := range entries {
+		// the ca.crt entries are bundles of trusted CAs rather than chains
+		if entry.Key == "tls.crt" {
+			results := []cert.Result{entry.Result()}
+			cert.RunCheckers(results, checkers, now)
+			entry.Findings = append(entry.Findings, results[0].Findings...)
+		}
+
+		for _, c := range entry.Chain {
+			summary.Add(expiry.Evaluate(c, now), cert.DaysLeft(c, now))
+		}
+		if len(entry.Chain) == 0 && !entry.NotAfter.IsZero() {
+			summary.Add(expiry.EvaluateNotAfter(entry.NotAfter, now), cert.DaysUntil(entry.NotAfter, now))
+		}
+		for _, finding := range entry.Findings {
+			summary.AddFinding(finding)
+		}
+	}
+
+	if format != "table" {
+		report := cert.NewReport(expiry, now)
+		for _, entry := range entries {
+			endpoint := report.AddEndpoint(entry.Resource()+" "+entry.Key, entry.Chain, entry.Findings)
+			endpoint.Path = entry.Path
+			if len(entry.Chain) == 0 && !entry.NotAfter.IsZero() {
+				endpoint.Raise(expiry.EvaluateNotAfter(entry.NotAfter, now))
+			}
+		}
+		return writeReport(w, format, report, summary)
+	}
+
+	fmt.Fprintln(w, summary.String())
+	renderManifests(w, entries, expiry, now)
+	if summary.Findings != 0 {
+		renderManifestFindings(w, entries)
+	}
+	return summary.ExitCode()
+}
+
+// displays the certificates of the manifests, one row per certificate
+func renderManifests(w io.Writer, entries []*cert.ManifestEntry, expiry cert.ExpiryConfig, now time.Time) {
+	table := tablewriter.NewWriter(w)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeader([]string{"Resource", "Key", "Depth", "Subject", "Issuer", "Not After", "Days Left", "Status", "Certificate"})
+
+	for _, entry := range entries {
+		for depth, c := range entry.Chain {
+			table.Append([]string{
+				entry.Resource(),
+				entry.Key,
+				fmt.Sprintf("%d", depth),
+				c.Subject.CommonName,
+				c.Issuer.CommonName,
+				c.NotAfter.UTC().Format("2006-01-02 15:04:05"),
+				fmt.Sprintf("%d", cert.DaysLeft(c, now)),
+				expiry.Evaluate(c, now).String(),
+				entry.Certificate,
+			})
+		}
+		if len(entry.Chain) != 0 {
+			continue
+		}
+
+		// a cert-manager Certificate whose Secret is not in the manifests
+		notAfter, daysLeft, status := "", "", "NOT ISSUED"
+		if !entry.NotAfter.IsZero() {
+			notAfter = entry.NotAfter.UTC().Format("2006-01-02 15:04:05")
+			daysLeft = fmt.Sprintf("%d", cert.DaysUntil(entry.NotAfter, now))
+			status = expiry.EvaluateNotAfter(entry.NotAfter, now).String()
+		}
+		table.Append([]string{
+			entry.Resource(),
+			entry.Key,
+			"",
+			strings.Join(entry.DNSNames, "\n"),
+			entry.Issuer,
+			notAfter,
+			daysLeft,
+			status,
+			entry.Certificate,
+		})
+	}
+
+	table.Render()
+}
+
+// displays the findings on the certificates of the manifests, OK findings are omitted
+func renderManifestFindings(w io.Writer, entries []*cert.ManifestEntry) {
+	table := tablewriter.NewWriter(w)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeader([]string{"Resource", "Key", "Depth", "Check", "Finding", "Status", "Message"})
+
+	for _, entry := range entries {
+		for _, finding := range entry.Findings {
+			if finding.Status == cert.StatusOK {
+				continue
+			}
+			depth := ""
+			if finding.Depth >= 0 {
+				depth = fmt.Sprintf("%d", finding.Depth)
+			}
+			table.Append([]string{
+				entry.Resource(),
+				entry.Key,
+				depth,
+				finding.Check,
+				finding.ID,
+				finding.Status.String(),
+				finding.Message,
+			})
+		}
+	}
+
+	table.Render()
+}
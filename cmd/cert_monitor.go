@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Huuancao/sentinel/pkg/cert"
+	"github.com/Huuancao/sentinel/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	certMetricsSocket     = "/var/run/prometheus/sentinel_certs"
+	defaultMonitorRefresh = 3600
+)
+
+var certLabels = []string{
+	"host",
+	"port",
+	"sni",
+	"subject_cn",
+	"issuer",
+	"serial",
+}
+
+var (
+	metricCertNotAfter = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sentinel_cert_not_after_seconds",
+			Help: "Expiry date of the certificate presented by an endpoint, in seconds since epoch",
+		},
+		certLabels,
+	)
+	metricCertNotBefore = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sentinel_cert_not_before_seconds",
+			Help: "Start of validity of the certificate presented by an endpoint, in seconds since epoch",
+		},
+		certLabels,
+	)
+	metricCertChainValid = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sentinel_cert_chain_valid",
+			Help: "Whether the chain presented by an endpoint verifies against the trust store (1) or not (0)",
+		},
+		certLabels,
+	)
+)
+
+var monitorCertCmd = &cobra.Command{
+	Use:   "certMonitor",
+	Short: "Monitor the expiry of the certificates of the configured subnets and domains.",
+	Long: `Monitor the expiry of the certificates of the configured subnets and domains.
+
+The subnets (certs.monitor.subnets) and domains (certs.monitor.domains) are rescanned
+every certs.monitor.refresh seconds and the certificates are exposed as Prometheus metrics.
+They are also recorded in the inventory and notified as the ones of the network checks,
+see sentinel help certs.
+
+When certs.calendar.serve is set, the expiry dates of the inventory are also served as
+an iCalendar feed at certs.calendar.path (/calendar.ics by default), see certCalendar.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		monitorCerts()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(monitorCertCmd)
+
+	prometheus.MustRegister(metricCertNotAfter)
+	prometheus.MustRegister(metricCertNotBefore)
+	prometheus.MustRegister(metricCertChainValid)
+}
+
+func monitorCerts() {
+	logger, err := config.GetLogger(true)
+	if err != nil {
+		fmt.Printf("Could not create logger: %s\n", err)
+		os.Exit(1)
+	}
+
+	monitoredSubnets := viper.GetStringSlice("certs.monitor.subnets")
+	monitoredDomains := viper.GetStringSlice("certs.monitor.domains")
+	if len(monitoredSubnets) == 0 && len(monitoredDomains) == 0 {
+		logger.Fatal("No configured monitored subnets nor domains.")
+		os.Exit(1)
+	}
+
+	scanConfig, err := getScanConfig()
+	if err != nil {
+		logger.Fatalf("cannot create scan config: %s\n", err)
+		os.Exit(1)
+	}
+
+	validator, err := getChainValidator()
+	if err != nil {
+		logger.Fatalf("cannot create chain validator: %s\n", err)
+		os.Exit(1)
+	}
+
+	subnetPorts, err := getSubnetPorts(scanConfig)
+	if err != nil {
+		logger.Fatalf("cannot parse port ranges: %s\n", err)
+		os.Exit(1)
+	}
+
+	refresh := viper.GetInt("certs.monitor.refresh")
+	if refresh <= 0 {
+		refresh = defaultMonitorRefresh
+	}
+	socket := viper.GetString("certs.monitor.socket")
+	if socket == "" {
+		socket = certMetricsSocket
+	}
+
+	ctx := context.Background()
+
+	enforceGracefulShutdown(func(wg *sync.WaitGroup, shutdownChan chan struct{}, errorChan chan error) {
+		wg.Add(1)
+		go scrapeCerts(wg, shutdownChan, logger, scanConfig, validator, monitoredSubnets, subnetPorts, monitoredDomains, time.Duration(refresh)*time.Second)
+		startMetricsServer(wg, shutdownChan, ctx, socket, getMonitorHandler(logger))
+	})
+}
+
+// returns the handler of the metrics socket, serving the calendar feed of the inventory
+// at certs.calendar.path when certs.calendar.serve is set
+func getMonitorHandler(logger *logrus.Logger) http.Handler {
+	if !viper.GetBool("certs.calendar.serve") || viper.GetString("certs.inventory.path") == "" {
+		return promhttp.Handler()
+	}
+	path := viper.GetString("certs.calendar.path")
+	if path == "" {
+		path = defaultCalendarPath
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, calendarHandler(logger))
+	mux.Handle("/", promhttp.Handler())
+	return mux
+}
+
+// periodically rescans the subnets, probed on subnetPorts, and domains and updates the
+// certificate metrics
+func scrapeCerts(wg *sync.WaitGroup, shutdownChan chan struct{}, logger *logrus.Logger, scanConfig cert.ScanConfig, validator *cert.ChainValidator, subnets []string, subnetPorts []int, domains []string, refresh time.Duration) {
+	defer wg.Done()
+
+	// aborts a running scan on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-shutdownChan
+		cancel()
+	}()
+
+	scanner := cert.NewScanner(scanConfig)
+	wait := time.After(0)
+	for {
+		select {
+		case <-wait:
+			results := []cert.Result{}
+			// the subnets and domains are snapshotted in the scopes of certSubnetCheck and
+			// certSubdomainCheck. A failed scan is not snapshotted, the endpoints it missed
+			// would be reported as gone.
+			scopes := map[string][]cert.Result{}
+			if len(subnets) != 0 {
+				subnetResults, err := scanSubnets(ctx, logger, scanner, scanConfig, subnets, "")
+				if err != nil {
+					logger.Errorf("Failed to scan subnets: %s", err)
+				} else {
+					scopes[scanScope(subnets, nil, subnetPorts)] = subnetResults
+				}
+				results = append(results, subnetResults...)
+			}
+			if len(domains) != 0 {
+				domainResults, err := scanDomains(ctx, logger, scanner, scanConfig, domains)
+				if err != nil {
+					logger.Errorf("Failed to scan domains: %s", err)
+				} else {
+					scopes[scanScope(nil, domains, scanConfig.Ports)] = domainResults
+				}
+				results = append(results, domainResults...)
+			}
+			if ctx.Err() == nil {
+				updateCertMetrics(results, validator, time.Now())
+				for scope, scopeResults := range scopes {
+					recordInventory(logger, scope, scopeResults)
+				}
+				logger.Infof("Scanned %d endpoints presenting a certificate", len(results))
+			}
+		case <-shutdownChan:
+			logger.Info("Initiating shutdown of the certificate scraper...")
+			return
+		}
+
+		wait = time.After(refresh)
+	}
+}
+
+// replaces the exposed metrics by the ones of the last scan, endpoints that
+// disappeared are no longer exposed
+func updateCertMetrics(results []cert.Result, validator *cert.ChainValidator, now time.Time) {
+	metricCertNotAfter.Reset()
+	metricCertNotBefore.Reset()
+	metricCertChainValid.Reset()
+
+	for _, result := range results {
+		leaf := result.Leaf()
+		labels := prometheus.Labels{
+			"host":       result.Host,
+			"port":       fmt.Sprintf("%d", result.Port),
+			"sni":        result.ServerName,
+			"subject_cn": leaf.Subject.CommonName,
+			"issuer":     leaf.Issuer.CommonName,
+			"serial":     fmt.Sprintf("%x", leaf.SerialNumber),
+		}
+		metricCertNotAfter.With(labels).Set(float64(leaf.NotAfter.Unix()))
+		metricCertNotBefore.With(labels).Set(float64(leaf.NotBefore.Unix()))
+		valid := 0.0
+		// warnings such as a wrong chain order do not prevent clients from validating the chain
+		if cert.WorstStatus(validator.Check(result, now)) < cert.StatusCritical {
+			valid = 1
+		}
+		metricCertChainValid.With(labels).Set(valid)
+	}
+}
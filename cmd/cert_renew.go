@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/Huuancao/sentinel/pkg/cert"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const defaultRenewTimeout = 300
+
+// a certificate of certs.renew.certificates
+type renewalConfig struct {
+	Domains []string
+	Cert    string
+	Key     string
+	Hook    string
+	// overrides certs.renew.webroot for the HTTP-01 challenges
+	Webroot string
+}
+
+// renews the certificates of certs.renew.certificates found expiring by the scan, when
+// certs.renew.enabled is set. The failures are logged, they do not change the result
+// of the check.
+func renewExpiring(logger *logrus.Logger, results []cert.Result, expiry cert.ExpiryConfig) {
+	if !viper.GetBool("certs.renew.enabled") {
+		return
+	}
+	configs := []renewalConfig{}
+	if err := viper.UnmarshalKey("certs.renew.certificates", &configs); err != nil {
+		logger.Errorf("invalid certs.renew.certificates: %s", err)
+		return
+	}
+	// the targets are identified by their index, several may share a certificate path
+	targets := []cert.RenewalTarget{}
+	expiring := []int{}
+	now := time.Now()
+	for i, config := range configs {
+		target := cert.RenewalTarget{Domains: config.Domains, CertPath: config.Cert, KeyPath: config.Key, Hook: config.Hook}
+		targets = append(targets, target)
+		if target.Expiring(results, expiry, now) {
+			expiring = append(expiring, i)
+		}
+	}
+	if len(expiring) == 0 {
+		return
+	}
+
+	renewer, err := getRenewer()
+	if err != nil {
+		logger.Errorf("cannot create ACME renewer: %s", err)
+		return
+	}
+	timeout := viper.GetInt("certs.renew.timeout")
+	if timeout <= 0 {
+		timeout = defaultRenewTimeout
+	}
+	for _, i := range expiring {
+		target := targets[i]
+		provider, err := getChallengeProvider(configs[i].Webroot)
+		if err != nil {
+			logger.Errorf("cannot renew %v: %s", target.Domains, err)
+			continue
+		}
+		renewer.Provider = provider
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+		err = renewCertificate(ctx, renewer, target)
+		cancel()
+		if err != nil {
+			logger.Errorf("cannot renew %v: %s", target.Domains, err)
+			continue
+		}
+		logger.Infof("Renewed the certificate of %v in %s", target.Domains, target.CertPath)
+	}
+}
+
+// orders the certificate of the target, writes it and runs the hook
+func renewCertificate(ctx context.Context, renewer *cert.Renewer, target cert.RenewalTarget) error {
+	chain, key, err := renewer.Renew(ctx, target.Domains)
+	if err != nil {
+		return err
+	}
+	if err := target.Write(chain, key); err != nil {
+		return errors.Wrapf(err, "cannot write %s", target.CertPath)
+	}
+	return target.RunHook(ctx)
+}
+
+// returns the renewer of the ACME directory of certs.renew.directory, trusted with the
+// system trust store and certs.renew.cabundle, e.g. the CA of a local Pebble
+func getRenewer() (*cert.Renewer, error) {
+	directory := viper.GetString("certs.renew.directory")
+	if directory == "" {
+		return nil, errors.New("no ACME directory configured in certs.renew.directory")
+	}
+	accountKey, err := cert.LoadAccountKey(viper.GetString("certs.renew.accountkey"))
+	if err != nil {
+		return nil, err
+	}
+	renewer := cert.NewRenewer(directory, accountKey, nil, viper.GetStringSlice("certs.renew.contact"))
+
+	client := getHTTPClient()
+	if bundle := viper.GetString("certs.renew.cabundle"); bundle != "" {
+		validator, err := cert.NewChainValidator(true, []string{bundle})
+		if err != nil {
+			return nil, err
+		}
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: validator.Roots},
+		}
+	}
+	renewer.Client.HTTPClient = client
+	return renewer, nil
+}
+
+// returns the challenge provider of certs.renew.challenge, the HTTP-01 responses are
+// written in the given web root, certs.renew.webroot when empty
+func getChallengeProvider(webroot string) (cert.ChallengeProvider, error) {
+	switch challenge := viper.GetString("certs.renew.challenge"); challenge {
+	case "", cert.ChallengeHTTP01:
+		if webroot == "" {
+			webroot = viper.GetString("certs.renew.webroot")
+		}
+		if webroot == "" {
+			return nil, errors.New("no web root configured in certs.renew.webroot for the HTTP-01 challenges")
+		}
+		return &cert.WebrootProvider{Root: webroot}, nil
+	case cert.ChallengeDNS01:
+		timeout := time.Duration(viper.GetInt("certs.resolvertimeout")) * time.Second
+		if command := viper.GetString("certs.renew.dns01.command"); command != "" {
+			return &cert.ExecDNSProvider{Command: command}, nil
+		}
+		if nameserver := viper.GetString("certs.renew.dns01.rfc2136.nameserver"); nameserver != "" {
+			return &cert.RFC2136Provider{
+				Nameserver:    nameserver,
+				Zone:          viper.GetString("certs.renew.dns01.rfc2136.zone"),
+				TSIGKey:       viper.GetString("certs.renew.dns01.rfc2136.tsigkey"),
+				TSIGAlgorithm: viper.GetString("certs.renew.dns01.rfc2136.tsigalgorithm"),
+				TSIGSecret:    viper.GetString("certs.renew.dns01.rfc2136.tsigsecret"),
+				Timeout:       timeout,
+			}, nil
+		}
+		return nil, errors.New("no DNS-01 provider configured in certs.renew.dns01")
+	default:
+		return nil, errors.Errorf("unknown challenge %s, expected http-01 or dns-01", challenge)
+	}
+}
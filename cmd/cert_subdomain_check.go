@@ -1,11 +1,17 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/Huuancao/sentinel/pkg/cert"
 	"github.com/Huuancao/sentinel/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var (
@@ -17,6 +23,17 @@ var checkSubdomainCertCmd = &cobra.Command{
 	Short: "Check the validity of all certificates of all sub-domains of a given domain.",
 	Long: `Check the validity of all certificates of all sub-domains of a given domain.
 
+The sub-domains are enumerated with the configured sources (certs.subdomains.sources),
+resolved and probed on the configured ports with the hostname sent as SNI.
+
+The compliance of every endpoint with the DNS is checked as configured in
+certs.compliance: the CA must be authorized by the CAA records of the names of the
+leaf, the chain must match the TLSA records of the port (DANE) when there are some,
+and the leaf must embed enough valid SCTs, verified against the CT logs of
+certs.compliance.sct.logs. The records are queried from certs.resolver.
+
+See sentinel help certs for the behaviours shared by the certificate commands.
+
 You may provide multiple domains.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		checkSubdomainCert()
@@ -26,7 +43,126 @@ You may provide multiple domains.`,
 func init() {
 	RootCmd.AddCommand(checkSubdomainCertCmd)
 	//Flags
+	checkSubdomainCertCmd.Flags().StringVarP(&outputFlag, "output", "", "", "Output format: table, json, ndjson, csv, html or junit (default certs.output)")
 	checkSubdomainCertCmd.Flags().StringSliceVarP(&domains, "domains", "", []string{}, "Domains to scan for certificates")
+	checkSubdomainCertCmd.Flags().IntSliceVarP(&portsFlag, "ports", "", []int{}, "Ports to probe on every host (default certs.ports)")
+}
+
+// returns the configured sub-domain sources
+func getSubdomainSources(scanner *cert.Scanner, scanConfig cert.ScanConfig, resolver cert.HostResolver) ([]cert.SubdomainSource, error) {
+	var err error
+	dnsTimeout := time.Duration(viper.GetInt("certs.resolvertimeout")) * time.Second
+	names := viper.GetStringSlice("certs.subdomains.sources")
+	if len(names) == 0 {
+		names = []string{"list", "san"}
+	}
+
+	sources := []cert.SubdomainSource{}
+	for _, name := range names {
+		switch name {
+		case "list":
+			sources = append(sources, &cert.ListSource{Hosts: viper.GetStringSlice("certs.subdomains.hosts")})
+		case "san":
+			sources = append(sources, &cert.SANSource{
+				Scanner:  scanner,
+				Ports:    scanConfig.Ports,
+				Prefixes: viper.GetStringSlice("certs.subdomains.san.prefixes"),
+			})
+		case "crtsh":
+			sources = append(sources, &cert.CrtShSource{
+				BaseURL:        viper.GetString("certs.subdomains.crtsh.url"),
+				ExcludeExpired: viper.GetBool("certs.subdomains.crtsh.excludeexpired"),
+				Client:         getHTTPClient(),
+			})
+		case "ctlog":
+			logURLs := viper.GetStringSlice("certs.subdomains.ctlog.urls")
+			if len(logURLs) == 0 {
+				return nil, errors.New("the ctlog source requires the URLs of certs.subdomains.ctlog.urls")
+			}
+			sources = append(sources, &cert.CTLogSource{
+				LogURLs:   logURLs,
+				Entries:   viper.GetInt64("certs.subdomains.ctlog.entries"),
+				BatchSize: viper.GetInt64("certs.subdomains.ctlog.batchsize"),
+				Client:    getHTTPClient(),
+			})
+		case "bruteforce":
+			words := cert.DefaultWordlist
+			if path := viper.GetString("certs.subdomains.bruteforce.wordlist"); path != "" {
+				words, err = cert.ReadWordlist(path)
+				if err != nil {
+					return nil, err
+				}
+			}
+			sources = append(sources, &cert.BruteForceSource{
+				Resolver: resolver,
+				Words:    words,
+				Workers:  viper.GetInt("certs.subdomains.bruteforce.workers"),
+			})
+		case "axfr":
+			dnsResolver, ok := resolver.(*cert.DNSResolver)
+			if !ok {
+				dnsResolver, err = cert.NewDNSResolver("", dnsTimeout)
+				if err != nil {
+					return nil, err
+				}
+			}
+			sources = append(sources, &cert.AXFRSource{
+				Resolver: dnsResolver,
+				Port:     viper.GetString("certs.subdomains.axfr.port"),
+				Timeout:  dnsTimeout,
+			})
+		default:
+			return nil, errors.Errorf("unknown sub-domain source %s", name)
+		}
+	}
+	return sources, nil
+}
+
+// returns the CAA, TLSA and SCT checkers enabled in certs.compliance, the DNS records
+// are queried from certs.resolver or the first nameserver of /etc/resolv.conf
+func getComplianceCheckers() ([]cert.Checker, error) {
+	enabled := func(check string) bool {
+		key := "certs.compliance." + check + ".enabled"
+		return !viper.IsSet(key) || viper.GetBool(key)
+	}
+	checkers := []cert.Checker{}
+	if !enabled("caa") && !enabled("tlsa") && !enabled("sct") {
+		return checkers, nil
+	}
+
+	resolver, err := cert.NewDNSResolver(viper.GetString("certs.resolver"), time.Duration(viper.GetInt("certs.resolvertimeout"))*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if enabled("caa") {
+		identities := map[string][]string{}
+		if err := viper.UnmarshalKey("certs.compliance.caa.identities", &identities); err != nil {
+			return nil, errors.Wrap(err, "invalid certs.compliance.caa.identities")
+		}
+		checkers = append(checkers, cert.NewCAAChecker(resolver, identities))
+	}
+	if enabled("tlsa") {
+		checkers = append(checkers, &cert.TLSAChecker{Resolver: resolver})
+	}
+	if enabled("sct") {
+		configured := []struct {
+			Name string
+			Key  string
+		}{}
+		if err := viper.UnmarshalKey("certs.compliance.sct.logs", &configured); err != nil {
+			return nil, errors.Wrap(err, "invalid certs.compliance.sct.logs")
+		}
+		logs := []*cert.CTLog{}
+		for _, log := range configured {
+			ctLog, err := cert.ParseCTLog(log.Name, log.Key)
+			if err != nil {
+				return nil, err
+			}
+			logs = append(logs, ctLog)
+		}
+		checkers = append(checkers, cert.NewSCTChecker(logs, viper.GetInt("certs.compliance.sct.minscts")))
+	}
+	return checkers, nil
 }
 
 func checkSubdomainCert() {
@@ -41,4 +177,73 @@ func checkSubdomainCert() {
 		os.Exit(1)
 	}
 	logger.Debugf("Provided domains: %v", domains)
+
+	scanConfig, err := getScanConfig()
+	if err != nil {
+		logger.Fatalf("cannot create scan config: %s\n", err)
+		os.Exit(1)
+	}
+
+	format, err := getOutputFormat()
+	if err != nil {
+		logger.Fatalf("cannot get output format: %s\n", err)
+		os.Exit(1)
+	}
+
+	expiryConfig, err := getExpiryConfig()
+	if err != nil {
+		logger.Fatalf("cannot create expiry config: %s\n", err)
+		os.Exit(1)
+	}
+
+	checkers, err := getCheckers()
+	if err != nil {
+		logger.Fatalf("cannot create certificate checkers: %s\n", err)
+		os.Exit(1)
+	}
+	complianceCheckers, err := getComplianceCheckers()
+	if err != nil {
+		logger.Fatalf("cannot create compliance checkers: %s\n", err)
+		os.Exit(1)
+	}
+	checkers = append(checkers, complianceCheckers...)
+
+	results, err := scanDomains(context.Background(), logger, cert.NewScanner(scanConfig), scanConfig, domains)
+	if err != nil {
+		logger.Fatalf("cannot scan domains: %s\n", err)
+		os.Exit(1)
+	}
+	sortResults(results)
+	recordInventory(logger, scanScope(nil, domains, scanConfig.Ports), results)
+	exitCode := reportResults(os.Stdout, format, results, expiryConfig, checkers)
+	renewExpiring(logger, results, expiryConfig)
+	os.Exit(exitCode)
+}
+
+// discovers the sub-domains of the domains and retrieves the certificates of all of them
+func scanDomains(ctx context.Context, logger *logrus.Logger, scanner *cert.Scanner, scanConfig cert.ScanConfig, domains []string) ([]cert.Result, error) {
+	resolver, err := getResolver()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create DNS resolver")
+	}
+
+	sources, err := getSubdomainSources(scanner, scanConfig, resolver)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create sub-domain sources")
+	}
+
+	hosts, errs := cert.DiscoverSubdomains(ctx, sources, domains)
+	for _, err := range errs {
+		logger.Errorf("%s", err)
+	}
+	logger.Debugf("Discovered hosts: %v", hosts)
+
+	resolved := cert.ResolveHosts(ctx, resolver, hosts, scanConfig.Workers)
+	for _, host := range hosts {
+		if _, ok := resolved[host]; !ok {
+			logger.Debugf("Cannot resolve %s", host)
+		}
+	}
+
+	return collectResults(logger, scanner.Run(ctx, cert.HostTargets(ctx, resolved, scanConfig.Ports))), nil
 }
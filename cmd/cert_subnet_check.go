@@ -1,22 +1,64 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/Huuancao/sentinel/pkg/cert"
 	"github.com/Huuancao/sentinel/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var (
-	subnets []string
+	subnets           []string
+	hostnamesFlag     []string
+	hostnamesFileFlag string
+	ptrFlag           bool
+	checkpointFlag    string
+	restartFlag       bool
 )
 
+const defaultCheckpointBatch = 256
+
 var checkSubnetCertCmd = &cobra.Command{
 	Use:   "certSubnetCheck",
 	Short: "Check the validity of all certificates in a given subnet.",
 	Long: `Check the validity of all certificates in a given subnet.
 
+Every host of the given subnets (IPv4 or IPv6) is probed on the configured ports,
+the full certificate chain of every endpoint answering a TLS handshake is retrieved.
+
+Every endpoint answering a handshake without SNI, whose certificate is the default one,
+is probed again once per candidate virtual host name: the names given with --hostnames,
+--hostnames-file or certs.vhosts and, with --ptr or certs.vhosts.ptr, the PTR names of
+its address. The SNI column records which certificate each name yields, the names for
+which the endpoint falls back to a default certificate not covering them are marked
+as fallbacks.
+
+With --port-ranges or certs.discovery.ranges, e.g. 1-1024,8000-8999, a fast TCP connect
+scan finds the open ports of the ranges first, with the concurrency, connection rate and
+timeout of certs.discovery, and only the open ports are probed for TLS. The endpoints
+speaking TLS on a non-standard port are logged, the ones speaking another protocol
+only in verbose mode.
+
+The connections are paced by the global and per host rates of certs.ratelimit, with
+a random delay of up to certs.ratelimit.jitter. The CIDRs, addresses and host names of
+--exclude and certs.exclude are never probed.
+
+The progress of the scan is saved in the checkpoint file (--checkpoint or
+certs.checkpoint.path) after every batch of certs.checkpoint.batch hosts. A scan of the
+same subnets and ports interrupted, e.g. by SIGTERM, resumes after the last completed
+batch when rerun, --restart discards the saved progress.
+
+See sentinel help certs for the behaviours shared by the certificate commands.
+
 You may provide multiple subnets.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		checkSubnetCert()
@@ -26,7 +68,16 @@ You may provide multiple subnets.`,
 func init() {
 	RootCmd.AddCommand(checkSubnetCertCmd)
 	//Flags
+	checkSubnetCertCmd.Flags().StringVarP(&outputFlag, "output", "", "", "Output format: table, json, ndjson, csv, html or junit (default certs.output)")
 	checkSubnetCertCmd.Flags().StringSliceVarP(&subnets, "subnets", "", []string{}, "Subnets to scan for certificates")
+	checkSubnetCertCmd.Flags().IntSliceVarP(&portsFlag, "ports", "", []int{}, "Ports to probe on every host (default certs.ports)")
+	checkSubnetCertCmd.Flags().StringSliceVarP(&portRangesFlag, "port-ranges", "", []string{}, "Port ranges to discover with a TCP connect scan, e.g. 1-1024,8000-8999 (default certs.discovery.ranges)")
+	checkSubnetCertCmd.Flags().StringSliceVarP(&excludeFlag, "exclude", "", []string{}, "CIDRs, addresses and host names never probed, added to certs.exclude")
+	checkSubnetCertCmd.Flags().StringVarP(&checkpointFlag, "checkpoint", "", "", "File saving the progress of the scan to resume it (default certs.checkpoint.path)")
+	checkSubnetCertCmd.Flags().BoolVarP(&restartFlag, "restart", "", false, "Discard the saved progress and scan all the hosts again")
+	checkSubnetCertCmd.Flags().StringSliceVarP(&hostnamesFlag, "hostnames", "", []string{}, "Virtual host names sent as SNI to every endpoint (default certs.vhosts.names)")
+	checkSubnetCertCmd.Flags().StringVarP(&hostnamesFileFlag, "hostnames-file", "", "", "File of virtual host names, one per line (default certs.vhosts.file)")
+	checkSubnetCertCmd.Flags().BoolVarP(&ptrFlag, "ptr", "", false, "Also send the PTR names of each address as SNI (default certs.vhosts.ptr)")
 }
 
 func checkSubnetCert() {
@@ -41,4 +92,192 @@ func checkSubnetCert() {
 		os.Exit(1)
 	}
 	logger.Debugf("Provided subnets: %v", subnets)
+
+	scanConfig, err := getScanConfig()
+	if err != nil {
+		logger.Fatalf("cannot create scan config: %s\n", err)
+		os.Exit(1)
+	}
+
+	format, err := getOutputFormat()
+	if err != nil {
+		logger.Fatalf("cannot get output format: %s\n", err)
+		os.Exit(1)
+	}
+
+	expiryConfig, err := getExpiryConfig()
+	if err != nil {
+		logger.Fatalf("cannot create expiry config: %s\n", err)
+		os.Exit(1)
+	}
+
+	checkers, err := getCheckers()
+	if err != nil {
+		logger.Fatalf("cannot create certificate checkers: %s\n", err)
+		os.Exit(1)
+	}
+
+	ports, err := getSubnetPorts(scanConfig)
+	if err != nil {
+		logger.Fatalf("cannot parse port ranges: %s\n", err)
+		os.Exit(1)
+	}
+
+	checkpointPath := checkpointFlag
+	if checkpointPath == "" {
+		checkpointPath = viper.GetString("certs.checkpoint.path")
+	}
+	if restartFlag && checkpointPath != "" {
+		if err := cert.RemoveCheckpoint(checkpointPath); err != nil {
+			logger.Fatalf("cannot restart the scan: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// the progress is saved after each batch, an interrupted scan is resumed by the next run
+	ctx, cancel := context.WithCancel(context.Background())
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-signalChan
+		logger.Warn("Interrupting the scan...")
+		cancel()
+	}()
+
+	results, err := scanSubnets(ctx, logger, cert.NewScanner(scanConfig), scanConfig, subnets, checkpointPath)
+	if err != nil {
+		logger.Fatalf("cannot scan subnets: %s\n", err)
+		os.Exit(1)
+	}
+	if ctx.Err() != nil {
+		fmt.Println("CERTS UNKNOWN - scan interrupted, rerun to resume | certificates=0")
+		os.Exit(cert.ExitUnknown)
+	}
+	sortResults(results)
+	recordInventory(logger, scanScope(subnets, nil, ports), results)
+	exitCode := reportResults(os.Stdout, format, results, expiryConfig, checkers)
+	renewExpiring(logger, results, expiryConfig)
+	os.Exit(exitCode)
+}
+
+// retrieves the certificates of every host of the subnets, then the ones returned
+// for each candidate virtual host name. When port ranges are configured, only the
+// ports found open by a TCP connect scan are probed.
+// With a checkpoint file, the hosts are scanned by batches (certs.checkpoint.batch) and
+// the progress saved after each one, a scan of the same scope resumes after the last
+// completed batch. The file is removed once the scan is over.
+func scanSubnets(ctx context.Context, logger *logrus.Logger, scanner *cert.Scanner, scanConfig cert.ScanConfig, subnets []string, checkpointPath string) ([]cert.Result, error) {
+	parsedSubnets, err := cert.ParseSubnets(subnets)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse subnets")
+	}
+	vhosts, err := getVirtualHosts()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get virtual host names")
+	}
+	portScanner, ports, err := getPortScanner(scanConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse port ranges")
+	}
+	if portScanner == nil {
+		ports = scanConfig.Ports
+		logger.Debugf("Probing ports %v with %d workers", scanConfig.Ports, scanConfig.Workers)
+	} else {
+		logger.Debugf("Scanning ports %s with %d workers", cert.FormatPortRanges(ports), portScanner.Workers)
+	}
+
+	total := uint64(0)
+	for _, subnet := range parsedSubnets {
+		total += cert.SubnetHostCount(subnet)
+	}
+	batch := total
+	checkpoint := &cert.Checkpoint{}
+	if checkpointPath != "" {
+		if size := viper.GetInt("certs.checkpoint.batch"); size > 0 {
+			batch = uint64(size)
+		} else {
+			batch = defaultCheckpointBatch
+		}
+		checkpoint, err = cert.LoadCheckpoint(checkpointPath, scanScope(subnets, nil, ports))
+		if err != nil {
+			return nil, err
+		}
+		if checkpoint.Hosts != 0 {
+			logger.Infof("Resuming the scan after %d of %d hosts, checkpoint of %s", checkpoint.Hosts, total,
+				checkpoint.UpdatedAt.Format(time.RFC3339))
+		}
+	}
+	results, err := checkpoint.Restore()
+	if err != nil {
+		return nil, err
+	}
+
+	for first := checkpoint.Hosts; first < total && ctx.Err() == nil; first += batch {
+		targets := cert.SubnetBatchTargets(ctx, parsedSubnets, ports, first, batch)
+		if portScanner != nil {
+			targets = portScanner.Run(ctx, targets)
+		}
+		batchResults := collectResults(logger, scanner.Run(ctx, targets))
+		for _, result := range batchResults {
+			if portScanner != nil && !cert.IsStandardTLSPort(result.Port, scanConfig.StartTLS) {
+				logger.Infof("TLS found on non-standard port %s", result.Address())
+			}
+		}
+		if vhosts != nil {
+			logger.Debugf("Probing %d endpoints with the virtual host names", len(batchResults))
+			vhostResults := collectResults(logger, scanner.Run(ctx, vhosts.Targets(ctx, batchResults)))
+			batchResults = append(batchResults, cert.VirtualHostResults(batchResults, vhostResults)...)
+		}
+		// an interrupted batch is scanned again on resume
+		if ctx.Err() != nil {
+			break
+		}
+		results = append(results, batchResults...)
+
+		if checkpointPath != "" && first+batch < total {
+			checkpoint.Add(batch, batchResults)
+			if err := checkpoint.Save(checkpointPath, time.Now()); err != nil {
+				return results, err
+			}
+		}
+	}
+	if checkpointPath != "" && ctx.Err() == nil {
+		return results, cert.RemoveCheckpoint(checkpointPath)
+	}
+	return results, nil
+}
+
+// returns the candidate virtual host names of the subnet endpoints, nil when neither
+// names (flags, certs.vhosts.names and certs.vhosts.file) nor PTR lookups are configured
+func getVirtualHosts() (*cert.VirtualHosts, error) {
+	names := hostnamesFlag
+	if len(names) == 0 {
+		names = viper.GetStringSlice("certs.vhosts.names")
+	}
+	file := hostnamesFileFlag
+	if file == "" {
+		file = viper.GetString("certs.vhosts.file")
+	}
+	if file != "" {
+		fileNames, err := cert.ReadWordlist(file)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, fileNames...)
+	}
+
+	vhosts := &cert.VirtualHosts{Names: names}
+	if ptrFlag || viper.GetBool("certs.vhosts.ptr") {
+		resolver, err := getResolver()
+		if err != nil {
+			return nil, err
+		}
+		if addrResolver, ok := resolver.(cert.AddrResolver); ok {
+			vhosts.Resolver = addrResolver
+		}
+	}
+	if len(vhosts.Names) == 0 && vhosts.Resolver == nil {
+		return nil, nil
+	}
+	return vhosts, nil
 }
@@ -94,13 +94,18 @@ func monitorConsumerLag() {
 
 	enforceGracefulShutdown(func(wg *sync.WaitGroup, shutdownChan chan struct{}, errorChan chan error) {
 		config.StartKafkaScraper(wg, shutdownChan, errorChan, client, ca, metricConsumerOffset, scrapeConfig)
-		startPrometheus(wg, shutdownChan, ctx)
+		startPrometheus(wg, shutdownChan, ctx, metricsSocket)
 	})
 
 }
 
-// starts the Prometheus server to expose the metrics
-func startPrometheus(wg *sync.WaitGroup, shutdownChan chan struct{}, c context.Context) {
+// starts the Prometheus server to expose the metrics on the given unix socket
+func startPrometheus(wg *sync.WaitGroup, shutdownChan chan struct{}, c context.Context, socket string) {
+	startMetricsServer(wg, shutdownChan, c, socket, promhttp.Handler())
+}
+
+// starts the HTTP server of the handler on the given unix socket
+func startMetricsServer(wg *sync.WaitGroup, shutdownChan chan struct{}, c context.Context, socket string, handler http.Handler) {
 	logger, err := config.GetLogger(true)
 	if err != nil {
 		fmt.Printf("Could not create logger: %s\n", err)
@@ -112,13 +117,13 @@ func startPrometheus(wg *sync.WaitGroup, shutdownChan chan struct{}, c context.C
 
 	srv := &http.Server{
 		Addr:    "/metrics",
-		Handler: promhttp.Handler(),
+		Handler: handler,
 	}
 
 	wg.Add(1)
 	defer wg.Done()
 
-	listener, errListen := net.Listen("unix", metricsSocket)
+	listener, errListen := net.Listen("unix", socket)
 	if errListen != nil {
 		logger.Errorf("Failed to initialize metrics socket: %s", errListen.Error())
 		os.Exit(1)
@@ -134,7 +139,7 @@ func startPrometheus(wg *sync.WaitGroup, shutdownChan chan struct{}, c context.C
 	logger.Info("Shutting down metrics server...")
 	srv.Shutdown(ctx)
 	listener.Close()
-	os.Remove(metricsSocket)
+	os.Remove(socket)
 }
 
 // monitors and propagates shutdown signals
@@ -146,7 +151,7 @@ func enforceGracefulShutdown(f func(wg *sync.WaitGroup, shutdownChan chan struct
 	}
 	wg := &sync.WaitGroup{}
 	shutdownChan := make(chan struct{})
-	signalChan := make(chan os.Signal)
+	signalChan := make(chan os.Signal, 1)
 	errorChan := make(chan error, 1)
 	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
 	go func() {
@@ -162,6 +167,6 @@ func enforceGracefulShutdown(f func(wg *sync.WaitGroup, shutdownChan chan struct
 	f(wg, shutdownChan, errorChan)
 
 	<-shutdownChan
-	logger.Info("Initiating shutdown of the consumer lag monitoring...")
+	logger.Info("Initiating shutdown of the monitoring...")
 	wg.Wait()
 }
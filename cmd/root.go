@@ -54,5 +54,5 @@ func initConfig() {
 		fmt.Println("Cannot read config:", err)
 		os.Exit(1)
 	}
-	// Later we might add some configuration file to store the results in a DB
+	// the harvested certificates are stored in the inventory, see certs.inventory
 }
@@ -0,0 +1,169 @@
+package cert
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// a group of endpoints sharing a certificate
+	BlastRadiusCertificate = "certificate"
+	// a group of endpoints sharing a private key through several certificates
+	BlastRadiusKey = "key"
+
+	defaultBlastRadiusMinEndpoints = 2
+	defaultBroadWildcardHosts      = 5
+)
+
+// selects the groups of the blast radius report, the zero value gets the defaults
+type BlastRadiusOptions struct {
+	// minimum number of endpoints sharing a certificate or key, 2 by default
+	MinEndpoints int
+	// number of hosts from which a wildcard certificate is deployed broadly, 5 by default
+	BroadWildcardHosts int
+	// endpoints last seen before this time are ignored
+	SeenSince time.Time
+	// groups expiring before this time only
+	ExpiresBefore time.Time
+}
+
+// endpoints that must be touched when a certificate expires or its key is compromised
+type BlastRadiusGroup struct {
+	// BlastRadiusCertificate or BlastRadiusKey
+	Kind string `json:"kind"`
+	// fingerprint of the certificate or SHA-256 hash of the public key (SPKI)
+	ID       string `json:"id"`
+	KeyType  string `json:"keyType"`
+	Wildcard bool   `json:"wildcard"`
+	// a wildcard certificate presented by many hosts
+	BroadWildcard bool     `json:"broadWildcard"`
+	Subjects      []string `json:"subjects"`
+	SANs          []string `json:"sans"`
+	// fingerprints of the certificates of the group
+	Certificates []string `json:"certificates"`
+	// first expiry of the certificates of the group
+	NotAfter  time.Time           `json:"notAfter"`
+	Hosts     []string            `json:"hosts"`
+	Endpoints []InventoryEndpoint `json:"endpoints"`
+}
+
+// returns the SHA-256 hash of the public key (SPKI) of the certificate
+func SPKIHash(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+// groups the leaf certificates of the inventory by fingerprint and by public key and
+// returns the groups shared by several endpoints, the broad wildcards first then by
+// decreasing number of endpoints. A key is only reported when it is shared by several
+// certificates, a certificate group already covers the other ones.
+func BlastRadius(records []*InventoryRecord, options BlastRadiusOptions) ([]*BlastRadiusGroup, error) {
+	if options.MinEndpoints <= 0 {
+		options.MinEndpoints = defaultBlastRadiusMinEndpoints
+	}
+	if options.BroadWildcardHosts <= 0 {
+		options.BroadWildcardHosts = defaultBroadWildcardHosts
+	}
+
+	groups := []*BlastRadiusGroup{}
+	keys := map[string]*BlastRadiusGroup{}
+	keyOrder := []string{}
+	for _, record := range records {
+		endpoints := []InventoryEndpoint{}
+		for _, endpoint := range record.Endpoints {
+			// the CAs shared by every endpoint are not part of the blast radius
+			if endpoint.Depth == 0 && !endpoint.LastSeen.Before(options.SeenSince) {
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+		if len(endpoints) == 0 {
+			continue
+		}
+		c, err := x509.ParseCertificate(record.Raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse certificate %s", record.Fingerprint)
+		}
+
+		group := &BlastRadiusGroup{Kind: BlastRadiusCertificate, ID: record.Fingerprint, KeyType: KeyType(c)}
+		group.add(record, endpoints)
+		groups = append(groups, group)
+
+		spki := SPKIHash(c)
+		key, ok := keys[spki]
+		if !ok {
+			key = &BlastRadiusGroup{Kind: BlastRadiusKey, ID: spki, KeyType: KeyType(c)}
+			keys[spki] = key
+			keyOrder = append(keyOrder, spki)
+		}
+		key.add(record, endpoints)
+	}
+	for _, spki := range keyOrder {
+		if len(keys[spki].Certificates) > 1 {
+			groups = append(groups, keys[spki])
+		}
+	}
+
+	shared := []*BlastRadiusGroup{}
+	for _, group := range groups {
+		if len(group.Endpoints) < options.MinEndpoints {
+			continue
+		}
+		if !options.ExpiresBefore.IsZero() && !group.NotAfter.Before(options.ExpiresBefore) {
+			continue
+		}
+		group.BroadWildcard = group.Wildcard && len(group.Hosts) >= options.BroadWildcardHosts
+		shared = append(shared, group)
+	}
+	sort.SliceStable(shared, func(a int, b int) bool {
+		if shared[a].BroadWildcard != shared[b].BroadWildcard {
+			return shared[a].BroadWildcard
+		}
+		if len(shared[a].Endpoints) != len(shared[b].Endpoints) {
+			return len(shared[a].Endpoints) > len(shared[b].Endpoints)
+		}
+		return shared[a].NotAfter.Before(shared[b].NotAfter)
+	})
+	return shared, nil
+}
+
+// adds the certificate of the record and the endpoints presenting it to the group
+func (g *BlastRadiusGroup) add(record *InventoryRecord, endpoints []InventoryEndpoint) {
+	g.Certificates = append(g.Certificates, record.Fingerprint)
+	g.Subjects = appendMissing(g.Subjects, record.Subject)
+	for _, san := range record.SANs {
+		g.SANs = appendMissing(g.SANs, san)
+		if strings.HasPrefix(san, "*.") {
+			g.Wildcard = true
+		}
+	}
+	if g.NotAfter.IsZero() || record.NotAfter.Before(g.NotAfter) {
+		g.NotAfter = record.NotAfter
+	}
+	for _, endpoint := range endpoints {
+		g.Hosts = appendMissing(g.Hosts, endpoint.Host)
+		// a renewed certificate reusing its key is presented by the same endpoints
+		known := false
+		for j := range g.Endpoints {
+			existing := &g.Endpoints[j]
+			if existing.Host == endpoint.Host && existing.Port == endpoint.Port && existing.ServerName == endpoint.ServerName {
+				known = true
+				if endpoint.LastSeen.After(existing.LastSeen) {
+					*existing = endpoint
+				}
+			}
+		}
+		if !known {
+			g.Endpoints = append(g.Endpoints, endpoint)
+		}
+	}
+	sort.Slice(g.Endpoints, func(a int, b int) bool {
+		return g.Endpoints[a].String() < g.Endpoints[b].String()
+	})
+	sort.Strings(g.Hosts)
+}
@@ -0,0 +1,86 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_BlastRadius(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	wildcard := newTestLeaf(t, ca, "*.example.com")
+	api := newTestLeaf(t, ca, "api.example.com")
+	single := newTestLeaf(t, ca, "single.example.com")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	old := newTestCertWithKey(t, &x509.Certificate{Subject: pkix.Name{CommonName: "shop.example.com"}, DNSNames: []string{"shop.example.com"}, NotAfter: time.Now().Add(24 * time.Hour)}, ca, key)
+	renewed := newTestCertWithKey(t, &x509.Certificate{Subject: pkix.Name{CommonName: "shop.example.com"}, DNSNames: []string{"shop.example.com"}}, ca, key)
+	require.Equal(t, SPKIHash(old.cert), SPKIHash(renewed.cert))
+
+	now := time.Now()
+	results := []Result{
+		{Target: Target{Host: "192.0.2.30", Port: 443}, Chain: []*x509.Certificate{api.cert, ca.cert}},
+		{Target: Target{Host: "192.0.2.31", Port: 443}, Chain: []*x509.Certificate{api.cert, ca.cert}},
+		{Target: Target{Host: "192.0.2.40", Port: 443}, Chain: []*x509.Certificate{single.cert, ca.cert}},
+		{Target: Target{Host: "192.0.2.50", Port: 443}, Chain: []*x509.Certificate{old.cert, ca.cert}},
+		{Target: Target{Host: "192.0.2.51", Port: 443}, Chain: []*x509.Certificate{renewed.cert, ca.cert}},
+	}
+	for i := 0; i < 3; i++ {
+		results = append(results, Result{Target: Target{Host: fmt.Sprintf("192.0.2.%d", 10+i), Port: 443, ServerName: "www.example.com"}, Chain: []*x509.Certificate{wildcard.cert, ca.cert}})
+	}
+	inventory, err := OpenInventory(tempDir(t) + "/inventory.db")
+	require.Nil(t, err)
+	defer inventory.Close()
+	require.Nil(t, inventory.Record(results, now.Add(-48*time.Hour)))
+	// the wildcard is also presented on another port and the renewed certificate by the old endpoint
+	require.Nil(t, inventory.Record([]Result{
+		{Target: Target{Host: "192.0.2.10", Port: 8443}, Chain: []*x509.Certificate{wildcard.cert, ca.cert}},
+		{Target: Target{Host: "192.0.2.50", Port: 443}, Chain: []*x509.Certificate{renewed.cert, ca.cert}},
+	}, now))
+	records, err := inventory.List(InventoryFilter{})
+	require.Nil(t, err)
+
+	groups, err := BlastRadius(records, BlastRadiusOptions{BroadWildcardHosts: 3})
+	require.Nil(t, err)
+	require.Len(t, groups, 4)
+
+	require.Equal(t, BlastRadiusCertificate, groups[0].Kind)
+	require.Equal(t, Fingerprint(wildcard.cert), groups[0].ID)
+	require.True(t, groups[0].Wildcard)
+	require.True(t, groups[0].BroadWildcard)
+	require.Equal(t, []string{"192.0.2.10", "192.0.2.11", "192.0.2.12"}, groups[0].Hosts)
+	require.Len(t, groups[0].Endpoints, 4)
+	require.Equal(t, "ECDSA P-256", groups[0].KeyType)
+
+	// the key shared by both shop certificates, its endpoint presenting both is counted once
+	require.Equal(t, BlastRadiusKey, groups[1].Kind)
+	require.Equal(t, SPKIHash(old.cert), groups[1].ID)
+	require.ElementsMatch(t, []string{Fingerprint(old.cert), Fingerprint(renewed.cert)}, groups[1].Certificates)
+	require.Len(t, groups[1].Endpoints, 2)
+	require.True(t, old.cert.NotAfter.Equal(groups[1].NotAfter))
+	require.False(t, groups[1].Wildcard)
+
+	kinds := []string{}
+	for _, group := range groups[2:] {
+		kinds = append(kinds, group.Kind+" "+group.SANs[0])
+	}
+	// the old shop certificate is only presented by a single endpoint
+	require.ElementsMatch(t, []string{"certificate api.example.com", "certificate shop.example.com"}, kinds)
+
+	// only the endpoints still presenting the certificates
+	groups, err = BlastRadius(records, BlastRadiusOptions{SeenSince: now.Add(-time.Hour)})
+	require.Nil(t, err)
+	require.Empty(t, groups)
+
+	groups, err = BlastRadius(records, BlastRadiusOptions{MinEndpoints: 1, ExpiresBefore: now.Add(48 * time.Hour)})
+	require.Nil(t, err)
+	require.Len(t, groups, 2)
+	require.Equal(t, Fingerprint(old.cert), groups[1].ID)
+}
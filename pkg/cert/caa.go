@@ -0,0 +1,206 @@
+package cert
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+const caaCheck = "caa"
+
+// CAA issuer domains of the public CAs, by lowercase substring of the organization or
+// common name of the issuers of the chain
+var DefaultCAAIdentities = map[string][]string{
+	"let's encrypt":         {"letsencrypt.org"},
+	"digicert":              {"digicert.com", "symantec.com", "geotrust.com", "rapidssl.com", "thawte.com"},
+	"sectigo":               {"sectigo.com", "comodoca.com", "comodo.com"},
+	"comodo":                {"sectigo.com", "comodoca.com", "comodo.com"},
+	"globalsign":            {"globalsign.com"},
+	"google trust services": {"pki.goog"},
+	"amazon":                {"amazon.com", "amazontrust.com", "awstrust.com", "amazonaws.com"},
+	"entrust":               {"entrust.net"},
+	"godaddy":               {"godaddy.com", "starfieldtech.com"},
+	"starfield":             {"godaddy.com", "starfieldtech.com"},
+	"zerossl":               {"sectigo.com", "zerossl.com"},
+	"buypass":               {"buypass.com", "buypass.no"},
+	"microsoft":             {"microsoft.com"},
+}
+
+// the relevant CAA record set of a name and the name holding it
+type caaSet struct {
+	Domain  string
+	Records []*dns.CAA
+	err     error
+}
+
+// flags the certificates issued by a CA that the CAA records of their names do not authorize
+type CAAChecker struct {
+	Resolver *DNSResolver
+	// CAA issuer domains by issuer organization, see DefaultCAAIdentities
+	Identities map[string][]string
+
+	lock  sync.Mutex
+	cache map[string]*caaSet
+}
+
+// creates a CAA checker, the configured identities are added to the default ones
+func NewCAAChecker(resolver *DNSResolver, identities map[string][]string) *CAAChecker {
+	merged := map[string][]string{}
+	for issuer, domains := range DefaultCAAIdentities {
+		merged[issuer] = domains
+	}
+	for issuer, domains := range identities {
+		merged[strings.ToLower(issuer)] = domains
+	}
+	return &CAAChecker{Resolver: resolver, Identities: merged, cache: map[string]*caaSet{}}
+}
+
+func (c *CAAChecker) Check(result Result, now time.Time) []Finding {
+	leaf := result.Leaf()
+	if leaf == nil || len(leaf.DNSNames) == 0 {
+		return nil
+	}
+	identities := c.issuerIdentities(result.Chain)
+
+	findings := []Finding{}
+	checked := map[string]bool{}
+	for _, name := range leaf.DNSNames {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		wildcard := strings.HasPrefix(name, "*.")
+		if wildcard {
+			name = name[2:]
+		}
+		if checked[name] {
+			continue
+		}
+		checked[name] = true
+
+		set := c.lookup(name)
+		if set.err != nil {
+			findings = append(findings, newFinding(caaCheck, "caa-lookup-failed", StatusWarning, 0, "cannot look up the CAA records of %s: %s", name, set.err))
+			continue
+		}
+		allowed, restricted := authorizedIssuers(set.Records, wildcard)
+		if !restricted {
+			continue
+		}
+		if len(identities) == 0 {
+			findings = append(findings, newFinding(caaCheck, "caa-unknown-issuer", StatusWarning, 0,
+				"the CAA identity of %s is unknown, cannot check it against the CAA records of %s (%s)",
+				leaf.Issuer.String(), set.Domain, strings.Join(allowed, ", ")))
+			continue
+		}
+		if !intersects(identities, allowed) {
+			authorized := strings.Join(allowed, ", ")
+			if authorized == "" {
+				authorized = "no CA"
+			}
+			findings = append(findings, newFinding(caaCheck, "caa-unauthorized", StatusCritical, 0,
+				"%s is issued by %s but the CAA records of %s authorize %s", name, leaf.Issuer.String(), set.Domain, authorized))
+			continue
+		}
+		findings = append(findings, newFinding(caaCheck, "caa-authorized", StatusOK, 0,
+			"%s is authorized by the CAA records of %s", leaf.Issuer.String(), set.Domain))
+	}
+	return findings
+}
+
+// returns the CAA issuer domains of the CAs of the chain
+func (c *CAAChecker) issuerIdentities(chain []*x509.Certificate) []string {
+	identities := []string{}
+	for _, certificate := range chain {
+		names := append([]string{certificate.Issuer.CommonName}, certificate.Issuer.Organization...)
+		for _, name := range names {
+			name = strings.ToLower(name)
+			for issuer, domains := range c.Identities {
+				if issuer != "" && strings.Contains(name, issuer) {
+					for _, domain := range domains {
+						identities = appendMissing(identities, strings.ToLower(domain))
+					}
+				}
+			}
+		}
+	}
+	return identities
+}
+
+// returns the relevant CAA record set of the name, the one of the closest ancestor
+// having CAA records (RFC 8659), cached for the other certificates
+func (c *CAAChecker) lookup(name string) *caaSet {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if set, ok := c.cache[name]; ok {
+		return set
+	}
+
+	set := &caaSet{}
+	labels := dns.SplitDomainName(name)
+	// the top-level domains are not queried
+	for i := 0; i < len(labels)-1; i++ {
+		domain := strings.Join(labels[i:], ".")
+		ctx, cancel := context.WithTimeout(context.Background(), c.Resolver.Timeout)
+		response, err := c.Resolver.Exchange(ctx, domain, dns.TypeCAA)
+		cancel()
+		if err != nil {
+			set.err = err
+			break
+		}
+		if response.Rcode != dns.RcodeSuccess && response.Rcode != dns.RcodeNameError {
+			set.err = errors.Errorf("query for %s CAA returned %s", domain, dns.RcodeToString[response.Rcode])
+			break
+		}
+		for _, answer := range answersOf(response, dns.TypeCAA) {
+			set.Records = append(set.Records, answer.(*dns.CAA))
+		}
+		if len(set.Records) != 0 {
+			set.Domain = domain
+			break
+		}
+	}
+	c.cache[name] = set
+	return set
+}
+
+// returns the issuer domains authorized by the CAA records and false when they do not
+// restrict the issuance. The issuewild records take precedence for the wildcard names.
+func authorizedIssuers(records []*dns.CAA, wildcard bool) ([]string, bool) {
+	for _, tag := range []string{"issuewild", "issue"} {
+		if tag == "issuewild" && !wildcard {
+			continue
+		}
+		allowed := []string{}
+		restricted := false
+		for _, record := range records {
+			if strings.ToLower(record.Tag) != tag {
+				continue
+			}
+			restricted = true
+			// the parameters after the domain, e.g. the account URI, are ignored
+			domain := strings.ToLower(strings.TrimSpace(strings.SplitN(record.Value, ";", 2)[0]))
+			if domain != "" {
+				allowed = appendMissing(allowed, domain)
+			}
+		}
+		if restricted {
+			return allowed, true
+		}
+	}
+	return nil, false
+}
+
+// returns true if the lists have a value in common
+func intersects(values []string, others []string) bool {
+	for _, value := range values {
+		for _, other := range others {
+			if value == other {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package cert
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CAAChecker(t *testing.T) {
+	port := startDNSServer(t,
+		`caa.test. 300 IN CAA 0 issue "ca.test"`,
+		`caa.test. 300 IN CAA 0 issuewild ";"`,
+		`other.test. 300 IN CAA 0 issue "letsencrypt.org; accounturi=https://acme.test/1"`,
+	)
+	resolver, err := NewDNSResolver("127.0.0.1:"+port, time.Second)
+	require.Nil(t, err)
+	checker := NewCAAChecker(resolver, map[string][]string{"Sentinel Test CA": {"ca.test"}})
+	require.Equal(t, []string{"letsencrypt.org"}, checker.Identities["let's encrypt"])
+	ca := newTestCA(t, "Sentinel Test CA")
+	now := time.Now()
+
+	// the records of the parent domain apply
+	findings := checker.Check(Result{Chain: []*x509.Certificate{newTestLeaf(t, ca, "www.caa.test").cert, ca.cert}}, now)
+	require.Equal(t, []string{"caa-authorized"}, findingIDs(findings))
+
+	// the issuewild records forbid the wildcards
+	findings = checker.Check(Result{Chain: []*x509.Certificate{newTestLeaf(t, ca, "*.caa.test").cert, ca.cert}}, now)
+	require.Equal(t, []string{"caa-unauthorized"}, findingIDs(findings))
+	require.Equal(t, StatusCritical, findings[0].Status)
+	require.Contains(t, findings[0].Message, "authorize no CA")
+
+	findings = checker.Check(Result{Chain: []*x509.Certificate{newTestLeaf(t, ca, "www.other.test").cert, ca.cert}}, now)
+	require.Equal(t, []string{"caa-unauthorized"}, findingIDs(findings))
+	require.Contains(t, findings[0].Message, "authorize letsencrypt.org")
+
+	// names without CAA records allow any CA
+	require.Empty(t, checker.Check(Result{Chain: []*x509.Certificate{newTestLeaf(t, ca, "www.none.test").cert, ca.cert}}, now))
+
+	unknown := newTestCA(t, "Unknown CA")
+	findings = checker.Check(Result{Chain: []*x509.Certificate{newTestLeaf(t, unknown, "www.caa.test").cert, unknown.cert}}, now)
+	require.Equal(t, []string{"caa-unknown-issuer"}, findingIDs(findings))
+	require.Equal(t, StatusWarning, findings[0].Status)
+}
@@ -0,0 +1,116 @@
+package cert
+
+import (
+	"bufio"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	calendarTimeFormat = "20060102T150405Z"
+	// maximum length in octets of the lines of an iCalendar stream, without the CRLF
+	calendarLineLength = 75
+)
+
+// writes the expiry dates of the records as an iCalendar stream (RFC 5545), one event
+// per certificate with display alarms at the warning and critical thresholds
+func WriteCalendar(w io.Writer, records []*InventoryRecord, expiry ExpiryConfig, now time.Time) error {
+	writer := bufio.NewWriter(w)
+	line := func(name string, value string) {
+		writeCalendarLine(writer, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//sentinel//certificate expiry//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	line("X-WR-CALNAME", "Certificate expiry")
+	for _, record := range records {
+		name := calendarName(record)
+		line("BEGIN", "VEVENT")
+		line("UID", record.Fingerprint+"@sentinel")
+		line("DTSTAMP", now.UTC().Format(calendarTimeFormat))
+		line("DTSTART", record.NotAfter.UTC().Format(calendarTimeFormat))
+		line("DTEND", record.NotAfter.UTC().Add(time.Hour).Format(calendarTimeFormat))
+		line("SUMMARY", escapeCalendarText("Certificate expiry: "+name))
+		line("DESCRIPTION", escapeCalendarText(calendarDescription(record)))
+		line("CATEGORIES", "CERTIFICATE")
+		line("TRANSP", "TRANSPARENT")
+		for _, alarm := range []struct {
+			status Status
+			days   int
+		}{{StatusWarning, expiry.WarningDays}, {StatusCritical, expiry.CriticalDays}} {
+			if alarm.days <= 0 {
+				continue
+			}
+			line("BEGIN", "VALARM")
+			line("ACTION", "DISPLAY")
+			line("TRIGGER;RELATED=START", fmt.Sprintf("-P%dD", alarm.days))
+			line("DESCRIPTION", escapeCalendarText(fmt.Sprintf("%s: %s expires in %d days", alarm.status, name, alarm.days)))
+			line("END", "VALARM")
+		}
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return writer.Flush()
+}
+
+// returns the common name of the certificate, its first SAN or its subject
+func calendarName(record *InventoryRecord) string {
+	if c, err := x509.ParseCertificate(record.Raw); err == nil && c.Subject.CommonName != "" {
+		return c.Subject.CommonName
+	}
+	if len(record.SANs) != 0 {
+		return record.SANs[0]
+	}
+	return record.Subject
+}
+
+// returns the details of the certificate and the endpoints presenting it
+func calendarDescription(record *InventoryRecord) string {
+	description := []string{
+		"Subject: " + record.Subject,
+		"Issuer: " + record.Issuer,
+		"Serial: " + record.Serial,
+		"Fingerprint: " + record.Fingerprint,
+	}
+	if len(record.SANs) != 0 {
+		description = append(description, "SANs: "+strings.Join(record.SANs, ", "))
+	}
+	if len(record.Endpoints) != 0 {
+		description = append(description, "Endpoints:")
+		for _, endpoint := range record.Endpoints {
+			description = append(description, "  "+endpoint.String())
+		}
+	}
+	return strings.Join(description, "\n")
+}
+
+// escapes the characters of a TEXT value
+func escapeCalendarText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+// writes a content line terminated by CRLF, folded every 75 octets without splitting
+// the UTF-8 characters
+func writeCalendarLine(w *bufio.Writer, line string) {
+	limit := calendarLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		// the leading space of the continuation lines counts
+		limit = calendarLineLength - 1
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
@@ -0,0 +1,61 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_WriteCalendar(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	notAfter := time.Date(2030, 3, 1, 12, 0, 0, 0, time.UTC)
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com", "mail.example.com"}, NotAfter: notAfter}, ca)
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	record := newInventoryRecord(leaf.cert, now)
+	for i := 0; i < 5; i++ {
+		record.see(Target{Host: "192.0.2.1", Port: 8440 + i, ServerName: "www.example.com"}, 0, now)
+	}
+	require.True(t, record.IsLeaf())
+	require.False(t, newInventoryRecord(ca.cert, now).IsLeaf())
+
+	output := &bytes.Buffer{}
+	require.Nil(t, WriteCalendar(output, []*InventoryRecord{record}, ExpiryConfig{WarningDays: 30, CriticalDays: 7}, now))
+	calendar := output.String()
+
+	lines := strings.Split(strings.TrimSuffix(calendar, "\r\n"), "\r\n")
+	require.Equal(t, "BEGIN:VCALENDAR", lines[0])
+	require.Equal(t, "END:VCALENDAR", lines[len(lines)-1])
+	for _, line := range lines {
+		require.True(t, len(line) <= 75, line)
+	}
+	require.Contains(t, calendar, "UID:"+record.Fingerprint[:60])
+	require.Contains(t, calendar, "\r\nDTSTART:20300301T120000Z\r\n")
+	require.Contains(t, calendar, "\r\nSUMMARY:Certificate expiry: www.example.com\r\n")
+	require.Contains(t, calendar, "\r\nTRIGGER;RELATED=START:-P30D\r\n")
+	require.Contains(t, calendar, "\r\nTRIGGER;RELATED=START:-P7D\r\n")
+	require.Equal(t, 2, strings.Count(calendar, "BEGIN:VALARM"))
+
+	// the folded lines are unfolded by removing CRLF and the following space
+	unfolded := strings.Replace(calendar, "\r\n ", "", -1)
+	require.Contains(t, unfolded, `\nSANs: www.example.com\, mail.example.com\nEndpoints:\n  192.0.2.1:8440 (www.example.com)\n`)
+	require.Contains(t, unfolded, "DESCRIPTION:CRITICAL: www.example.com expires in 7 days\r\n")
+}
+
+func Test_WriteCalendarLine(t *testing.T) {
+	output := &bytes.Buffer{}
+	require.Nil(t, WriteCalendar(output, []*InventoryRecord{{
+		Fingerprint: "00",
+		Subject:     strings.Repeat("é", 100),
+		NotAfter:    time.Now(),
+	}}, ExpiryConfig{}, time.Now()))
+	for _, line := range strings.Split(output.String(), "\r\n") {
+		require.True(t, len(line) <= 75)
+		require.True(t, strings.ToValidUTF8(line, "?") == line, line)
+	}
+	require.Contains(t, strings.Replace(output.String(), "\r\n ", "", -1), "SUMMARY:Certificate expiry: "+strings.Repeat("é", 100)+"\r\n")
+	require.NotContains(t, output.String(), "VALARM")
+}
@@ -0,0 +1,188 @@
+package cert
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+const (
+	ChallengeHTTP01 = "http-01"
+	ChallengeDNS01  = "dns-01"
+
+	// directory of the HTTP-01 challenge responses below the web root
+	http01ChallengeDir = ".well-known/acme-challenge"
+	defaultDNS01TTL    = 60
+)
+
+// fulfills the ACME challenges proving the control of the domains
+type ChallengeProvider interface {
+	// ACME challenge type, ChallengeHTTP01 or ChallengeDNS01
+	Type() string
+	// publishes the response to the challenge of the domain, the key authorization for
+	// HTTP-01 or the TXT record value for DNS-01
+	Present(ctx context.Context, domain string, token string, value string) error
+	// removes what Present published
+	CleanUp(ctx context.Context, domain string, token string, value string) error
+}
+
+// answers the HTTP-01 challenges with files written in the web root of the domains,
+// served by the existing web server at /.well-known/acme-challenge/
+type WebrootProvider struct {
+	Root string
+}
+
+func (p *WebrootProvider) Type() string {
+	return ChallengeHTTP01
+}
+
+func (p *WebrootProvider) Present(ctx context.Context, domain string, token string, value string) error {
+	dir := filepath.Join(p.Root, http01ChallengeDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "cannot create challenge directory %s", dir)
+	}
+	return ioutil.WriteFile(filepath.Join(dir, filepath.Base(token)), []byte(value), 0644)
+}
+
+func (p *WebrootProvider) CleanUp(ctx context.Context, domain string, token string, value string) error {
+	err := os.Remove(filepath.Join(p.Root, http01ChallengeDir, filepath.Base(token)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// answers the DNS-01 challenges with a command, called with present or cleanup, the
+// record name (_acme-challenge.<domain>.) and the TXT record value as arguments
+type ExecDNSProvider struct {
+	Command string
+	Timeout time.Duration
+}
+
+func (p *ExecDNSProvider) Type() string {
+	return ChallengeDNS01
+}
+
+func (p *ExecDNSProvider) Present(ctx context.Context, domain string, token string, value string) error {
+	return p.run(ctx, "present", domain, value)
+}
+
+func (p *ExecDNSProvider) CleanUp(ctx context.Context, domain string, token string, value string) error {
+	return p.run(ctx, "cleanup", domain, value)
+}
+
+func (p *ExecDNSProvider) run(ctx context.Context, action string, domain string, value string) error {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	output, err := exec.CommandContext(ctx, p.Command, action, dns01RecordName(domain), value).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "%s %s failed: %s", p.Command, action, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// answers the DNS-01 challenges with dynamic updates (RFC 2136) of the zone on its
+// primary nameserver, authenticated with TSIG when a key is configured
+type RFC2136Provider struct {
+	// host:port of the primary nameserver
+	Nameserver string
+	// zone updated, found with SOA queries to the nameserver when empty
+	Zone string
+	// TSIG key name, algorithm (hmac-sha256. by default) and base64 secret
+	TSIGKey       string
+	TSIGAlgorithm string
+	TSIGSecret    string
+	TTL           uint32
+	Timeout       time.Duration
+}
+
+func (p *RFC2136Provider) Type() string {
+	return ChallengeDNS01
+}
+
+func (p *RFC2136Provider) Present(ctx context.Context, domain string, token string, value string) error {
+	return p.update(ctx, domain, value, true)
+}
+
+func (p *RFC2136Provider) CleanUp(ctx context.Context, domain string, token string, value string) error {
+	return p.update(ctx, domain, value, false)
+}
+
+// inserts or removes the TXT record of the challenge of the domain
+func (p *RFC2136Provider) update(ctx context.Context, domain string, value string, insert bool) error {
+	ttl := p.TTL
+	if ttl == 0 {
+		ttl = defaultDNS01TTL
+	}
+	client := &dns.Client{Net: "tcp", Timeout: p.Timeout}
+	if client.Timeout <= 0 {
+		client.Timeout = defaultDNSTimeout
+	}
+	zone := p.Zone
+	if zone == "" {
+		var err error
+		if zone, err = p.findZone(ctx, client, domain); err != nil {
+			return err
+		}
+	}
+	name := dns01RecordName(domain)
+	record := &dns.TXT{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl}, Txt: []string{value}}
+
+	update := new(dns.Msg)
+	update.SetUpdate(dns.Fqdn(zone))
+	if insert {
+		update.Insert([]dns.RR{record})
+	} else {
+		update.Remove([]dns.RR{record})
+	}
+
+	if p.TSIGKey != "" {
+		algorithm := p.TSIGAlgorithm
+		if algorithm == "" {
+			algorithm = dns.HmacSHA256
+		}
+		update.SetTsig(dns.Fqdn(p.TSIGKey), dns.Fqdn(algorithm), 300, time.Now().Unix())
+		client.TsigSecret = map[string]string{dns.Fqdn(p.TSIGKey): p.TSIGSecret}
+	}
+	response, _, err := client.ExchangeContext(ctx, update, p.Nameserver)
+	if err != nil {
+		return errors.Wrapf(err, "cannot update %s on %s", name, p.Nameserver)
+	}
+	if response.Rcode != dns.RcodeSuccess {
+		return errors.Errorf("update of %s on %s returned %s", name, p.Nameserver, dns.RcodeToString[response.Rcode])
+	}
+	return nil
+}
+
+// returns the name of the TXT record of the DNS-01 challenge of the domain
+func dns01RecordName(domain string) string {
+	return "_acme-challenge." + dns.Fqdn(strings.TrimPrefix(domain, "*."))
+}
+
+// returns the zone of the domain, the closest ancestor having an SOA record
+func (p *RFC2136Provider) findZone(ctx context.Context, client *dns.Client, domain string) (string, error) {
+	labels := dns.SplitDomainName(strings.TrimPrefix(domain, "*."))
+	for i := range labels {
+		candidate := dns.Fqdn(strings.Join(labels[i:], "."))
+		query := new(dns.Msg)
+		query.SetQuestion(candidate, dns.TypeSOA)
+		response, _, err := client.ExchangeContext(ctx, query, p.Nameserver)
+		if err != nil {
+			return "", errors.Wrapf(err, "cannot find the zone of %s on %s", domain, p.Nameserver)
+		}
+		for _, answer := range answersOf(response, dns.TypeSOA) {
+			return answer.Header().Name, nil
+		}
+	}
+	return "", errors.Errorf("no zone of %s found on %s", domain, p.Nameserver)
+}
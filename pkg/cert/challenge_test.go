@@ -0,0 +1,81 @@
+package cert
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ExecDNSProvider(t *testing.T) {
+	dir := tempDir(t)
+	script := filepath.Join(dir, "hook.sh")
+	require.Nil(t, ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" >> "+filepath.Join(dir, "calls")+"\n"), 0755))
+	provider := &ExecDNSProvider{Command: script, Timeout: 5 * time.Second}
+	require.Equal(t, ChallengeDNS01, provider.Type())
+
+	require.Nil(t, provider.Present(context.Background(), "*.example.com", "token", "value"))
+	require.Nil(t, provider.CleanUp(context.Background(), "*.example.com", "token", "value"))
+	calls, err := ioutil.ReadFile(filepath.Join(dir, "calls"))
+	require.Nil(t, err)
+	require.Equal(t, "present _acme-challenge.example.com. value\ncleanup _acme-challenge.example.com. value\n", string(calls))
+
+	provider.Command = filepath.Join(dir, "missing")
+	require.NotNil(t, provider.Present(context.Background(), "example.com", "token", "value"))
+}
+
+func Test_RFC2136Provider(t *testing.T) {
+	secret := "c2VudGluZWwtdGVzdC1zZWNyZXQ="
+	soa, err := dns.NewRR("example.test. 300 IN SOA ns1.example.test. admin.example.test. 1 3600 600 86400 300")
+	require.Nil(t, err)
+	lock := &sync.Mutex{}
+	updates := []string{}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		switch {
+		case r.Opcode == dns.OpcodeUpdate:
+			if r.IsTsig() == nil || w.TsigStatus() != nil {
+				m.SetRcode(r, dns.RcodeNotAuth)
+				break
+			}
+			lock.Lock()
+			for _, rr := range r.Ns {
+				updates = append(updates, r.Question[0].Name+" "+rr.String())
+			}
+			lock.Unlock()
+			m.SetTsig(r.IsTsig().Hdr.Name, dns.HmacSHA256, 300, time.Now().Unix())
+		case r.Question[0].Name == "example.test.":
+			m.Answer = append(m.Answer, soa)
+		}
+		w.WriteMsg(m)
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	server := &dns.Server{Listener: listener, Handler: handler, TsigSecret: map[string]string{"sentinel.": secret},
+		// the default accept function refuses the updates
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept }}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	provider := &RFC2136Provider{Nameserver: listener.Addr().String(), TSIGKey: "sentinel", TSIGSecret: secret, Timeout: time.Second}
+	require.Equal(t, ChallengeDNS01, provider.Type())
+	require.Nil(t, provider.Present(context.Background(), "www.example.test", "token", "value"))
+	require.Nil(t, provider.CleanUp(context.Background(), "www.example.test", "token", "value"))
+	lock.Lock()
+	received := append([]string{}, updates...)
+	lock.Unlock()
+	require.Equal(t, []string{
+		"example.test. _acme-challenge.www.example.test.\t60\tIN\tTXT\t\"value\"",
+		"example.test. _acme-challenge.www.example.test.\t0\tNONE\tTXT\t\"value\"",
+	}, received)
+
+	provider.TSIGSecret = "d3Jvbmc="
+	require.NotNil(t, provider.Present(context.Background(), "www.example.test", "token", "value"))
+}
@@ -0,0 +1,139 @@
+package cert
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// progress of a subnet scan saved after every batch of hosts, a rerun with the same
+// scope resumes after the hosts already scanned
+type Checkpoint struct {
+	Scope string `json:"scope"`
+	// number of hosts completely scanned, in the order of SubnetTargets
+	Hosts     uint64             `json:"hosts"`
+	Results   []checkpointResult `json:"results"`
+	UpdatedAt time.Time          `json:"updatedAt"`
+}
+
+// the fields of a Result kept in a checkpoint, the findings are computed once the scan is over
+type checkpointResult struct {
+	Host                string    `json:"host"`
+	Port                int       `json:"port"`
+	ServerName          string    `json:"serverName,omitempty"`
+	Fallback            bool      `json:"fallback,omitempty"`
+	Protocol            string    `json:"protocol,omitempty"`
+	Chain               [][]byte  `json:"chain"`
+	OCSPStaple          []byte    `json:"ocspStaple,omitempty"`
+	Version             uint16    `json:"version"`
+	CipherSuite         uint16    `json:"cipherSuite"`
+	ScannedAt           time.Time `json:"scannedAt"`
+	ClientAuth          string    `json:"clientAuth,omitempty"`
+	ClientCAs           []string  `json:"clientCAs,omitempty"`
+	ClientCertPresented bool      `json:"clientCertPresented,omitempty"`
+}
+
+// reads the checkpoint of the scope, a new one is returned when the file does not exist
+// or belongs to another scan
+func LoadCheckpoint(path string, scope string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Checkpoint{Scope: scope}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read checkpoint %s", path)
+	}
+	checkpoint := &Checkpoint{}
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, errors.Wrapf(err, "invalid checkpoint %s", path)
+	}
+	if checkpoint.Scope != scope {
+		return &Checkpoint{Scope: scope}, nil
+	}
+	return checkpoint, nil
+}
+
+// records the results of the next hosts scanned
+func (c *Checkpoint) Add(hosts uint64, results []Result) {
+	c.Hosts += hosts
+	for _, result := range results {
+		chain := [][]byte{}
+		for _, certificate := range result.Chain {
+			chain = append(chain, certificate.Raw)
+		}
+		c.Results = append(c.Results, checkpointResult{
+			Host:                result.Host,
+			Port:                result.Port,
+			ServerName:          result.ServerName,
+			Fallback:            result.Fallback,
+			Protocol:            result.Protocol,
+			Chain:               chain,
+			OCSPStaple:          result.OCSPStaple,
+			Version:             result.Version,
+			CipherSuite:         result.CipherSuite,
+			ScannedAt:           result.ScannedAt,
+			ClientAuth:          result.ClientAuth,
+			ClientCAs:           result.ClientCAs,
+			ClientCertPresented: result.ClientCertPresented,
+		})
+	}
+}
+
+// returns the results recorded so far
+func (c *Checkpoint) Restore() ([]Result, error) {
+	results := []Result{}
+	for _, saved := range c.Results {
+		result := Result{
+			Target:              Target{Host: saved.Host, Port: saved.Port, ServerName: saved.ServerName},
+			Reachable:           true,
+			Fallback:            saved.Fallback,
+			Protocol:            saved.Protocol,
+			OCSPStaple:          saved.OCSPStaple,
+			Version:             saved.Version,
+			CipherSuite:         saved.CipherSuite,
+			ScannedAt:           saved.ScannedAt,
+			ClientAuth:          saved.ClientAuth,
+			ClientCAs:           saved.ClientCAs,
+			ClientCertPresented: saved.ClientCertPresented,
+		}
+		for _, der := range saved.Chain {
+			certificate, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid certificate of %s in checkpoint", result.Address())
+			}
+			result.Chain = append(result.Chain, certificate)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// writes the checkpoint atomically, an interrupted write leaves the previous one intact
+func (c *Checkpoint) Save(path string, now time.Time) error {
+	c.UpdatedAt = now
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return errors.Wrapf(err, "cannot create the directory of checkpoint %s", path)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0640); err != nil {
+		return errors.Wrapf(err, "cannot write checkpoint %s", path)
+	}
+	return errors.Wrapf(os.Rename(tmp, path), "cannot write checkpoint %s", path)
+}
+
+// removes the checkpoint of a completed scan
+func RemoveCheckpoint(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "cannot remove checkpoint %s", path)
+	}
+	return nil
+}
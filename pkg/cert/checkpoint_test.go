@@ -0,0 +1,53 @@
+package cert
+
+import (
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Checkpoint(t *testing.T) {
+	path := filepath.Join(tempDir(t), "state", "checkpoint.json")
+	ca := newTestCA(t, "Sentinel Test CA")
+	leaf := newTestLeaf(t, ca, "www.example.com")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	checkpoint, err := LoadCheckpoint(path, "subnets=10.0.0.0/24 ports=443")
+	require.Nil(t, err)
+	require.Zero(t, checkpoint.Hosts)
+
+	checkpoint.Add(64, []Result{{
+		Target:    Target{Host: "10.0.0.5", Port: 443, ServerName: "www.example.com"},
+		Reachable: true,
+		Chain:     []*x509.Certificate{leaf.cert, ca.cert},
+		Version:   0x0303,
+		ScannedAt: now,
+	}})
+	require.Nil(t, checkpoint.Save(path, now))
+
+	// the progress of another scan is ignored
+	other, err := LoadCheckpoint(path, "subnets=10.0.1.0/24 ports=443")
+	require.Nil(t, err)
+	require.Zero(t, other.Hosts)
+
+	resumed, err := LoadCheckpoint(path, "subnets=10.0.0.0/24 ports=443")
+	require.Nil(t, err)
+	require.Equal(t, uint64(64), resumed.Hosts)
+	require.True(t, now.Equal(resumed.UpdatedAt))
+	results, err := resumed.Restore()
+	require.Nil(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, "www.example.com", results[0].ServerName)
+	require.Equal(t, leaf.cert.Raw, results[0].Leaf().Raw)
+	require.Len(t, results[0].Chain, 2)
+	require.Equal(t, uint16(0x0303), results[0].Version)
+
+	require.Nil(t, RemoveCheckpoint(path))
+	require.Nil(t, RemoveCheckpoint(path))
+	fresh, err := LoadCheckpoint(path, "subnets=10.0.0.0/24 ports=443")
+	require.Nil(t, err)
+	require.Zero(t, fresh.Hosts)
+}
@@ -0,0 +1,112 @@
+package cert
+
+import (
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// client authentication of an endpoint
+const (
+	// a client certificate is requested during the handshake but not verified
+	ClientAuthRequested = "requested"
+	// the handshake fails without a client certificate
+	ClientAuthRequired = "required"
+	// the handshake fails with the presented client certificate
+	ClientAuthRejected = "rejected"
+)
+
+// a client certificate presented to the endpoints of some networks or domains
+type ClientCertificate struct {
+	Certificate tls.Certificate
+	Networks    []*net.IPNet
+	// domains whose sub-domains are also matched
+	Domains []string
+}
+
+// the client certificates presented during the handshakes, the first one matching the
+// target is used. A nil list presents none.
+type ClientCertificates []*ClientCertificate
+
+// loads a client certificate and its key from PEM files, it is presented to the
+// addresses of the given CIDRs and to the given domains
+func LoadClientCertificate(certFile string, keyFile string, cidrs []string, domains []string) (*ClientCertificate, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot load client certificate %s", certFile)
+	}
+	client := &ClientCertificate{Certificate: certificate}
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, errors.Wrapf(err, "invalid network %s of client certificate %s", cidr, certFile)
+			}
+			ipnet = singleHostNet(ip)
+		}
+		client.Networks = append(client.Networks, ipnet)
+	}
+	for _, domain := range domains {
+		client.Domains = append(client.Domains, strings.TrimSuffix(strings.ToLower(domain), "."))
+	}
+	return client, nil
+}
+
+// returns true if the certificate is presented to the target, matched by the address of
+// its host or by its server name or host name
+func (c *ClientCertificate) Matches(target Target) bool {
+	if ip := net.ParseIP(target.Host); ip != nil {
+		for _, network := range c.Networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	for _, name := range []string{target.ServerName, target.Host} {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		if name == "" {
+			continue
+		}
+		for _, domain := range c.Domains {
+			if name == domain || strings.HasSuffix(name, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// returns the client certificate presented to the target, nil if none matches
+func (c ClientCertificates) Select(target Target) *tls.Certificate {
+	for _, client := range c {
+		if client.Matches(target) {
+			return &client.Certificate
+		}
+	}
+	return nil
+}
+
+// returns the distinguished names of the CAs advertised in a CertificateRequest
+func acceptableCANames(acceptableCAs [][]byte) []string {
+	names := []string{}
+	for _, der := range acceptableCAs {
+		rdns := pkix.RDNSequence{}
+		if rest, err := asn1.Unmarshal(der, &rdns); err != nil || len(rest) != 0 {
+			continue
+		}
+		name := pkix.Name{}
+		name.FillFromRDNSequence(&rdns)
+		names = append(names, name.String())
+	}
+	return names
+}
+
+// returns true if the error is a TLS alert sent by the peer
+func isRemoteAlert(err error) bool {
+	return err != nil && strings.Contains(errors.Cause(err).Error(), "remote error: tls:")
+}
@@ -0,0 +1,114 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// returns a client certificate issued by the CA
+func newTestClient(t *testing.T, ca *testCert, name string) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, ca)
+}
+
+// starts a server requiring a client certificate issued by the client CA
+func startMTLSServer(t *testing.T, clientCA *testCert, clientAuth tls.ClientAuthType, maxVersion uint16) (*testCert, int) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	leaf := newTestLeaf(t, ca, "127.0.0.1")
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCA.cert)
+	_, port := startTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{tlsCertificate(leaf, ca)},
+		ClientAuth:   clientAuth,
+		ClientCAs:    pool,
+		MaxVersion:   maxVersion,
+	})
+	return leaf, port
+}
+
+func probeMTLS(t *testing.T, port int, clients ClientCertificates) Result {
+	conf, err := NewScanConfig([]int{port}, 1, 2)
+	require.Nil(t, err)
+	conf.ClientCertificates = clients
+	return NewScanner(conf).Probe(context.Background(), Target{Host: "127.0.0.1", Port: port})
+}
+
+func Test_ProbeClientAuthRequired(t *testing.T) {
+	clientCA := newTestCA(t, "Sentinel Client CA")
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		leaf, port := startMTLSServer(t, clientCA, tls.RequireAndVerifyClientCert, version)
+
+		// the server certificate is reported without client certificate
+		result := probeMTLS(t, port, nil)
+		require.Nil(t, result.Err, tlsVersionName(version))
+		require.Equal(t, ClientAuthRequired, result.ClientAuth, tlsVersionName(version))
+		require.Equal(t, []string{"CN=Sentinel Client CA"}, result.ClientCAs)
+		require.Equal(t, leaf.cert.Raw, result.Leaf().Raw)
+		require.Equal(t, version, result.Version)
+
+		// the configured client certificate is accepted
+		client := newTestClient(t, clientCA, "scanner")
+		clients := ClientCertificates{{Certificate: tlsCertificate(client), Networks: []*net.IPNet{singleHostNet(net.ParseIP("127.0.0.1"))}}}
+		result = probeMTLS(t, port, clients)
+		require.Nil(t, result.Err, tlsVersionName(version))
+		require.Equal(t, ClientAuthRequested, result.ClientAuth, tlsVersionName(version))
+		require.True(t, result.ClientCertPresented)
+
+		// a client certificate of another CA is rejected
+		other := newTestClient(t, newTestCA(t, "Other CA"), "scanner")
+		clients[0].Certificate = tlsCertificate(other)
+		result = probeMTLS(t, port, clients)
+		require.Nil(t, result.Err, tlsVersionName(version))
+		require.Equal(t, ClientAuthRejected, result.ClientAuth, tlsVersionName(version))
+		require.Equal(t, leaf.cert.Raw, result.Leaf().Raw)
+	}
+}
+
+func Test_ProbeClientAuthRequested(t *testing.T) {
+	_, port := startMTLSServer(t, newTestCA(t, "Sentinel Client CA"), tls.VerifyClientCertIfGiven, 0)
+	result := probeMTLS(t, port, nil)
+	require.Nil(t, result.Err)
+	require.Equal(t, ClientAuthRequested, result.ClientAuth)
+	require.False(t, result.ClientCertPresented)
+
+	_, port = startMTLSServer(t, newTestCA(t, "Sentinel Client CA"), tls.NoClientCert, 0)
+	result = probeMTLS(t, port, nil)
+	require.Nil(t, result.Err)
+	require.Empty(t, result.ClientAuth)
+}
+
+func Test_LoadClientCertificate(t *testing.T) {
+	dir := tempDir(t)
+	client := newTestClient(t, newTestCA(t, "Sentinel Client CA"), "scanner")
+	key, err := x509.MarshalPKCS8PrivateKey(client.key)
+	require.Nil(t, err)
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	require.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: client.cert.Raw}), 0600))
+	require.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600))
+
+	loaded, err := LoadClientCertificate(certFile, keyFile, []string{"10.1.0.0/16", "192.168.0.5"}, []string{"Internal.Example.com."})
+	require.Nil(t, err)
+	clients := ClientCertificates{loaded}
+	require.NotNil(t, clients.Select(Target{Host: "10.1.2.3", Port: 443}))
+	require.NotNil(t, clients.Select(Target{Host: "192.168.0.5", Port: 443}))
+	require.NotNil(t, clients.Select(Target{Host: "10.9.0.1", Port: 443, ServerName: "api.internal.example.com"}))
+	require.NotNil(t, clients.Select(Target{Host: "internal.example.com", Port: 443}))
+	require.Nil(t, clients.Select(Target{Host: "10.9.0.1", Port: 443, ServerName: "notinternal.example.com"}))
+
+	_, err = LoadClientCertificate(certFile, keyFile, []string{"10.1.0.0/33"}, nil)
+	require.NotNil(t, err)
+	_, err = LoadClientCertificate(certFile, certFile, nil, nil)
+	require.NotNil(t, err)
+}
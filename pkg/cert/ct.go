@@ -0,0 +1,295 @@
+package cert
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultCTEntries   = 1000
+	defaultCTBatchSize = 256
+)
+
+var oidExtensionSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// fetches the JSON document at the given URL into v
+func getJSON(ctx context.Context, client *http.Client, rawURL string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("GET %s returned %s: %s", rawURL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "cannot decode the response of %s", rawURL)
+	}
+	return nil
+}
+
+// keeps the names belonging to the domain, wildcards are stripped
+func namesInDomain(names []string, domain string) []string {
+	kept := []string{}
+	for _, name := range names {
+		name = NormalizeHostname(name)
+		if InDomain(name, domain) {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
+
+// queries a crt.sh-style JSON search API
+type CrtShSource struct {
+	BaseURL        string
+	ExcludeExpired bool
+	Client         *http.Client
+}
+
+type crtShEntry struct {
+	CommonName string `json:"common_name"`
+	// newline separated SAN entries
+	NameValue string `json:"name_value"`
+}
+
+func (s *CrtShSource) Name() string {
+	return "crtsh"
+}
+
+func (s *CrtShSource) Subdomains(ctx context.Context, domain string) ([]string, error) {
+	query := url.Values{}
+	query.Set("q", "%."+domain)
+	query.Set("output", "json")
+	if s.ExcludeExpired {
+		query.Set("exclude", "expired")
+	}
+
+	entries := []crtShEntry{}
+	if err := getJSON(ctx, s.Client, strings.TrimSuffix(s.BaseURL, "/")+"/?"+query.Encode(), &entries); err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.CommonName)
+		names = append(names, strings.Split(entry.NameValue, "\n")...)
+	}
+	return namesInDomain(names, domain), nil
+}
+
+// reads the most recent entries of RFC 6962 logs. The entries are downloaded once and
+// shared between the domains.
+type CTLogSource struct {
+	LogURLs []string
+	// number of most recent entries read from every log
+	Entries   int64
+	BatchSize int64
+	Client    *http.Client
+
+	once  sync.Once
+	names []string
+	err   error
+}
+
+type signedTreeHead struct {
+	TreeSize int64 `json:"tree_size"`
+}
+
+type logEntry struct {
+	LeafInput []byte `json:"leaf_input"`
+	ExtraData []byte `json:"extra_data"`
+}
+
+type getEntriesResponse struct {
+	Entries []logEntry `json:"entries"`
+}
+
+func (s *CTLogSource) Name() string {
+	return "ctlog"
+}
+
+func (s *CTLogSource) Subdomains(ctx context.Context, domain string) ([]string, error) {
+	s.once.Do(func() {
+		s.names, s.err = s.fetchNames(ctx)
+	})
+	return namesInDomain(s.names, domain), s.err
+}
+
+// returns the names of the certificates of the most recent entries of all logs
+func (s *CTLogSource) fetchNames(ctx context.Context) ([]string, error) {
+	entries, batchSize := s.Entries, s.BatchSize
+	if entries <= 0 {
+		entries = defaultCTEntries
+	}
+	if batchSize <= 0 {
+		batchSize = defaultCTBatchSize
+	}
+
+	names := []string{}
+	var lastErr error
+	for _, logURL := range s.LogURLs {
+		logNames, err := s.fetchLogNames(ctx, strings.TrimSuffix(logURL, "/"), entries, batchSize)
+		if err != nil {
+			lastErr = errors.Wrapf(err, "cannot read CT log %s", logURL)
+		}
+		names = append(names, logNames...)
+	}
+	return names, lastErr
+}
+
+func (s *CTLogSource) fetchLogNames(ctx context.Context, logURL string, entries int64, batchSize int64) ([]string, error) {
+	sth := signedTreeHead{}
+	if err := getJSON(ctx, s.Client, logURL+"/ct/v1/get-sth", &sth); err != nil {
+		return nil, err
+	}
+
+	start := sth.TreeSize - entries
+	if start < 0 {
+		start = 0
+	}
+	names := []string{}
+	for start < sth.TreeSize {
+		end := start + batchSize - 1
+		if end >= sth.TreeSize {
+			end = sth.TreeSize - 1
+		}
+		response := getEntriesResponse{}
+		entriesURL := fmt.Sprintf("%s/ct/v1/get-entries?start=%d&end=%d", logURL, start, end)
+		if err := getJSON(ctx, s.Client, entriesURL, &response); err != nil {
+			return names, err
+		}
+		// logs may return fewer entries than requested
+		if len(response.Entries) == 0 {
+			return names, errors.Errorf("no entries returned for %s", entriesURL)
+		}
+		for _, entry := range response.Entries {
+			entryNames, err := ParseLeafInput(entry.LeafInput)
+			if err != nil {
+				continue
+			}
+			names = append(names, entryNames...)
+		}
+		start += int64(len(response.Entries))
+	}
+	return names, nil
+}
+
+const (
+	ctX509Entry    = 0
+	ctPrecertEntry = 1
+)
+
+// returns the subject common name and SAN DNS names of the certificate or
+// pre-certificate of an RFC 6962 MerkleTreeLeaf
+func ParseLeafInput(leafInput []byte) ([]string, error) {
+	// version (1), leaf type (1), timestamp (8), entry type (2)
+	if len(leafInput) < 12 || leafInput[0] != 0 || leafInput[1] != 0 {
+		return nil, errors.New("unsupported Merkle tree leaf")
+	}
+	entryType := binary.BigEndian.Uint16(leafInput[10:12])
+	data := leafInput[12:]
+
+	switch entryType {
+	case ctX509Entry:
+		der, err := readUint24Prefixed(data)
+		if err != nil {
+			return nil, err
+		}
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		return append([]string{c.Subject.CommonName}, c.DNSNames...), nil
+	case ctPrecertEntry:
+		// skip the issuer key hash
+		if len(data) < 32 {
+			return nil, errors.New("truncated pre-certificate entry")
+		}
+		tbs, err := readUint24Prefixed(data[32:])
+		if err != nil {
+			return nil, err
+		}
+		return parseTBSNames(tbs)
+	}
+	return nil, errors.Errorf("unknown log entry type %d", entryType)
+}
+
+// returns the data prefixed by its 24 bits length
+func readUint24Prefixed(data []byte) ([]byte, error) {
+	if len(data) < 3 {
+		return nil, errors.New("truncated log entry")
+	}
+	length := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+	if len(data) < 3+length {
+		return nil, errors.New("truncated log entry")
+	}
+	return data[3 : 3+length], nil
+}
+
+type tbsCertificate struct {
+	Version            int `asn1:"optional,explicit,default:0,tag:0"`
+	SerialNumber       *big.Int
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Issuer             asn1.RawValue
+	Validity           asn1.RawValue
+	Subject            asn1.RawValue
+	PublicKey          asn1.RawValue
+	UniqueID           asn1.BitString   `asn1:"optional,tag:1"`
+	SubjectUniqueID    asn1.BitString   `asn1:"optional,tag:2"`
+	Extensions         []pkix.Extension `asn1:"optional,explicit,tag:3"`
+}
+
+// extracts the subject common name and SAN DNS names of a TBSCertificate,
+// pre-certificates log only this part of the certificate
+func parseTBSNames(der []byte) ([]string, error) {
+	tbs := tbsCertificate{}
+	if _, err := asn1.Unmarshal(der, &tbs); err != nil {
+		return nil, errors.Wrap(err, "cannot parse TBSCertificate")
+	}
+
+	names := []string{}
+	subject := pkix.RDNSequence{}
+	if _, err := asn1.Unmarshal(tbs.Subject.FullBytes, &subject); err == nil {
+		name := pkix.Name{}
+		name.FillFromRDNSequence(&subject)
+		if name.CommonName != "" {
+			names = append(names, name.CommonName)
+		}
+	}
+
+	for _, extension := range tbs.Extensions {
+		if !extension.Id.Equal(oidExtensionSubjectAltName) {
+			continue
+		}
+		generalNames := []asn1.RawValue{}
+		if _, err := asn1.Unmarshal(extension.Value, &generalNames); err != nil {
+			return names, errors.Wrap(err, "cannot parse subject alternative names")
+		}
+		for _, generalName := range generalNames {
+			// dNSName [2] IA5String
+			if generalName.Class == asn1.ClassContextSpecific && generalName.Tag == 2 {
+				names = append(names, string(generalName.Bytes))
+			}
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,75 @@
+package cert
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// builds an RFC 6962 MerkleTreeLeaf holding the given entry
+func merkleTreeLeaf(entryType uint16, entry []byte) []byte {
+	leaf := make([]byte, 12)
+	binary.BigEndian.PutUint64(leaf[2:10], 1590000000000)
+	binary.BigEndian.PutUint16(leaf[10:12], entryType)
+	return append(leaf, entry...)
+}
+
+func uint24Prefixed(data []byte) []byte {
+	return append([]byte{byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}, data...)
+}
+
+func Test_CrtShSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "%.example.com", r.URL.Query().Get("q"))
+		require.Equal(t, "json", r.URL.Query().Get("output"))
+		w.Write([]byte(`[
+			{"common_name": "www.example.com", "name_value": "www.example.com\nexample.com"},
+			{"common_name": "*.dev.example.com", "name_value": "*.dev.example.com\nunrelated.org"}
+		]`))
+	}))
+	defer server.Close()
+
+	source := &CrtShSource{BaseURL: server.URL, Client: server.Client()}
+	names, err := source.Subdomains(context.Background(), "example.com")
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{"www.example.com", "www.example.com", "example.com", "dev.example.com", "dev.example.com"}, names)
+}
+
+func Test_CTLogSource(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	leaf := newTestLeaf(t, ca, "www.example.com", "*.shop.example.com")
+	precert := newTestLeaf(t, ca, "mail.example.com", "other.org")
+
+	entries := []logEntry{
+		{LeafInput: merkleTreeLeaf(ctX509Entry, uint24Prefixed(leaf.cert.Raw))},
+		{LeafInput: merkleTreeLeaf(ctPrecertEntry, append(make([]byte, 32), uint24Prefixed(precert.cert.RawTBSCertificate)...))},
+		{LeafInput: []byte("garbage")},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ct/v1/get-sth":
+			json.NewEncoder(w).Encode(signedTreeHead{TreeSize: int64(len(entries))})
+		case "/ct/v1/get-entries":
+			start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+			end, _ := strconv.Atoi(r.URL.Query().Get("end"))
+			// only return a single entry to exercise partial responses
+			require.True(t, start <= end)
+			json.NewEncoder(w).Encode(getEntriesResponse{Entries: entries[start : start+1]})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	source := &CTLogSource{LogURLs: []string{server.URL + "/"}, BatchSize: 2, Client: server.Client()}
+	names, err := source.Subdomains(context.Background(), "example.com")
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{"www.example.com", "www.example.com", "shop.example.com", "mail.example.com", "mail.example.com"}, names)
+}
@@ -0,0 +1,93 @@
+package cert
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const daneCheck = "dane"
+
+// TLSA certificate usages (RFC 6698)
+const (
+	tlsaPKIXTA = 0
+	tlsaPKIXEE = 1
+	tlsaDANETA = 2
+	tlsaDANEEE = 3
+)
+
+// validates the presented chains against the TLSA records of the endpoints (DANE)
+type TLSAChecker struct {
+	Resolver *DNSResolver
+}
+
+func (c *TLSAChecker) Check(result Result, now time.Time) []Finding {
+	name := result.ServerName
+	if name == "" && net.ParseIP(result.Host) == nil {
+		name = result.Host
+	}
+	if name == "" || len(result.Chain) == 0 {
+		return nil
+	}
+
+	owner := fmt.Sprintf("_%d._tcp.%s", result.Port, strings.TrimSuffix(name, "."))
+	ctx, cancel := context.WithTimeout(context.Background(), c.Resolver.Timeout)
+	defer cancel()
+	response, err := c.Resolver.Exchange(ctx, owner, dns.TypeTLSA)
+	if err != nil {
+		return []Finding{newFinding(daneCheck, "tlsa-lookup-failed", StatusWarning, -1, "cannot look up the TLSA records of %s: %s", owner, err)}
+	}
+	if response.Rcode == dns.RcodeNameError {
+		return nil
+	}
+	if response.Rcode != dns.RcodeSuccess {
+		return []Finding{newFinding(daneCheck, "tlsa-lookup-failed", StatusWarning, -1,
+			"cannot look up the TLSA records of %s: %s", owner, dns.RcodeToString[response.Rcode])}
+	}
+	answers := answersOf(response, dns.TypeTLSA)
+	if len(answers) == 0 {
+		return nil
+	}
+
+	findings := []Finding{}
+	if !response.AuthenticatedData {
+		findings = append(findings, newFinding(daneCheck, "tlsa-unsigned", StatusWarning, -1,
+			"the TLSA records of %s are not DNSSEC authenticated by the resolver", owner))
+	}
+	for _, answer := range answers {
+		record := answer.(*dns.TLSA)
+		if depth, ok := matchTLSA(record, result); ok {
+			return append(findings, newFinding(daneCheck, "tlsa-match", StatusOK, depth,
+				"the chain matches the TLSA record %d %d %d of %s", record.Usage, record.Selector, record.MatchingType, owner))
+		}
+	}
+	return append(findings, newFinding(daneCheck, "tlsa-mismatch", StatusCritical, -1,
+		"none of the %d TLSA records of %s matches the presented chain", len(answers), owner))
+}
+
+// returns the depth of the certificate of the chain matching the TLSA record
+func matchTLSA(record *dns.TLSA, result Result) (int, bool) {
+	for depth, c := range result.Chain {
+		switch record.Usage {
+		case tlsaPKIXEE, tlsaDANEEE:
+			if depth != 0 {
+				continue
+			}
+		case tlsaPKIXTA, tlsaDANETA:
+			if depth == 0 {
+				continue
+			}
+		default:
+			return 0, false
+		}
+		data, err := dns.CertificateToDANE(record.Selector, record.MatchingType, c)
+		if err == nil && strings.EqualFold(data, record.Certificate) {
+			return depth, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,47 @@
+package cert
+
+import (
+	"crypto/x509"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_TLSAChecker(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	leaf := newTestLeaf(t, ca, "www.secure.test")
+	other := newTestLeaf(t, ca, "www.secure.test")
+	leafHash, err := dns.CertificateToDANE(1, 1, leaf.cert)
+	require.Nil(t, err)
+	caHash, err := dns.CertificateToDANE(0, 1, ca.cert)
+	require.Nil(t, err)
+
+	port := startDNSServer(t,
+		fmt.Sprintf("_443._tcp.www.secure.test. 300 IN TLSA 3 1 1 %s", leafHash),
+		fmt.Sprintf("_443._tcp.www.plain.test. 300 IN TLSA 2 0 1 %s", caHash),
+	)
+	resolver, err := NewDNSResolver("127.0.0.1:"+port, time.Second)
+	require.Nil(t, err)
+	checker := &TLSAChecker{Resolver: resolver}
+	now := time.Now()
+	chain := []*x509.Certificate{leaf.cert, ca.cert}
+
+	findings := checker.Check(Result{Target: Target{Host: "192.0.2.1", Port: 443, ServerName: "www.secure.test"}, Chain: chain}, now)
+	require.Equal(t, []string{"tlsa-match"}, findingIDs(findings))
+	require.Equal(t, 0, findings[0].Depth)
+
+	findings = checker.Check(Result{Target: Target{Host: "192.0.2.1", Port: 443, ServerName: "www.secure.test"}, Chain: []*x509.Certificate{other.cert, ca.cert}}, now)
+	require.Equal(t, []string{"tlsa-mismatch"}, findingIDs(findings))
+	require.Equal(t, StatusCritical, findings[0].Status)
+
+	// the trust anchor matches but the answer is not authenticated
+	findings = checker.Check(Result{Target: Target{Host: "www.plain.test", Port: 443}, Chain: chain}, now)
+	require.Equal(t, []string{"tlsa-unsigned", "tlsa-match"}, findingIDs(findings))
+	require.Equal(t, 1, findings[1].Depth)
+
+	// no TLSA record on the port
+	require.Empty(t, checker.Check(Result{Target: Target{Host: "www.plain.test", Port: 8443}, Chain: chain}, now))
+}
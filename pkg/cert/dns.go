@@ -0,0 +1,351 @@
+package cert
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultDNSTimeout = 5 * time.Second
+	// number of random labels queried to detect wildcard records
+	wildcardProbes = 3
+)
+
+// words tried by the brute force source when no wordlist is configured
+var DefaultWordlist = []string{
+	"www", "mail", "smtp", "imap", "pop", "webmail", "mx", "ns1", "ns2", "vpn", "remote",
+	"api", "app", "portal", "intranet", "extranet", "auth", "sso", "login", "admin",
+	"dev", "test", "staging", "preprod", "prod", "git", "gitlab", "jenkins", "ci",
+	"grafana", "kibana", "prometheus", "monitoring", "wiki", "jira", "confluence",
+	"ftp", "ldap", "db", "backup", "shop", "blog", "static", "cdn", "img", "m",
+}
+
+// queries a given DNS server directly
+type DNSResolver struct {
+	// host:port of the DNS server
+	Server  string
+	Timeout time.Duration
+}
+
+// creates a resolver querying the given server, the first nameserver of
+// /etc/resolv.conf is used when none is provided
+func NewDNSResolver(server string, timeout time.Duration) (*DNSResolver, error) {
+	if timeout <= 0 {
+		timeout = defaultDNSTimeout
+	}
+	if server == "" {
+		resolvConf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read the system resolver configuration")
+		}
+		if len(resolvConf.Servers) == 0 {
+			return nil, errors.New("no nameserver configured in /etc/resolv.conf")
+		}
+		server = net.JoinHostPort(resolvConf.Servers[0], resolvConf.Port)
+	} else if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &DNSResolver{Server: server, Timeout: timeout}, nil
+}
+
+// returns the answers of the given type for name, retrying over TCP when truncated
+func (r *DNSResolver) Lookup(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	response, err := r.Exchange(ctx, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	if response.Rcode != dns.RcodeSuccess {
+		return nil, errors.Errorf("query for %s %s returned %s", name, dns.TypeToString[qtype], dns.RcodeToString[response.Rcode])
+	}
+	return answersOf(response, qtype), nil
+}
+
+// sends a query with the AD bit set, so that validating resolvers report whether the
+// answer is DNSSEC authenticated, and returns the whole response whatever its rcode
+func (r *DNSResolver) Exchange(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), qtype)
+	query.SetEdns0(4096, false)
+	query.AuthenticatedData = true
+
+	client := &dns.Client{Timeout: r.Timeout}
+	response, _, err := client.ExchangeContext(ctx, query, r.Server)
+	if err == nil && response.Truncated {
+		client.Net = "tcp"
+		response, _, err = client.ExchangeContext(ctx, query, r.Server)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot query %s for %s", r.Server, name)
+	}
+	return response, nil
+}
+
+// returns the answers of the response of the given type
+func answersOf(response *dns.Msg, qtype uint16) []dns.RR {
+	answers := []dns.RR{}
+	for _, answer := range response.Answer {
+		if answer.Header().Rrtype == qtype {
+			answers = append(answers, answer)
+		}
+	}
+	return answers
+}
+
+// returns the IPv4 and IPv6 addresses of the host
+func (r *DNSResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addrs := []string{}
+	var lastErr error
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		answers, err := r.Lookup(ctx, host, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, answer := range answers {
+			switch rr := answer.(type) {
+			case *dns.A:
+				addrs = append(addrs, rr.A.String())
+			case *dns.AAAA:
+				addrs = append(addrs, rr.AAAA.String())
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		if lastErr == nil {
+			lastErr = errors.Errorf("no address found for %s", host)
+		}
+		return nil, lastErr
+	}
+	return addrs, nil
+}
+
+// returns the names of the PTR records of the address
+func (r *DNSResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	reverse, err := dns.ReverseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	answers, err := r.Lookup(ctx, reverse, dns.TypePTR)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, answer := range answers {
+		if ptr, ok := answer.(*dns.PTR); ok {
+			names = append(names, ptr.Ptr)
+		}
+	}
+	return names, nil
+}
+
+// returns the authoritative nameservers of the domain
+func (r *DNSResolver) LookupNS(ctx context.Context, domain string) ([]string, error) {
+	answers, err := r.Lookup(ctx, domain, dns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+	nameservers := []string{}
+	for _, answer := range answers {
+		nameservers = append(nameservers, answer.(*dns.NS).Ns)
+	}
+	return nameservers, nil
+}
+
+// reads a wordlist file, one word per line, empty lines and comments are skipped
+func ReadWordlist(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	words := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words, scanner.Err()
+}
+
+// resolves every word of the wordlist as a sub-domain, answers matching a wildcard
+// record of the domain are discarded
+type BruteForceSource struct {
+	Resolver HostResolver
+	Words    []string
+	Workers  int
+}
+
+func (s *BruteForceSource) Name() string {
+	return "bruteforce"
+}
+
+func (s *BruteForceSource) Subdomains(ctx context.Context, domain string) ([]string, error) {
+	wildcardAddrs, err := s.wildcardAddresses(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []string{}
+	for _, word := range s.Words {
+		candidates = append(candidates, word+"."+domain)
+	}
+	workers := s.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+
+	names := []string{}
+	for host, addrs := range ResolveHosts(ctx, s.Resolver, candidates, workers) {
+		for _, addr := range addrs {
+			if !wildcardAddrs[addr] {
+				names = append(names, host)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// returns the addresses answered for random labels of the domain, if any
+func (s *BruteForceSource) wildcardAddresses(ctx context.Context, domain string) (map[string]bool, error) {
+	addrs := map[string]bool{}
+	for i := 0; i < wildcardProbes; i++ {
+		label := make([]byte, 12)
+		if _, err := rand.Read(label); err != nil {
+			return nil, err
+		}
+		resolved, err := s.Resolver.LookupHost(ctx, hex.EncodeToString(label)+"."+domain)
+		if err != nil {
+			continue
+		}
+		for _, addr := range resolved {
+			addrs[addr] = true
+		}
+	}
+	return addrs, nil
+}
+
+// attempts a zone transfer from every authoritative nameserver of the domain
+type AXFRSource struct {
+	Resolver *DNSResolver
+	// port the nameservers are contacted on, 53 when empty
+	Port    string
+	Timeout time.Duration
+}
+
+func (s *AXFRSource) Name() string {
+	return "axfr"
+}
+
+// returns the owner names of the transferred records. Refused transfers are expected
+// and not reported, an error is only returned when no nameserver could be reached.
+func (s *AXFRSource) Subdomains(ctx context.Context, domain string) ([]string, error) {
+	nameservers, err := s.Resolver.LookupNS(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	port := s.Port
+	if port == "" {
+		port = "53"
+	}
+
+	names := map[string]bool{}
+	lock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	reached := false
+	var lastErr error
+	for _, nameserver := range nameservers {
+		addrs, err := s.Resolver.LookupHost(ctx, nameserver)
+		if err != nil {
+			lock.Lock()
+			lastErr = err
+			lock.Unlock()
+			continue
+		}
+		for _, addr := range addrs {
+			wg.Add(1)
+			go func(addr string) {
+				defer wg.Done()
+				transferred, err := s.transfer(domain, net.JoinHostPort(addr, port))
+				lock.Lock()
+				defer lock.Unlock()
+				if err != nil {
+					lastErr = err
+					return
+				}
+				reached = true
+				for _, name := range transferred {
+					names[name] = true
+				}
+			}(addr)
+		}
+	}
+	wg.Wait()
+
+	if !reached && lastErr != nil {
+		return nil, lastErr
+	}
+	result := []string{}
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// returns the owner names of the zone, an empty list if the transfer was refused
+func (s *AXFRSource) transfer(domain string, address string) ([]string, error) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultDNSTimeout
+	}
+	query := new(dns.Msg)
+	query.SetAxfr(dns.Fqdn(domain))
+
+	transfer := &dns.Transfer{DialTimeout: timeout, ReadTimeout: timeout, WriteTimeout: timeout}
+	envelopes, err := transfer.In(query, address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot reach nameserver %s", address)
+	}
+
+	names := []string{}
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			if isTransferRefusal(envelope.Error) {
+				return []string{}, nil
+			}
+			return nil, errors.Wrapf(envelope.Error, "zone transfer of %s from %s failed", domain, address)
+		}
+		for _, rr := range envelope.RR {
+			names = append(names, rr.Header().Name)
+		}
+	}
+	return names, nil
+}
+
+// returns true if the nameserver answered but denied the transfer
+func isTransferRefusal(err error) bool {
+	if err == io.EOF || err == dns.ErrSoa {
+		return true
+	}
+	// servers answer with an error rcode or simply close the connection
+	message := err.Error()
+	return strings.HasPrefix(message, "dns: bad xfr rcode") || strings.Contains(message, "connection reset")
+}
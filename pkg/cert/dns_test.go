@@ -0,0 +1,141 @@
+package cert
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// records served by the test DNS server
+var testZone = []string{
+	"example.test. 300 IN SOA ns1.example.test. admin.example.test. 1 3600 600 86400 300",
+	"example.test. 300 IN NS ns1.example.test.",
+	"ns1.example.test. 300 IN A 127.0.0.1",
+	"www.example.test. 300 IN A 192.0.2.10",
+	"mail.example.test. 300 IN A 192.0.2.11",
+	"hidden.example.test. 300 IN AAAA 2001:db8::11",
+	"*.wild.test. 300 IN A 192.0.2.99",
+	"www.wild.test. 300 IN A 192.0.2.20",
+	"10.2.0.192.in-addr.arpa. 300 IN PTR www.example.test.",
+}
+
+// starts a DNS server on localhost answering from testZone and the extra records,
+// zone transfers are only allowed for example.test and the answers under secure.test
+// are flagged as DNSSEC authenticated. Returns the port shared by UDP and TCP.
+func startDNSServer(t *testing.T, extra ...string) string {
+	records := []dns.RR{}
+	for _, line := range append(append([]string{}, testZone...), extra...) {
+		rr, err := dns.NewRR(line)
+		require.Nil(t, err)
+		records = append(records, rr)
+	}
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		question := r.Question[0]
+		if question.Qtype == dns.TypeAXFR {
+			if question.Name != "example.test." {
+				m := new(dns.Msg)
+				m.SetRcode(r, dns.RcodeRefused)
+				w.WriteMsg(m)
+				return
+			}
+			envelopes := make(chan *dns.Envelope)
+			transfer := new(dns.Transfer)
+			go func() {
+				envelopes <- &dns.Envelope{RR: append(append([]dns.RR{records[0]}, records[1:6]...), records[0])}
+				close(envelopes)
+			}()
+			transfer.Out(w, r, envelopes)
+			w.Hijack()
+			return
+		}
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		exact := false
+		for _, rr := range records {
+			if strings.EqualFold(rr.Header().Name, question.Name) {
+				exact = true
+				if rr.Header().Rrtype == question.Qtype {
+					m.Answer = append(m.Answer, rr)
+				}
+			}
+		}
+		if !exact && strings.HasSuffix(question.Name, ".wild.test.") && question.Qtype == dns.TypeA {
+			rr := dns.Copy(records[6])
+			rr.Header().Name = question.Name
+			m.Answer = append(m.Answer, rr)
+			exact = true
+		}
+		if !exact {
+			m.SetRcode(r, dns.RcodeNameError)
+		}
+		m.AuthenticatedData = len(m.Answer) != 0 && strings.HasSuffix(question.Name, ".secure.test.")
+		w.WriteMsg(m)
+	})
+
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	_, port, _ := net.SplitHostPort(tcpListener.Addr().String())
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:"+port)
+	require.Nil(t, err)
+
+	tcpServer := &dns.Server{Listener: tcpListener, Handler: handler}
+	udpServer := &dns.Server{PacketConn: udpConn, Handler: handler}
+	go tcpServer.ActivateAndServe()
+	go udpServer.ActivateAndServe()
+	t.Cleanup(func() {
+		tcpServer.Shutdown()
+		udpServer.Shutdown()
+	})
+	return port
+}
+
+func Test_DNSResolver(t *testing.T) {
+	port := startDNSServer(t)
+	resolver, err := NewDNSResolver("127.0.0.1:"+port, time.Second)
+	require.Nil(t, err)
+
+	addrs, err := resolver.LookupHost(context.Background(), "hidden.example.test")
+	require.Nil(t, err)
+	require.Equal(t, []string{"2001:db8::11"}, addrs)
+
+	_, err = resolver.LookupHost(context.Background(), "nope.example.test")
+	require.NotNil(t, err)
+
+	names, err := resolver.LookupAddr(context.Background(), "192.0.2.10")
+	require.Nil(t, err)
+	require.Equal(t, []string{"www.example.test."}, names)
+}
+
+func Test_BruteForceSource(t *testing.T) {
+	port := startDNSServer(t)
+	resolver, err := NewDNSResolver("127.0.0.1:"+port, time.Second)
+	require.Nil(t, err)
+
+	source := &BruteForceSource{Resolver: resolver, Words: []string{"www", "mail", "ftp"}, Workers: 2}
+	names, err := source.Subdomains(context.Background(), "example.test")
+	require.Nil(t, err)
+	require.Equal(t, []string{"mail.example.test", "www.example.test"}, names)
+
+	// only the record differing from the wildcard is kept
+	names, err = source.Subdomains(context.Background(), "wild.test")
+	require.Nil(t, err)
+	require.Equal(t, []string{"www.wild.test"}, names)
+}
+
+func Test_AXFRSource(t *testing.T) {
+	port := startDNSServer(t)
+	resolver, err := NewDNSResolver("127.0.0.1:"+port, time.Second)
+	require.Nil(t, err)
+
+	source := &AXFRSource{Resolver: resolver, Port: port, Timeout: time.Second}
+	hosts, errs := DiscoverSubdomains(context.Background(), []SubdomainSource{source}, []string{"example.test"})
+	require.Empty(t, errs)
+	require.Equal(t, []string{"example.test", "hidden.example.test", "mail.example.test", "ns1.example.test", "www.example.test"}, hosts)
+}
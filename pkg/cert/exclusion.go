@@ -0,0 +1,69 @@
+package cert
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// addresses and host names that must never be probed. A nil list excludes nothing.
+type Exclusions struct {
+	Networks []*net.IPNet
+	// host names, *.example.com excludes all the sub-domains of example.com
+	Names []string
+}
+
+// parses the excluded CIDRs, addresses and host names
+func ParseExclusions(entries []string) (*Exclusions, error) {
+	exclusions := &Exclusions{}
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if _, ipnet, err := net.ParseCIDR(entry); err == nil {
+			exclusions.Networks = append(exclusions.Networks, ipnet)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			exclusions.Networks = append(exclusions.Networks, singleHostNet(ip))
+			continue
+		}
+		if strings.ContainsAny(entry, "/ :") {
+			return nil, errors.Errorf("invalid exclusion %s", entry)
+		}
+		exclusions.Names = append(exclusions.Names, strings.TrimSuffix(entry, "."))
+	}
+	return exclusions, nil
+}
+
+// returns true if the host or the server name of the target is excluded
+func (e *Exclusions) Excludes(target Target) bool {
+	if e == nil {
+		return false
+	}
+	return e.excludesHost(target.Host) || (target.ServerName != "" && e.excludesHost(target.ServerName))
+}
+
+func (e *Exclusions) excludesHost(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range e.Networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, name := range e.Names {
+		if strings.HasPrefix(name, "*.") {
+			if strings.HasSuffix(host, name[1:]) {
+				return true
+			}
+		} else if host == name {
+			return true
+		}
+	}
+	return false
+}
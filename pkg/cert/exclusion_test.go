@@ -0,0 +1,48 @@
+package cert
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Exclusions(t *testing.T) {
+	exclusions, err := ParseExclusions([]string{"10.1.0.0/16", "192.168.1.7", "2001:db8::/64", "db.example.com", "*.prod.example.com"})
+	require.Nil(t, err)
+
+	require.True(t, exclusions.Excludes(Target{Host: "10.1.2.3", Port: 443}))
+	require.False(t, exclusions.Excludes(Target{Host: "10.2.0.1", Port: 443}))
+	require.True(t, exclusions.Excludes(Target{Host: "192.168.1.7", Port: 443}))
+	require.True(t, exclusions.Excludes(Target{Host: "2001:db8::1", Port: 443}))
+	require.True(t, exclusions.Excludes(Target{Host: "DB.example.com.", Port: 443}))
+	require.True(t, exclusions.Excludes(Target{Host: "10.2.0.1", Port: 443, ServerName: "api.prod.example.com"}))
+	require.False(t, exclusions.Excludes(Target{Host: "prod.example.com", Port: 443}))
+
+	var none *Exclusions
+	require.False(t, none.Excludes(Target{Host: "10.1.2.3", Port: 443}))
+
+	_, err = ParseExclusions([]string{"10.0.0.0/33"})
+	require.NotNil(t, err)
+}
+
+func Test_ProbeExcluded(t *testing.T) {
+	exclusions, err := ParseExclusions([]string{"127.0.0.0/8"})
+	require.Nil(t, err)
+	conf, err := NewScanConfig([]int{443}, 1, 1)
+	require.Nil(t, err)
+	conf.Exclusions = exclusions
+
+	result := NewScanner(conf).Probe(context.Background(), Target{Host: "127.0.0.1", Port: 443})
+	require.NotNil(t, result.Err)
+	require.False(t, result.Reachable)
+
+	targets := make(chan Target, 1)
+	targets <- Target{Host: "127.0.0.1", Port: 443}
+	close(targets)
+	portScanner := NewPortScanner(1, 0, 0)
+	portScanner.Exclusions = exclusions
+	for range portScanner.Run(context.Background(), targets) {
+		t.Fatal("excluded target scanned")
+	}
+}
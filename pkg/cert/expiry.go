@@ -0,0 +1,174 @@
+package cert
+
+import (
+	"crypto/x509"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultWarningDays  = 30
+	defaultCriticalDays = 7
+)
+
+// Nagios/Icinga plugin exit codes
+const (
+	ExitOK       = 0
+	ExitWarning  = 1
+	ExitCritical = 2
+	ExitUnknown  = 3
+)
+
+// status of a certificate, ordered by severity
+type Status int
+
+const (
+	StatusOK Status = iota
+	StatusWarning
+	StatusCritical
+	StatusExpired
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "OK"
+	case StatusWarning:
+		return "WARNING"
+	case StatusCritical:
+		return "CRITICAL"
+	case StatusExpired:
+		return "EXPIRED"
+	}
+	return "UNKNOWN"
+}
+
+// returns the plugin exit code of the status, an expired certificate is critical
+func (s Status) ExitCode() int {
+	switch s {
+	case StatusOK:
+		return ExitOK
+	case StatusWarning:
+		return ExitWarning
+	case StatusCritical, StatusExpired:
+		return ExitCritical
+	}
+	return ExitUnknown
+}
+
+type ExpiryConfig struct {
+	WarningDays  int
+	CriticalDays int
+}
+
+// creates the expiry thresholds, given in days before notAfter
+func NewExpiryConfig(warningDays int, criticalDays int) (ExpiryConfig, error) {
+	// set default values
+	if warningDays <= 0 {
+		warningDays = defaultWarningDays
+	}
+	if criticalDays <= 0 {
+		criticalDays = defaultCriticalDays
+	}
+	if criticalDays > warningDays {
+		return ExpiryConfig{}, errors.Errorf("critical threshold (%d days) is greater than the warning threshold (%d days)", criticalDays, warningDays)
+	}
+
+	return ExpiryConfig{
+		WarningDays:  warningDays,
+		CriticalDays: criticalDays,
+	}, nil
+}
+
+// returns the number of whole days left before the certificate expires, negative once expired
+func DaysLeft(c *x509.Certificate, now time.Time) int {
+	return DaysUntil(c.NotAfter, now)
+}
+
+// returns the number of whole days until notAfter, negative once it is past
+func DaysUntil(notAfter time.Time, now time.Time) int {
+	return int(math.Floor(notAfter.Sub(now).Hours() / 24))
+}
+
+// classifies the certificate according to its notAfter date
+func (e ExpiryConfig) Evaluate(c *x509.Certificate, now time.Time) Status {
+	return e.EvaluateNotAfter(c.NotAfter, now)
+}
+
+// classifies an expiry date known without its certificate
+func (e ExpiryConfig) EvaluateNotAfter(notAfter time.Time, now time.Time) Status {
+	left := notAfter.Sub(now)
+	switch {
+	case left <= 0:
+		return StatusExpired
+	case left <= time.Duration(e.CriticalDays)*24*time.Hour:
+		return StatusCritical
+	case left <= time.Duration(e.WarningDays)*24*time.Hour:
+		return StatusWarning
+	}
+	return StatusOK
+}
+
+// aggregates the statuses of all the evaluated certificates
+type Summary struct {
+	Expiry      ExpiryConfig
+	Total       int
+	Counts      map[Status]int
+	MinDaysLeft int
+	Findings    int
+	Status      Status
+}
+
+func NewSummary(expiry ExpiryConfig) *Summary {
+	return &Summary{Expiry: expiry, Counts: map[Status]int{}}
+}
+
+// records the status of a certificate
+func (s *Summary) Add(status Status, daysLeft int) {
+	if s.Total == 0 || daysLeft < s.MinDaysLeft {
+		s.MinDaysLeft = daysLeft
+	}
+	s.Total++
+	s.Counts[status]++
+	if status > s.Status {
+		s.Status = status
+	}
+}
+
+// records a finding, it raises the overall status without counting a certificate
+func (s *Summary) AddFinding(finding Finding) {
+	if finding.Status == StatusOK {
+		return
+	}
+	s.Findings++
+	if finding.Status > s.Status {
+		s.Status = finding.Status
+	}
+}
+
+// returns the plugin exit code, unknown when no certificate was found
+func (s *Summary) ExitCode() int {
+	if s.Total == 0 {
+		return ExitUnknown
+	}
+	return s.Status.ExitCode()
+}
+
+// returns the one-line plugin output with its performance data
+func (s *Summary) String() string {
+	if s.Total == 0 {
+		return "CERTS UNKNOWN - no certificate found | certificates=0"
+	}
+	label := s.Status.String()
+	if s.Status == StatusExpired {
+		label = StatusCritical.String()
+	}
+	return fmt.Sprintf("CERTS %s - %d certificates: %d OK, %d WARNING, %d CRITICAL, %d EXPIRED, soonest expiry in %d days, %d findings"+
+		" | certificates=%d ok=%d warning=%d critical=%d expired=%d findings=%d min_days_left=%d;%d;%d",
+		label, s.Total, s.Counts[StatusOK], s.Counts[StatusWarning], s.Counts[StatusCritical], s.Counts[StatusExpired], s.MinDaysLeft, s.Findings,
+		s.Total, s.Counts[StatusOK], s.Counts[StatusWarning], s.Counts[StatusCritical], s.Counts[StatusExpired], s.Findings,
+		s.MinDaysLeft, s.Expiry.WarningDays, s.Expiry.CriticalDays)
+}
@@ -0,0 +1,58 @@
+package cert
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewExpiryConfig(t *testing.T) {
+	conf, err := NewExpiryConfig(0, 0)
+	require.Nil(t, err)
+	require.Equal(t, ExpiryConfig{WarningDays: defaultWarningDays, CriticalDays: defaultCriticalDays}, conf)
+
+	_, err = NewExpiryConfig(10, 20)
+	require.NotNil(t, err)
+}
+
+func Test_EvaluateExpiry(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	conf, err := NewExpiryConfig(30, 7)
+	require.Nil(t, err)
+
+	expiring := func(days int) *x509.Certificate {
+		return &x509.Certificate{NotAfter: now.Add(time.Duration(days) * 24 * time.Hour)}
+	}
+	require.Equal(t, StatusOK, conf.Evaluate(expiring(31), now))
+	require.Equal(t, StatusWarning, conf.Evaluate(expiring(30), now))
+	require.Equal(t, StatusCritical, conf.Evaluate(expiring(7), now))
+	require.Equal(t, StatusExpired, conf.Evaluate(expiring(-1), now))
+	require.Equal(t, -1, DaysLeft(expiring(-1), now))
+	require.Equal(t, 31, DaysLeft(expiring(31), now))
+}
+
+func Test_Summary(t *testing.T) {
+	conf, err := NewExpiryConfig(30, 7)
+	require.Nil(t, err)
+
+	summary := NewSummary(conf)
+	require.Equal(t, ExitUnknown, summary.ExitCode())
+
+	summary.Add(StatusOK, 200)
+	summary.Add(StatusWarning, 20)
+	require.Equal(t, ExitWarning, summary.ExitCode())
+	summary.Add(StatusExpired, -3)
+	require.Equal(t, ExitCritical, summary.ExitCode())
+	require.Equal(t, "CERTS CRITICAL - 3 certificates: 1 OK, 1 WARNING, 0 CRITICAL, 1 EXPIRED, soonest expiry in -3 days, 0 findings"+
+		" | certificates=3 ok=1 warning=1 critical=0 expired=1 findings=0 min_days_left=-3;30;7", summary.String())
+
+	summary = NewSummary(conf)
+	summary.Add(StatusOK, 200)
+	summary.AddFinding(Finding{Status: StatusOK})
+	require.Equal(t, 0, summary.Findings)
+	summary.AddFinding(Finding{Status: StatusCritical})
+	require.Equal(t, ExitCritical, summary.ExitCode())
+	require.Equal(t, 1, summary.Findings)
+}
@@ -0,0 +1,321 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pkcs12"
+)
+
+const (
+	// larger files are not considered as certificate containers
+	maxCertificateFileSize = 16 * 1024 * 1024
+
+	jksMagic   = 0xfeedfeed
+	jceksMagic = 0xcececece
+
+	jksPrivateKeyEntry  = 1
+	jksTrustedCertEntry = 2
+)
+
+var oidPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+// a certificate found in a file
+type FileCertificate struct {
+	Path string
+	// pem, der, pkcs7, pkcs12 or jks
+	Format string
+	// alias or friendly name of the entry in a keystore
+	Alias       string
+	Certificate *x509.Certificate
+}
+
+// the ASN.1 structures of a PKCS#7 certificate bundle
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+// the outer structure of a PKCS#12 file
+type pfxPDU struct {
+	Version  int
+	AuthSafe asn1.RawValue
+	MacData  asn1.RawValue `asn1:"optional"`
+}
+
+// walks the given files and directories and returns the certificates found in the
+// certificate files and keystores. Files in none of the supported formats are ignored,
+// errors are only returned for the unreadable files and the keystores that cannot be opened.
+func ScanFiles(roots []string, passwords []string) ([]FileCertificate, []error) {
+	certificates := []FileCertificate{}
+	errs := []error{}
+	for _, root := range roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+			if !info.Mode().IsRegular() || info.Size() > maxCertificateFileSize {
+				return nil
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+			found, err := ParseCertificateFile(path, data, passwords)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			certificates = append(certificates, found...)
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return certificates, errs
+}
+
+// returns the certificates of a PEM, DER, PKCS#7, PKCS#12 or Java KeyStore file, none
+// if it is in another format. The PKCS#12 files are opened with the first matching password.
+func ParseCertificateFile(path string, data []byte, passwords []string) ([]FileCertificate, error) {
+	found := func(format string, alias string, certificates ...*x509.Certificate) []FileCertificate {
+		files := []FileCertificate{}
+		for _, c := range certificates {
+			files = append(files, FileCertificate{Path: path, Format: format, Alias: alias, Certificate: c})
+		}
+		return files
+	}
+
+	if bytes.Contains(data, []byte("-----BEGIN ")) {
+		files := []FileCertificate{}
+		for rest := data; ; {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			switch block.Type {
+			case "CERTIFICATE", "TRUSTED CERTIFICATE", "X509 CERTIFICATE":
+				c, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return files, errors.Wrapf(err, "invalid certificate in %s", path)
+				}
+				files = append(files, found("pem", "", c)...)
+			case "PKCS7":
+				certificates, err := parsePKCS7(block.Bytes)
+				if err != nil {
+					return files, errors.Wrapf(err, "invalid PKCS#7 bundle in %s", path)
+				}
+				files = append(files, found("pkcs7", "", certificates...)...)
+			}
+		}
+		return files, nil
+	}
+
+	if len(data) >= 4 {
+		if magic := binary.BigEndian.Uint32(data); magic == jksMagic || magic == jceksMagic {
+			files, err := parseJKS(path, data)
+			return files, errors.Wrapf(err, "invalid Java KeyStore %s", path)
+		}
+	}
+
+	if certificates, err := x509.ParseCertificates(data); err == nil && len(certificates) != 0 {
+		return found("der", "", certificates...), nil
+	}
+	if certificates, err := parsePKCS7(data); err == nil {
+		return found("pkcs7", "", certificates...), nil
+	}
+
+	pfx := pfxPDU{}
+	if rest, err := asn1.Unmarshal(data, &pfx); err == nil && len(rest) == 0 && pfx.Version == 3 {
+		return parsePKCS12(path, data, passwords)
+	}
+	return nil, nil
+}
+
+// returns the certificates of a PKCS#7 SignedData structure
+func parsePKCS7(der []byte) ([]*x509.Certificate, error) {
+	info := pkcs7ContentInfo{}
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.ContentType.Equal(oidPKCS7SignedData) {
+		return nil, errors.Errorf("unexpected PKCS#7 content type %s", info.ContentType)
+	}
+	signedData := pkcs7SignedData{}
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signedData); err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificates(signedData.Certificates.Bytes)
+}
+
+// returns the certificates of a PKCS#12 file opened with the first matching password
+func parsePKCS12(path string, data []byte, passwords []string) ([]FileCertificate, error) {
+	// an empty password is tried last
+	for _, password := range append(append([]string{}, passwords...), "") {
+		blocks, err := pkcs12.ToPEM(data, password)
+		if err == pkcs12.ErrIncorrectPassword {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot open PKCS#12 file %s", path)
+		}
+
+		files := []FileCertificate{}
+		for _, block := range blocks {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return files, errors.Wrapf(err, "invalid certificate in %s", path)
+			}
+			files = append(files, FileCertificate{Path: path, Format: "pkcs12", Alias: block.Headers["friendlyName"], Certificate: c})
+		}
+		return files, nil
+	}
+	return nil, errors.Errorf("none of the configured passwords opens PKCS#12 file %s", path)
+}
+
+// returns the certificates of the private key and trusted certificate entries of a
+// JKS or JCEKS keystore, the certificates are not protected by the store password
+func parseJKS(path string, data []byte) ([]FileCertificate, error) {
+	reader := bytes.NewReader(data)
+	header := struct{ Magic, Version, Count uint32 }{}
+	if err := binary.Read(reader, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Version != 1 && header.Version != 2 {
+		return nil, errors.Errorf("unsupported version %d", header.Version)
+	}
+
+	readUint32 := func() (uint32, error) {
+		var value uint32
+		err := binary.Read(reader, binary.BigEndian, &value)
+		return value, err
+	}
+	readBytes := func(length int) ([]byte, error) {
+		if length > reader.Len() {
+			return nil, io.ErrUnexpectedEOF
+		}
+		value := make([]byte, length)
+		_, err := io.ReadFull(reader, value)
+		return value, err
+	}
+	readUTF := func() (string, error) {
+		var length uint16
+		if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+			return "", err
+		}
+		value, err := readBytes(int(length))
+		return string(value), err
+	}
+	readCertificate := func() (*x509.Certificate, error) {
+		// version 1 stores only X.509 certificates without their type
+		if header.Version == 2 {
+			certificateType, err := readUTF()
+			if err != nil {
+				return nil, err
+			}
+			if certificateType != "X.509" {
+				return nil, errors.Errorf("unsupported certificate type %s", certificateType)
+			}
+		}
+		length, err := readUint32()
+		if err != nil {
+			return nil, err
+		}
+		der, err := readBytes(int(length))
+		if err != nil {
+			return nil, err
+		}
+		return x509.ParseCertificate(der)
+	}
+
+	files := []FileCertificate{}
+	for i := uint32(0); i < header.Count; i++ {
+		tag, err := readUint32()
+		if err != nil {
+			return files, err
+		}
+		alias, err := readUTF()
+		if err != nil {
+			return files, err
+		}
+		// creation date
+		if _, err := readBytes(8); err != nil {
+			return files, err
+		}
+
+		switch tag {
+		case jksPrivateKeyEntry:
+			keyLength, err := readUint32()
+			if err != nil {
+				return files, err
+			}
+			if _, err := readBytes(int(keyLength)); err != nil {
+				return files, err
+			}
+			chainLength, err := readUint32()
+			if err != nil {
+				return files, err
+			}
+			for j := uint32(0); j < chainLength; j++ {
+				c, err := readCertificate()
+				if err != nil {
+					return files, errors.Wrapf(err, "invalid certificate for alias %s", alias)
+				}
+				files = append(files, FileCertificate{Path: path, Format: "jks", Alias: alias, Certificate: c})
+			}
+		case jksTrustedCertEntry:
+			c, err := readCertificate()
+			if err != nil {
+				return files, errors.Wrapf(err, "invalid certificate for alias %s", alias)
+			}
+			files = append(files, FileCertificate{Path: path, Format: "jks", Alias: alias, Certificate: c})
+		default:
+			// the secret key entries of JCEKS are serialized Java objects, they cannot be skipped
+			return files, errors.Errorf("unsupported entry type %d for alias %s", tag, alias)
+		}
+	}
+	return files, nil
+}
+
+// returns the type and size of the public key of the certificate, e.g. RSA 2048
+func KeyType(c *x509.Certificate) string {
+	switch key := c.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ECDSA %s", key.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "Ed25519"
+	case *dsa.PublicKey:
+		return fmt.Sprintf("DSA %d", key.P.BitLen())
+	}
+	return c.PublicKeyAlgorithm.String()
+}
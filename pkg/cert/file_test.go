@@ -0,0 +1,116 @@
+package cert
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// returns a JKS keystore with a private key entry and a trusted certificate entry
+func newTestJKS(t *testing.T, chain []*testCert, trusted *testCert) []byte {
+	buffer := &bytes.Buffer{}
+	write := func(values ...interface{}) {
+		for _, value := range values {
+			require.Nil(t, binary.Write(buffer, binary.BigEndian, value))
+		}
+	}
+	writeUTF := func(value string) {
+		write(uint16(len(value)), []byte(value))
+	}
+	writeCertificate := func(c *testCert) {
+		writeUTF("X.509")
+		write(uint32(len(c.cert.Raw)), c.cert.Raw)
+	}
+
+	write(uint32(jksMagic), uint32(2), uint32(2))
+	write(uint32(jksPrivateKeyEntry))
+	writeUTF("server")
+	write(int64(0), uint32(4), []byte("junk"), uint32(len(chain)))
+	for _, c := range chain {
+		writeCertificate(c)
+	}
+	write(uint32(jksTrustedCertEntry))
+	writeUTF("root")
+	write(int64(0))
+	writeCertificate(trusted)
+	// integrity digest, not verified
+	write(make([]byte, 20))
+	return buffer.Bytes()
+}
+
+func Test_ScanFiles(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	leaf := newTestLeaf(t, ca, "www.example.com")
+	dir := tempDir(t)
+	write := func(name string, data []byte) {
+		path := filepath.Join(dir, name)
+		require.Nil(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.Nil(t, ioutil.WriteFile(path, data, 0600))
+	}
+
+	chainPEM := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.cert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+	write("nginx/chain.pem", chainPEM)
+	write("der/leaf.cer", leaf.cert.Raw)
+
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true},
+		ContentInfo:      asn1.RawValue{FullBytes: []byte{0x30, 0x0b, 0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x07, 0x01}},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: append(leaf.cert.Raw, ca.cert.Raw...)},
+		SignerInfos:      asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true},
+	})
+	require.Nil(t, err)
+	p7b, err := asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidPKCS7SignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+	require.Nil(t, err)
+	write("bundle.p7b", p7b)
+	write("bundle.p7c", pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: p7b}))
+
+	write("app/keystore.jks", newTestJKS(t, []*testCert{leaf, ca}, ca))
+	p12, err := ioutil.ReadFile("testdata/client.p12")
+	require.Nil(t, err)
+	write("app/client.p12", p12)
+
+	write("app/application.yaml", []byte("server:\n  port: 8443\n"))
+	write("app/key.pem", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte("not a key")}))
+
+	certificates, errs := ScanFiles([]string{dir}, []string{"wrong", "sentinel"})
+	require.Empty(t, errs)
+	found := []string{}
+	for _, file := range certificates {
+		rel, err := filepath.Rel(dir, file.Path)
+		require.Nil(t, err)
+		found = append(found, rel+" "+file.Format+" "+file.Alias+" "+file.Certificate.Subject.CommonName)
+	}
+	require.ElementsMatch(t, []string{
+		"nginx/chain.pem pem  www.example.com",
+		"nginx/chain.pem pem  Sentinel Test CA",
+		"der/leaf.cer der  www.example.com",
+		"bundle.p7b pkcs7  www.example.com",
+		"bundle.p7b pkcs7  Sentinel Test CA",
+		"bundle.p7c pkcs7  www.example.com",
+		"bundle.p7c pkcs7  Sentinel Test CA",
+		"app/keystore.jks jks server www.example.com",
+		"app/keystore.jks jks server Sentinel Test CA",
+		"app/keystore.jks jks root Sentinel Test CA",
+		"app/client.p12 pkcs12 client client.example.com",
+	}, found)
+
+	_, errs = ScanFiles([]string{filepath.Join(dir, "app"), filepath.Join(dir, "missing")}, nil)
+	require.Len(t, errs, 2)
+}
+
+func Test_KeyType(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	require.Equal(t, "ECDSA P-256", KeyType(ca.cert))
+}
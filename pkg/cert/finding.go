@@ -0,0 +1,53 @@
+package cert
+
+import (
+	"fmt"
+	"time"
+)
+
+// a problem detected on an endpoint or one of its certificates
+type Finding struct {
+	// check that produced the finding, e.g. chain
+	Check string
+	// identifier of the problem, e.g. missing-intermediate
+	ID     string
+	Status Status
+	// position in the chain of the concerned certificate, -1 for the endpoint itself
+	Depth   int
+	Message string
+}
+
+// an evaluation of the endpoints producing findings
+type Checker interface {
+	Check(result Result, now time.Time) []Finding
+}
+
+// runs all the checkers on the results and records their findings
+func RunCheckers(results []Result, checkers []Checker, now time.Time) {
+	for i := range results {
+		for _, checker := range checkers {
+			results[i].Findings = append(results[i].Findings, checker.Check(results[i], now)...)
+		}
+	}
+}
+
+func newFinding(check string, id string, status Status, depth int, format string, args ...interface{}) Finding {
+	return Finding{
+		Check:   check,
+		ID:      id,
+		Status:  status,
+		Depth:   depth,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// returns the highest status of the findings, OK when there is none
+func WorstStatus(findings []Finding) Status {
+	worst := StatusOK
+	for _, finding := range findings {
+		if finding.Status > worst {
+			worst = finding.Status
+		}
+	}
+	return worst
+}
@@ -0,0 +1,175 @@
+package cert
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	hygieneCheck = "hygiene"
+
+	defaultMinRSABits      = 2048
+	defaultMinECBits       = 256
+	defaultMaxValidityDays = 398
+)
+
+// default severity of the hygiene rules
+var DefaultHygieneRules = map[string]Status{
+	"weak-key":       StatusCritical,
+	"weak-signature": StatusCritical,
+	"long-validity":  StatusWarning,
+	"missing-san":    StatusWarning,
+	"deprecated-tls": StatusWarning,
+	"weak-cipher":    StatusWarning,
+}
+
+// signature algorithms relying on a broken hash
+var weakSignatureAlgorithms = map[x509.SignatureAlgorithm]bool{
+	x509.MD2WithRSA:    true,
+	x509.MD5WithRSA:    true,
+	x509.SHA1WithRSA:   true,
+	x509.DSAWithSHA1:   true,
+	x509.ECDSAWithSHA1: true,
+}
+
+// cipher suites with a broken cipher or without forward secrecy
+var weakCipherSuites = map[uint16]bool{
+	tls.TLS_RSA_WITH_RC4_128_SHA:            true,
+	tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA:       true,
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA:        true,
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA:        true,
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA256:     true,
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256:     true,
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384:     true,
+	tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA:    true,
+	tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA:      true,
+	tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA: true,
+}
+
+// flags the weak keys, signatures and protocols of the endpoints
+type HygieneChecker struct {
+	MinRSABits      int
+	MinECBits       int
+	MaxValidityDays int
+	// severity of the enabled rules by identifier
+	Rules map[string]Status
+}
+
+// creates a hygiene checker, zero thresholds get the defaults. The severities override
+// the default ones by rule, a rule is disabled with "off".
+func NewHygieneChecker(minRSABits int, minECBits int, maxValidityDays int, severities map[string]string) (*HygieneChecker, error) {
+	if minRSABits <= 0 {
+		minRSABits = defaultMinRSABits
+	}
+	if minECBits <= 0 {
+		minECBits = defaultMinECBits
+	}
+	if maxValidityDays <= 0 {
+		maxValidityDays = defaultMaxValidityDays
+	}
+
+	rules := map[string]Status{}
+	for rule, status := range DefaultHygieneRules {
+		rules[rule] = status
+	}
+	for rule, severity := range severities {
+		if _, ok := DefaultHygieneRules[rule]; !ok {
+			return nil, errors.Errorf("unknown hygiene rule %s", rule)
+		}
+		switch strings.ToLower(severity) {
+		case "off":
+			delete(rules, rule)
+		case "warning":
+			rules[rule] = StatusWarning
+		case "critical":
+			rules[rule] = StatusCritical
+		default:
+			return nil, errors.Errorf("invalid severity %s for hygiene rule %s, expected warning, critical or off", severity, rule)
+		}
+	}
+
+	return &HygieneChecker{
+		MinRSABits:      minRSABits,
+		MinECBits:       minECBits,
+		MaxValidityDays: maxValidityDays,
+		Rules:           rules,
+	}, nil
+}
+
+// returns the hygiene problems of the endpoint and of its certificates
+func (h *HygieneChecker) Check(result Result, now time.Time) []Finding {
+	findings := []Finding{}
+	add := func(rule string, depth int, format string, args ...interface{}) {
+		if status, ok := h.Rules[rule]; ok {
+			findings = append(findings, newFinding(hygieneCheck, rule, status, depth, format, args...))
+		}
+	}
+
+	if result.Version != 0 && result.Version < tls.VersionTLS12 {
+		add("deprecated-tls", -1, "the endpoint negotiated the deprecated %s", tlsVersionName(result.Version))
+	}
+	if weakCipherSuites[result.CipherSuite] {
+		add("weak-cipher", -1, "the endpoint negotiated the weak cipher suite %s", tls.CipherSuiteName(result.CipherSuite))
+	}
+
+	for depth, c := range result.Chain {
+		if weakness := h.keyWeakness(c); weakness != "" {
+			add("weak-key", depth, "%s has a weak %s", c.Subject.CommonName, weakness)
+		}
+		// the signature of a self-signed root is never verified
+		if weakSignatureAlgorithms[c.SignatureAlgorithm] && !isSelfSigned(c) {
+			add("weak-signature", depth, "%s is signed with %s", c.Subject.CommonName, c.SignatureAlgorithm)
+		}
+	}
+
+	if leaf := result.Leaf(); leaf != nil {
+		validity := int(leaf.NotAfter.Sub(leaf.NotBefore).Hours() / 24)
+		if validity > h.MaxValidityDays {
+			add("long-validity", 0, "%s is valid for %d days, more than %d", leaf.Subject.CommonName, validity, h.MaxValidityDays)
+		}
+		if len(leaf.DNSNames) == 0 && len(leaf.IPAddresses) == 0 && len(leaf.EmailAddresses) == 0 && len(leaf.URIs) == 0 {
+			add("missing-san", 0, "%s has no subject alternative name", leaf.Subject.CommonName)
+		}
+	}
+	return findings
+}
+
+// describes the public key of the certificate if it is too weak, empty otherwise
+func (h *HygieneChecker) keyWeakness(c *x509.Certificate) string {
+	switch key := c.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if bits := key.N.BitLen(); bits < h.MinRSABits {
+			return fmt.Sprintf("%d bits RSA key", bits)
+		}
+	case *ecdsa.PublicKey:
+		if bits := key.Curve.Params().BitSize; bits < h.MinECBits {
+			return fmt.Sprintf("%d bits EC key (%s)", bits, key.Curve.Params().Name)
+		}
+	case *dsa.PublicKey:
+		return fmt.Sprintf("%d bits DSA key", key.P.BitLen())
+	}
+	return ""
+}
+
+// returns the name of the TLS version
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
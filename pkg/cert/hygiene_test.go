@@ -0,0 +1,93 @@
+package cert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewHygieneChecker(t *testing.T) {
+	checker, err := NewHygieneChecker(0, 0, 0, map[string]string{"missing-san": "off", "weak-cipher": "CRITICAL"})
+	require.Nil(t, err)
+	require.Equal(t, defaultMinRSABits, checker.MinRSABits)
+	require.Equal(t, defaultMaxValidityDays, checker.MaxValidityDays)
+	require.Equal(t, StatusCritical, checker.Rules["weak-cipher"])
+	_, ok := checker.Rules["missing-san"]
+	require.False(t, ok)
+
+	_, err = NewHygieneChecker(0, 0, 0, map[string]string{"weak-md": "warning"})
+	require.NotNil(t, err)
+	_, err = NewHygieneChecker(0, 0, 0, map[string]string{"weak-key": "info"})
+	require.NotNil(t, err)
+}
+
+func Test_HygieneCheckerCertificates(t *testing.T) {
+	checker, err := NewHygieneChecker(0, 0, 0, nil)
+	require.Nil(t, err)
+	ca := newTestCA(t, "Sentinel Test CA")
+	now := time.Now()
+
+	require.Empty(t, checker.Check(Result{Chain: []*x509.Certificate{newTestLeaf(t, ca, "www.example.com").cert, ca.cert}}, now))
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.Nil(t, err)
+	weakRSA := newTestCertWithKey(t, &x509.Certificate{Subject: pkix.Name{CommonName: "rsa.example.com"}, DNSNames: []string{"rsa.example.com"}}, ca, rsaKey)
+	findings := checker.Check(Result{Chain: []*x509.Certificate{weakRSA.cert}}, now)
+	require.Equal(t, []string{"weak-key"}, findingIDs(findings))
+	require.Contains(t, findings[0].Message, "1024 bits RSA")
+	require.Equal(t, StatusCritical, findings[0].Status)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	require.Nil(t, err)
+	weakEC := newTestCertWithKey(t, &x509.Certificate{Subject: pkix.Name{CommonName: "ec.example.com"}}, ca, ecKey)
+	findings = checker.Check(Result{Chain: []*x509.Certificate{weakEC.cert}}, now)
+	require.Equal(t, []string{"weak-key", "missing-san"}, findingIDs(findings))
+
+	longLived := newTestCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "long.example.com"},
+		DNSNames:  []string{"long.example.com"},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(825 * 24 * time.Hour),
+	}, ca)
+	findings = checker.Check(Result{Chain: []*x509.Certificate{longLived.cert}}, now)
+	require.Equal(t, []string{"long-validity"}, findingIDs(findings))
+
+	sha1Signed := *newTestLeaf(t, ca, "sha1.example.com").cert
+	sha1Signed.SignatureAlgorithm = x509.SHA1WithRSA
+	findings = checker.Check(Result{Chain: []*x509.Certificate{&sha1Signed, ca.cert}}, now)
+	require.Equal(t, []string{"weak-signature"}, findingIDs(findings))
+	require.Equal(t, 0, findings[0].Depth)
+}
+
+func Test_HygieneCheckerProtocol(t *testing.T) {
+	checker, err := NewHygieneChecker(0, 0, 0, map[string]string{"weak-cipher": "critical"})
+	require.Nil(t, err)
+
+	findings := checker.Check(Result{Version: tls.VersionTLS12, CipherSuite: tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA}, time.Now())
+	require.Equal(t, []string{"weak-cipher"}, findingIDs(findings))
+	require.Equal(t, StatusCritical, findings[0].Status)
+	require.Equal(t, -1, findings[0].Depth)
+
+	ca := newTestCA(t, "Sentinel Test CA")
+	host, port := startTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{tlsCertificate(newTestLeaf(t, ca, "www.example.com"))},
+		MinVersion:   tls.VersionTLS10,
+		MaxVersion:   tls.VersionTLS11,
+	})
+	conf, err := NewScanConfig([]int{port}, 1, 2)
+	require.Nil(t, err)
+	result := NewScanner(conf).Probe(context.Background(), Target{Host: host, Port: port})
+	require.Nil(t, result.Err)
+	findings = checker.Check(result, time.Now())
+	require.Equal(t, []string{"deprecated-tls"}, findingIDs(findings))
+	require.Contains(t, findings[0].Message, "TLS 1.1")
+}
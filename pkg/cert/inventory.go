@@ -0,0 +1,241 @@
+package cert
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var certificatesBucket = []byte("certificates")
+
+// a certificate of the inventory and the endpoints that presented it
+type InventoryRecord struct {
+	// SHA-256 fingerprint of the DER encoding, in lowercase hexadecimal
+	Fingerprint string              `json:"fingerprint"`
+	Subject     string              `json:"subject"`
+	Issuer      string              `json:"issuer"`
+	Serial      string              `json:"serial"`
+	SANs        []string            `json:"sans"`
+	NotBefore   time.Time           `json:"notBefore"`
+	NotAfter    time.Time           `json:"notAfter"`
+	FirstSeen   time.Time           `json:"firstSeen"`
+	LastSeen    time.Time           `json:"lastSeen"`
+	Endpoints   []InventoryEndpoint `json:"endpoints"`
+	Raw         []byte              `json:"raw"`
+}
+
+// an endpoint that presented a certificate of the inventory
+type InventoryEndpoint struct {
+	Host       string `json:"host"`
+	Port       int    `json:"port"`
+	ServerName string `json:"serverName,omitempty"`
+	// position of the certificate in the presented chain
+	Depth     int       `json:"depth"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// selects records of the inventory, the zero value matches everything
+type InventoryFilter struct {
+	// prefix of the fingerprint
+	Fingerprint string
+	// case insensitive substrings of the subject and issuer
+	Subject string
+	Issuer  string
+	// host of one of the endpoints
+	Host string
+	// records expiring before this time
+	ExpiresBefore time.Time
+	// records seen since this time
+	SeenSince time.Time
+}
+
+// records the certificates harvested by the scans and the snapshots of the scans in a
+// bbolt database
+type Inventory struct {
+	db *bolt.DB
+}
+
+// returns the SHA-256 fingerprint of the certificate
+func Fingerprint(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// opens the inventory stored at path, creating it if needed
+func OpenInventory(path string) (*Inventory, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, errors.Wrapf(err, "cannot create the directory of inventory %s", path)
+	}
+	db, err := bolt.Open(path, 0640, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open inventory %s", path)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{certificatesBucket, snapshotsBucket, notificationsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "cannot initialize inventory %s", path)
+	}
+	return &Inventory{db: db}, nil
+}
+
+func (i *Inventory) Close() error {
+	return i.db.Close()
+}
+
+// records every certificate of the results as seen at the given time
+func (i *Inventory) Record(results []Result, now time.Time) error {
+	return i.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(certificatesBucket)
+		for _, result := range results {
+			for depth, c := range result.Chain {
+				fingerprint := Fingerprint(c)
+				record := newInventoryRecord(c, now)
+				if data := bucket.Get([]byte(fingerprint)); data != nil {
+					if err := json.Unmarshal(data, record); err != nil {
+						return errors.Wrapf(err, "corrupted inventory record %s", fingerprint)
+					}
+				}
+				record.see(result.Target, depth, now)
+				data, err := json.Marshal(record)
+				if err != nil {
+					return err
+				}
+				if err := bucket.Put([]byte(fingerprint), data); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// returns the records matching the filter sorted by expiry
+func (i *Inventory) List(filter InventoryFilter) ([]*InventoryRecord, error) {
+	records := []*InventoryRecord{}
+	err := i.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(certificatesBucket).ForEach(func(key []byte, data []byte) error {
+			record := &InventoryRecord{}
+			if err := json.Unmarshal(data, record); err != nil {
+				return errors.Wrapf(err, "corrupted inventory record %s", key)
+			}
+			if filter.Match(record) {
+				records = append(records, record)
+			}
+			return nil
+		})
+	})
+	sort.Slice(records, func(a int, b int) bool {
+		if !records[a].NotAfter.Equal(records[b].NotAfter) {
+			return records[a].NotAfter.Before(records[b].NotAfter)
+		}
+		return records[a].Fingerprint < records[b].Fingerprint
+	})
+	return records, err
+}
+
+// returns true if the record is selected by the filter
+func (f InventoryFilter) Match(record *InventoryRecord) bool {
+	if !strings.HasPrefix(record.Fingerprint, strings.ToLower(f.Fingerprint)) {
+		return false
+	}
+	if !strings.Contains(strings.ToLower(record.Subject), strings.ToLower(f.Subject)) {
+		return false
+	}
+	if !strings.Contains(strings.ToLower(record.Issuer), strings.ToLower(f.Issuer)) {
+		return false
+	}
+	if !f.ExpiresBefore.IsZero() && !record.NotAfter.Before(f.ExpiresBefore) {
+		return false
+	}
+	if !f.SeenSince.IsZero() && record.LastSeen.Before(f.SeenSince) {
+		return false
+	}
+	if f.Host == "" {
+		return true
+	}
+	for _, endpoint := range record.Endpoints {
+		if strings.EqualFold(endpoint.Host, f.Host) || strings.EqualFold(endpoint.ServerName, f.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+func newInventoryRecord(c *x509.Certificate, now time.Time) *InventoryRecord {
+	sans := append([]string{}, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return &InventoryRecord{
+		Fingerprint: Fingerprint(c),
+		Subject:     c.Subject.String(),
+		Issuer:      c.Issuer.String(),
+		Serial:      c.SerialNumber.Text(16),
+		SANs:        sans,
+		NotBefore:   c.NotBefore,
+		NotAfter:    c.NotAfter,
+		FirstSeen:   now,
+		Raw:         c.Raw,
+	}
+}
+
+// records that the certificate was presented by the endpoint at the given depth
+func (r *InventoryRecord) see(target Target, depth int, now time.Time) {
+	if now.After(r.LastSeen) {
+		r.LastSeen = now
+	}
+	for j := range r.Endpoints {
+		endpoint := &r.Endpoints[j]
+		if endpoint.Host == target.Host && endpoint.Port == target.Port && endpoint.ServerName == target.ServerName {
+			endpoint.Depth = depth
+			if now.After(endpoint.LastSeen) {
+				endpoint.LastSeen = now
+			}
+			return
+		}
+	}
+	r.Endpoints = append(r.Endpoints, InventoryEndpoint{
+		Host:       target.Host,
+		Port:       target.Port,
+		ServerName: target.ServerName,
+		Depth:      depth,
+		FirstSeen:  now,
+		LastSeen:   now,
+	})
+}
+
+// returns true if the certificate was presented as the leaf of a chain
+func (r *InventoryRecord) IsLeaf() bool {
+	for _, endpoint := range r.Endpoints {
+		if endpoint.Depth == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the address of the endpoint followed by the SNI if any
+func (e InventoryEndpoint) String() string {
+	address := Target{Host: e.Host, Port: e.Port}.Address()
+	if e.ServerName != "" {
+		return address + " (" + e.ServerName + ")"
+	}
+	return address
+}
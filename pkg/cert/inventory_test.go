@@ -0,0 +1,64 @@
+package cert
+
+import (
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Inventory(t *testing.T) {
+	path := filepath.Join(tempDir(t), "inventory", "sentinel.db")
+	inventory, err := OpenInventory(path)
+	require.Nil(t, err)
+
+	ca := newTestCA(t, "Sentinel Test CA")
+	www := newTestLeaf(t, ca, "www.example.com")
+	mail := newTestLeaf(t, ca, "mail.example.com", "192.0.2.25")
+
+	first := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.Nil(t, inventory.Record([]Result{
+		{Target: Target{Host: "192.0.2.1", Port: 443, ServerName: "www.example.com"}, Chain: []*x509.Certificate{www.cert, ca.cert}},
+	}, first))
+
+	second := first.Add(24 * time.Hour)
+	require.Nil(t, inventory.Record([]Result{
+		{Target: Target{Host: "192.0.2.1", Port: 443, ServerName: "www.example.com"}, Chain: []*x509.Certificate{www.cert, ca.cert}},
+		{Target: Target{Host: "192.0.2.25", Port: 587}, Chain: []*x509.Certificate{mail.cert, ca.cert}},
+	}, second))
+	require.Nil(t, inventory.Close())
+
+	// the records survive the reopening of the database
+	inventory, err = OpenInventory(path)
+	require.Nil(t, err)
+	defer inventory.Close()
+
+	records, err := inventory.List(InventoryFilter{})
+	require.Nil(t, err)
+	require.Len(t, records, 3)
+
+	records, err = inventory.List(InventoryFilter{Fingerprint: Fingerprint(ca.cert)[:12]})
+	require.Nil(t, err)
+	require.Len(t, records, 1)
+	require.True(t, first.Equal(records[0].FirstSeen))
+	require.True(t, second.Equal(records[0].LastSeen))
+	require.Len(t, records[0].Endpoints, 2)
+	require.Equal(t, 1, records[0].Endpoints[0].Depth)
+	require.Equal(t, "192.0.2.1:443 (www.example.com)", records[0].Endpoints[0].String())
+
+	records, err = inventory.List(InventoryFilter{Host: "192.0.2.25", Subject: "MAIL"})
+	require.Nil(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, mail.cert.Raw, records[0].Raw)
+	require.Equal(t, []string{"mail.example.com", "192.0.2.25"}, records[0].SANs)
+	require.True(t, second.Equal(records[0].FirstSeen))
+
+	records, err = inventory.List(InventoryFilter{SeenSince: second})
+	require.Nil(t, err)
+	require.Len(t, records, 3)
+	records, err = inventory.List(InventoryFilter{ExpiresBefore: time.Now()})
+	require.Nil(t, err)
+	require.Empty(t, records)
+}
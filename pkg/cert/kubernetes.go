@@ -0,0 +1,271 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	certManagerCheck = "cert-manager"
+
+	kubeTLSSecretType = "kubernetes.io/tls"
+)
+
+// keys of the Secrets holding certificates
+var kubeCertificateKeys = []string{"tls.crt", "ca.crt"}
+
+// a certificate entry of a Secret, or a cert-manager Certificate whose Secret is not
+// part of the manifests
+type ManifestEntry struct {
+	Path      string
+	Kind      string
+	Namespace string
+	Name      string
+	// key of the Secret holding the certificates, e.g. tls.crt, or the name of the
+	// Secret of a cert-manager Certificate
+	Key   string
+	Chain []*x509.Certificate
+	// cert-manager Certificate requesting the certificates of the Secret
+	Certificate string
+	DNSNames    []string
+	Issuer      string
+	// expiry reported in the status of a cert-manager Certificate without Secret
+	NotAfter time.Time
+	Findings []Finding
+}
+
+// the fields of the Kubernetes objects used to extract the certificates
+type kubeObject struct {
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"metadata"`
+	Type       string            `yaml:"type"`
+	Data       map[string]string `yaml:"data"`
+	StringData map[string]string `yaml:"stringData"`
+	Spec       struct {
+		SecretName string   `yaml:"secretName"`
+		CommonName string   `yaml:"commonName"`
+		DNSNames   []string `yaml:"dnsNames"`
+		IssuerRef  struct {
+			Name string `yaml:"name"`
+			Kind string `yaml:"kind"`
+		} `yaml:"issuerRef"`
+	} `yaml:"spec"`
+	Status struct {
+		NotAfter string `yaml:"notAfter"`
+	} `yaml:"status"`
+	Items []kubeObject `yaml:"items"`
+}
+
+// returns the kind and the name of the resource, e.g. Secret default/www-tls
+func (e *ManifestEntry) Resource() string {
+	if e.Namespace == "" {
+		return e.Kind + " " + e.Name
+	}
+	return e.Kind + " " + e.Namespace + "/" + e.Name
+}
+
+// returns the certificates of the entry as a scan result, to be evaluated by the checkers
+func (e *ManifestEntry) Result() Result {
+	return Result{Target: Target{Host: e.Resource()}, Reachable: true, Chain: e.Chain}
+}
+
+// walks the given files and directories and extracts the certificates of the Secrets
+// and cert-manager Certificates of the YAML and JSON manifests, e.g. kubectl dumps
+func ScanManifests(roots []string) ([]*ManifestEntry, []error) {
+	entries := []*ManifestEntry{}
+	certificates := []*ManifestEntry{}
+	errs := []error{}
+	for _, root := range roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".yaml", ".yml", ".json":
+			default:
+				return nil
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			secrets, managed, err := parseManifest(path)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			entries = append(entries, secrets...)
+			certificates = append(certificates, managed...)
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// attaches the cert-manager Certificates to their Secrets
+	for _, certificate := range certificates {
+		found := false
+		for _, entry := range entries {
+			if entry.Kind != "Secret" || entry.Namespace != certificate.Namespace || entry.Name != certificate.Key {
+				continue
+			}
+			found = true
+			entry.Certificate, entry.DNSNames, entry.Issuer = certificate.Name, certificate.DNSNames, certificate.Issuer
+			if entry.Key == "tls.crt" {
+				entry.Findings = append(entry.Findings, checkCertManagerNames(entry)...)
+			}
+		}
+		if !found {
+			entries = append(entries, certificate)
+		}
+	}
+	return entries, errs
+}
+
+// returns the Secret entries and the cert-manager Certificates of a manifest file, the
+// Key of the Certificates is the name of their Secret
+func parseManifest(path string) ([]*ManifestEntry, []*ManifestEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	secrets := []*ManifestEntry{}
+	certificates := []*ManifestEntry{}
+	var extract func(object kubeObject) error
+	extract = func(object kubeObject) error {
+		switch {
+		case strings.HasSuffix(object.Kind, "List"):
+			for _, item := range object.Items {
+				if err := extract(item); err != nil {
+					return err
+				}
+			}
+		case object.Kind == "Secret":
+			for _, key := range kubeCertificateKeys {
+				entry, err := secretEntry(path, object, key)
+				if err != nil {
+					return err
+				}
+				if entry != nil {
+					secrets = append(secrets, entry)
+				}
+			}
+		case object.Kind == "Certificate" && object.Spec.SecretName != "":
+			entry := &ManifestEntry{
+				Path:        path,
+				Kind:        "Certificate",
+				Namespace:   object.Metadata.Namespace,
+				Name:        object.Metadata.Name,
+				Key:         object.Spec.SecretName,
+				Certificate: object.Metadata.Name,
+				DNSNames:    object.Spec.DNSNames,
+				Issuer:      object.Spec.IssuerRef.Kind + "/" + object.Spec.IssuerRef.Name,
+			}
+			if object.Spec.CommonName != "" && len(entry.DNSNames) == 0 {
+				entry.DNSNames = []string{object.Spec.CommonName}
+			}
+			if object.Status.NotAfter != "" {
+				notAfter, err := time.Parse(time.RFC3339, object.Status.NotAfter)
+				if err != nil {
+					return errors.Wrapf(err, "invalid notAfter of Certificate %s", object.Metadata.Name)
+				}
+				entry.NotAfter = notAfter
+			}
+			certificates = append(certificates, entry)
+		}
+		return nil
+	}
+
+	decoder := yaml.NewDecoder(file)
+	for {
+		object := kubeObject{}
+		err := decoder.Decode(&object)
+		if err == io.EOF {
+			break
+		}
+		// the fields of the other kinds may not fit the expected types
+		if _, ok := err.(*yaml.TypeError); err != nil && (!ok || object.Kind == "Secret" || object.Kind == "Certificate") {
+			return secrets, certificates, errors.Wrapf(err, "cannot parse manifest %s", path)
+		}
+		if err := extract(object); err != nil {
+			return secrets, certificates, errors.Wrapf(err, "invalid manifest %s", path)
+		}
+	}
+	return secrets, certificates, nil
+}
+
+// returns the entry of the certificates stored under the key of the Secret, nil if the
+// key is missing
+func secretEntry(path string, object kubeObject, key string) (*ManifestEntry, error) {
+	var data []byte
+	if value, ok := object.StringData[key]; ok {
+		data = []byte(value)
+	} else if value, ok := object.Data[key]; ok {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s in Secret %s", key, object.Metadata.Name)
+		}
+		data = decoded
+	} else {
+		return nil, nil
+	}
+
+	entry := &ManifestEntry{
+		Path:      path,
+		Kind:      "Secret",
+		Namespace: object.Metadata.Namespace,
+		Name:      object.Metadata.Name,
+		Key:       key,
+	}
+	for rest := bytes.TrimSpace(data); len(rest) != 0; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid certificate in %s of Secret %s", key, object.Metadata.Name)
+		}
+		entry.Chain = append(entry.Chain, c)
+	}
+	if len(entry.Chain) == 0 {
+		// the tls.crt of the Secrets of type kubernetes.io/tls is mandatory
+		if object.Type == kubeTLSSecretType && key == "tls.crt" {
+			return nil, errors.Errorf("no certificate in %s of Secret %s", key, object.Metadata.Name)
+		}
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// reports the names requested by the cert-manager Certificate that the leaf of its
+// Secret does not cover
+func checkCertManagerNames(entry *ManifestEntry) []Finding {
+	findings := []Finding{}
+	for _, name := range entry.DNSNames {
+		if err := entry.Chain[0].VerifyHostname(name); err != nil {
+			findings = append(findings, newFinding(certManagerCheck, "dns-names-mismatch", StatusWarning, 0,
+				"%s requested by Certificate %s is not covered by the certificate of the Secret", name, entry.Certificate))
+		}
+	}
+	return findings
+}
@@ -0,0 +1,131 @@
+package cert
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ScanManifests(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	leaf := newTestLeaf(t, ca, "www.example.com")
+	encode := func(certificates ...*testCert) string {
+		data := []byte{}
+		for _, c := range certificates {
+			data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})...)
+		}
+		return base64.StdEncoding.EncodeToString(data)
+	}
+
+	dir := tempDir(t)
+	manifest := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: www
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers: []
+---
+apiVersion: v1
+kind: Secret
+type: kubernetes.io/tls
+metadata:
+  name: www-tls
+  namespace: web
+data:
+  tls.crt: ` + encode(leaf, ca) + `
+  tls.key: c2VjcmV0
+  ca.crt: ` + encode(ca) + `
+---
+apiVersion: cert-manager.io/v1alpha2
+kind: Certificate
+metadata:
+  name: www
+  namespace: web
+spec:
+  secretName: www-tls
+  dnsNames:
+    - www.example.com
+    - example.com
+  issuerRef:
+    name: letsencrypt
+    kind: ClusterIssuer
+---
+apiVersion: cert-manager.io/v1alpha2
+kind: Certificate
+metadata:
+  name: api
+  namespace: web
+spec:
+  secretName: api-tls
+  commonName: api.example.com
+  issuerRef:
+    name: internal
+    kind: Issuer
+status:
+  notAfter: "2020-08-01T00:00:00Z"
+`
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "web.yaml"), []byte(manifest), 0600))
+
+	dump, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items": []interface{}{
+			map[string]interface{}{
+				"kind":       "Secret",
+				"metadata":   map[string]string{"name": "ldap-ca", "namespace": "auth"},
+				"stringData": map[string]string{"ca.crt": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}))},
+			},
+			map[string]interface{}{
+				"kind":     "Secret",
+				"metadata": map[string]string{"name": "password", "namespace": "auth"},
+				"data":     map[string]string{"password": "c2VjcmV0"},
+			},
+		},
+	})
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "dump.json"), dump, 0600))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("kind: Secret\n"), 0600))
+
+	entries, errs := ScanManifests([]string{dir})
+	require.Empty(t, errs)
+	found := []string{}
+	for _, entry := range entries {
+		found = append(found, entry.Resource()+" "+entry.Key+" "+entry.Certificate)
+	}
+	sort.Strings(found)
+	require.Equal(t, []string{
+		"Certificate web/api api-tls api",
+		"Secret auth/ldap-ca ca.crt ",
+		"Secret web/www-tls ca.crt www",
+		"Secret web/www-tls tls.crt www",
+	}, found)
+
+	for _, entry := range entries {
+		switch {
+		case entry.Key == "tls.crt":
+			require.Len(t, entry.Chain, 2)
+			require.Equal(t, "ClusterIssuer/letsencrypt", entry.Issuer)
+			require.Equal(t, []string{"dns-names-mismatch"}, findingIDs(entry.Findings))
+			require.Contains(t, entry.Findings[0].Message, "example.com requested")
+			require.Equal(t, "Secret web/www-tls", entry.Result().Host)
+		case entry.Kind == "Certificate":
+			require.Empty(t, entry.Chain)
+			require.Equal(t, []string{"api.example.com"}, entry.DNSNames)
+			require.Equal(t, 2020, entry.NotAfter.Year())
+		}
+	}
+
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "broken.yaml"), []byte(strings.Replace(manifest, "tls.key", "\ttls.key", 1)), 0600))
+	_, errs = ScanManifests([]string{dir})
+	require.Len(t, errs, 1)
+}
@@ -0,0 +1,421 @@
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// event of the notifications of certificates crossing the expiry thresholds, the other
+// notifications are the changes between snapshots
+const (
+	EventExpiry = "expiry"
+
+	// time allowed to deliver an email when the context has no deadline
+	defaultSMTPTimeout = 30 * time.Second
+)
+
+var notificationsBucket = []byte("notifications")
+
+// change events notified by default, new certificates are expected
+var DefaultNotifiedChanges = []string{EventRotated, EventIssuerChanged, EventDisappeared, EventTLSStopped}
+
+const (
+	defaultSMTPSubject = `[sentinel] {{len .Notifications}} certificate notification{{if gt (len .Notifications) 1}}s{{end}}`
+	defaultSMTPBody    = `{{range .Notifications}}{{.Status}} {{.Message}}
+{{if .Endpoints}}  endpoints: {{join .Endpoints ", "}}
+{{end}}{{if .Fingerprint}}  fingerprint: {{.Fingerprint}}
+{{end}}
+{{end}}`
+)
+
+// an alert about a certificate crossing an expiry threshold or an endpoint changing
+type Notification struct {
+	// identifies the notified condition for the deduplication
+	Key         string    `json:"key"`
+	Event       string    `json:"event"`
+	Status      string    `json:"status"`
+	Subject     string    `json:"subject,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	NotAfter    time.Time `json:"notAfter,omitempty"`
+	DaysLeft    int       `json:"daysLeft,omitempty"`
+	Endpoints   []string  `json:"endpoints,omitempty"`
+	Message     string    `json:"message"`
+	Time        time.Time `json:"time"`
+
+	status Status
+}
+
+// last delivery of a notification, stored in the inventory
+type notificationRecord struct {
+	Status     Status    `json:"status"`
+	NotifiedAt time.Time `json:"notifiedAt"`
+}
+
+// delivers the notifications
+type Notifier interface {
+	Notify(ctx context.Context, notifications []Notification) error
+}
+
+// returns a notification for every certificate of the results in the warning, critical
+// or expired state, the endpoints presenting the same certificate are grouped
+func ExpiryNotifications(results []Result, expiry ExpiryConfig, now time.Time) []Notification {
+	byFingerprint := map[string]*Notification{}
+	fingerprints := []string{}
+	for _, result := range results {
+		endpoint := result.Address()
+		if result.ServerName != "" {
+			endpoint += " (" + result.ServerName + ")"
+		}
+		for _, c := range result.Chain {
+			status := expiry.Evaluate(c, now)
+			if status == StatusOK {
+				continue
+			}
+			fingerprint := Fingerprint(c)
+			notification, ok := byFingerprint[fingerprint]
+			if !ok {
+				daysLeft := DaysLeft(c, now)
+				message := fmt.Sprintf("certificate %s expires in %d days", c.Subject.CommonName, daysLeft)
+				if status == StatusExpired {
+					message = fmt.Sprintf("certificate %s expired %d days ago", c.Subject.CommonName, -daysLeft)
+				}
+				notification = &Notification{
+					Key:         EventExpiry + "/" + fingerprint,
+					Event:       EventExpiry,
+					Status:      status.String(),
+					Subject:     c.Subject.String(),
+					Issuer:      c.Issuer.String(),
+					Fingerprint: fingerprint,
+					NotAfter:    c.NotAfter.UTC(),
+					DaysLeft:    daysLeft,
+					Message:     message,
+					Time:        now,
+					status:      status,
+				}
+				byFingerprint[fingerprint] = notification
+				fingerprints = append(fingerprints, fingerprint)
+			}
+			notification.Endpoints = appendMissing(notification.Endpoints, endpoint)
+		}
+	}
+
+	notifications := []Notification{}
+	for _, fingerprint := range fingerprints {
+		notifications = append(notifications, *byFingerprint[fingerprint])
+	}
+	sort.SliceStable(notifications, func(i int, j int) bool {
+		return notifications[i].NotAfter.Before(notifications[j].NotAfter)
+	})
+	return notifications
+}
+
+// returns a warning notification for every change event of the given types
+func ChangeNotifications(events []ChangeEvent, types []string) []Notification {
+	notifications := []Notification{}
+	for _, event := range events {
+		notified := false
+		for _, eventType := range types {
+			notified = notified || eventType == event.Type
+		}
+		if !notified {
+			continue
+		}
+		endpoint := fmt.Sprintf("%s:%d", event.Host, event.Port)
+		if event.ServerName != "" {
+			endpoint += " (" + event.ServerName + ")"
+		}
+		notifications = append(notifications, Notification{
+			Key:         strings.Join([]string{event.Type, endpoint, event.PreviousFingerprint, event.Fingerprint}, "/"),
+			Event:       event.Type,
+			Status:      StatusWarning.String(),
+			Issuer:      event.Issuer,
+			Fingerprint: event.Fingerprint,
+			Endpoints:   []string{endpoint},
+			Message:     event.Message,
+			Time:        event.Time,
+			status:      StatusWarning,
+		})
+	}
+	return notifications
+}
+
+// returns the notifications not delivered yet, the ones whose status got more severe
+// since their delivery and, when renotify is positive, the ones delivered longer ago
+func (i *Inventory) PendingNotifications(notifications []Notification, now time.Time, renotify time.Duration) ([]Notification, error) {
+	pending := []Notification{}
+	err := i.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(notificationsBucket)
+		for _, notification := range notifications {
+			data := bucket.Get([]byte(notification.Key))
+			if data == nil {
+				pending = append(pending, notification)
+				continue
+			}
+			record := notificationRecord{}
+			if err := json.Unmarshal(data, &record); err != nil {
+				return errors.Wrapf(err, "corrupted notification %s", notification.Key)
+			}
+			if notification.status > record.Status || (renotify > 0 && now.Sub(record.NotifiedAt) >= renotify) {
+				pending = append(pending, notification)
+			}
+		}
+		return nil
+	})
+	return pending, err
+}
+
+// records the delivery of the notifications
+func (i *Inventory) MarkNotified(notifications []Notification, now time.Time) error {
+	return i.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(notificationsBucket)
+		for _, notification := range notifications {
+			data, err := json.Marshal(notificationRecord{Status: notification.status, NotifiedAt: now})
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(notification.Key), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// posts the notifications as JSON, {"notifications": [...]}
+type WebhookNotifier struct {
+	URL     string
+	Client  *http.Client
+	Headers map[string]string
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, notifications []Notification) error {
+	payload := struct {
+		Notifications []Notification `json:"notifications"`
+	}{notifications}
+	return postJSON(ctx, n.Client, n.URL, n.Headers, payload)
+}
+
+// posts the notifications as a message to a Slack or Mattermost incoming webhook
+type SlackNotifier struct {
+	URL    string
+	Client *http.Client
+	// overrides the channel and the user name of the webhook when not empty
+	Channel  string
+	Username string
+}
+
+func (n *SlackNotifier) Notify(ctx context.Context, notifications []Notification) error {
+	lines := []string{}
+	for _, notification := range notifications {
+		line := fmt.Sprintf("*%s* %s", notification.Status, notification.Message)
+		if len(notification.Endpoints) != 0 {
+			line += " (" + strings.Join(notification.Endpoints, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	payload := struct {
+		Text     string `json:"text"`
+		Channel  string `json:"channel,omitempty"`
+		Username string `json:"username,omitempty"`
+	}{strings.Join(lines, "\n"), n.Channel, n.Username}
+	return postJSON(ctx, n.Client, n.URL, nil, payload)
+}
+
+// posts the payload as JSON and expects a 2xx response
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "cannot post notifications to %s", url)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("cannot post notifications to %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// sends the notifications in an email whose subject and body are Go templates executed
+// with the Notifications and the Time. STARTTLS is used when the server supports it.
+type SMTPNotifier struct {
+	// host:port of the server
+	Addr string
+	From string
+	To   []string
+	// nil for servers without authentication
+	Auth    smtp.Auth
+	Subject *template.Template
+	Body    *template.Template
+}
+
+// creates an SMTP notifier, the default templates are used for empty subject and body.
+// PLAIN authentication is used when a username is given.
+func NewSMTPNotifier(addr string, from string, to []string, username string, password string, subject string, body string) (*SMTPNotifier, error) {
+	if len(to) == 0 {
+		return nil, errors.New("no recipient")
+	}
+	if subject == "" {
+		subject = defaultSMTPSubject
+	}
+	if body == "" {
+		body = defaultSMTPBody
+	}
+	funcs := template.FuncMap{"join": strings.Join}
+	subjectTemplate, err := template.New("subject").Funcs(funcs).Parse(subject)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid subject template")
+	}
+	bodyTemplate, err := template.New("body").Funcs(funcs).Parse(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid body template")
+	}
+
+	notifier := &SMTPNotifier{Addr: addr, From: from, To: to, Subject: subjectTemplate, Body: bodyTemplate}
+	if username != "" {
+		host := addr
+		if i := strings.LastIndex(addr, ":"); i >= 0 {
+			host = addr[:i]
+		}
+		notifier.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return notifier, nil
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, notifications []Notification) error {
+	data := struct {
+		Notifications []Notification
+		Time          time.Time
+	}{notifications, time.Now()}
+	subject := &bytes.Buffer{}
+	if err := n.Subject.Execute(subject, data); err != nil {
+		return errors.Wrap(err, "cannot render the email subject")
+	}
+	body := &bytes.Buffer{}
+	if err := n.Body.Execute(body, data); err != nil {
+		return errors.Wrap(err, "cannot render the email body")
+	}
+
+	message := &bytes.Buffer{}
+	fmt.Fprintf(message, "From: %s\r\n", n.From)
+	fmt.Fprintf(message, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(message, "Subject: %s\r\n", strings.Replace(strings.TrimSpace(subject.String()), "\n", " ", -1))
+	fmt.Fprintf(message, "Date: %s\r\n", data.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(message, "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.Replace(body.String(), "\n", "\r\n", -1))
+
+	if err := n.send(ctx, message.Bytes()); err != nil {
+		return errors.Wrapf(err, "cannot send notifications to %s", n.Addr)
+	}
+	return nil
+}
+
+// delivers the message as smtp.SendMail does, within the deadline of the context
+func (n *SMTPNotifier) send(ctx context.Context, message []byte) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultSMTPTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	dialer := &net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", n.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	// a cancellation interrupts the exchange in progress
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	host, _, err := net.SplitHostPort(n.Addr)
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if n.Auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("the server does not support authentication")
+		}
+		if err := client.Auth(n.Auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(n.From); err != nil {
+		return err
+	}
+	for _, to := range n.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// appends the value unless already present
+func appendMissing(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
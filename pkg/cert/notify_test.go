@@ -0,0 +1,207 @@
+package cert
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestNotifications(t *testing.T, now time.Time, daysLeft int) []Notification {
+	ca := newTestCA(t, "Sentinel Test CA")
+	expiring := newTestCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "old.example.com"},
+		DNSNames:  []string{"old.example.com"},
+		NotBefore: now.Add(-24 * time.Hour),
+		NotAfter:  now.Add(time.Duration(daysLeft)*24*time.Hour + time.Hour),
+	}, ca)
+	expiry, err := NewExpiryConfig(30, 7)
+	require.Nil(t, err)
+
+	results := []Result{
+		{Target: Target{Host: "10.0.0.1", Port: 443}, Chain: []*x509.Certificate{expiring.cert, ca.cert}},
+		{Target: Target{Host: "10.0.0.2", Port: 443, ServerName: "old.example.com"}, Chain: []*x509.Certificate{expiring.cert}},
+	}
+	return ExpiryNotifications(results, expiry, now)
+}
+
+func Test_ExpiryNotifications(t *testing.T) {
+	notifications := newTestNotifications(t, time.Now(), 20)
+	// the CA is valid and the leaf presented twice is notified once
+	require.Len(t, notifications, 1)
+	require.Equal(t, EventExpiry, notifications[0].Event)
+	require.Equal(t, "WARNING", notifications[0].Status)
+	require.Equal(t, 20, notifications[0].DaysLeft)
+	require.Equal(t, []string{"10.0.0.1:443", "10.0.0.2:443 (old.example.com)"}, notifications[0].Endpoints)
+	require.Equal(t, "certificate old.example.com expires in 20 days", notifications[0].Message)
+}
+
+func Test_ChangeNotifications(t *testing.T) {
+	events := []ChangeEvent{
+		{Type: EventNewCertificate, Host: "10.0.0.1", Port: 443, Fingerprint: "aa", Message: "new certificate"},
+		{Type: EventRotated, Host: "10.0.0.2", Port: 443, PreviousFingerprint: "bb", Fingerprint: "cc", Message: "rotated"},
+	}
+	notifications := ChangeNotifications(events, DefaultNotifiedChanges)
+	require.Len(t, notifications, 1)
+	require.Equal(t, EventRotated, notifications[0].Event)
+	require.Equal(t, "rotated/10.0.0.2:443/bb/cc", notifications[0].Key)
+}
+
+func Test_PendingNotifications(t *testing.T) {
+	inventory, err := OpenInventory(filepath.Join(tempDir(t), "inventory.db"))
+	require.Nil(t, err)
+	defer inventory.Close()
+
+	now := time.Now()
+	warning := newTestNotifications(t, now, 20)
+	pending, err := inventory.PendingNotifications(warning, now, 0)
+	require.Nil(t, err)
+	require.Len(t, pending, 1)
+	require.Nil(t, inventory.MarkNotified(pending, now))
+
+	// not notified again on the next scan
+	pending, err = inventory.PendingNotifications(warning, now.Add(time.Hour), 0)
+	require.Nil(t, err)
+	require.Empty(t, pending)
+	// unless the renotification delay is over
+	pending, err = inventory.PendingNotifications(warning, now.Add(25*time.Hour), 24*time.Hour)
+	require.Nil(t, err)
+	require.Len(t, pending, 1)
+
+	// or the certificate crossed the critical threshold
+	critical := warning[0]
+	critical.Status, critical.status = "CRITICAL", StatusCritical
+	pending, err = inventory.PendingNotifications([]Notification{critical}, now.Add(time.Hour), 0)
+	require.Nil(t, err)
+	require.Len(t, pending, 1)
+}
+
+func Test_WebhookNotifiers(t *testing.T) {
+	requests := []map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		payload := map[string]interface{}{}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&payload))
+		requests = append(requests, payload)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	notifications := newTestNotifications(t, time.Now(), 3)
+	ctx := context.Background()
+	webhook := &WebhookNotifier{URL: server.URL + "/hook", Client: server.Client(), Headers: map[string]string{"Authorization": "Bearer token"}}
+	require.Nil(t, webhook.Notify(ctx, notifications))
+	require.Len(t, requests[0]["notifications"], 1)
+
+	slack := &SlackNotifier{URL: server.URL + "/slack", Client: server.Client(), Channel: "#certs"}
+	require.Nil(t, slack.Notify(ctx, notifications))
+	require.Equal(t, "#certs", requests[1]["channel"])
+	require.Equal(t, "*CRITICAL* certificate old.example.com expires in 3 days (10.0.0.1:443, 10.0.0.2:443 (old.example.com))", requests[1]["text"])
+
+	webhook.URL = server.URL + "/fail"
+	require.NotNil(t, webhook.Notify(ctx, notifications))
+}
+
+// accepts a single SMTP session and returns the received message
+func startSMTPServer(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		data := &strings.Builder{}
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					messages <- data.String()
+					reply("250 OK")
+				} else {
+					data.WriteString(line)
+				}
+				continue
+			}
+			switch command := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case command == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case command == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return listener.Addr().String(), messages
+}
+
+func Test_SMTPNotifier(t *testing.T) {
+	addr, messages := startSMTPServer(t)
+	notifier, err := NewSMTPNotifier(addr, "sentinel@example.com", []string{"ops@example.com"}, "", "",
+		"", `{{range .Notifications}}{{.Status}}: {{.Subject}} {{join .Endpoints " "}}{{end}}`)
+	require.Nil(t, err)
+	require.Nil(t, notifier.Notify(context.Background(), newTestNotifications(t, time.Now(), 20)))
+
+	message := <-messages
+	require.Contains(t, message, "To: ops@example.com\r\n")
+	require.Contains(t, message, "Subject: [sentinel] 1 certificate notification\r\n")
+	require.Contains(t, message, "WARNING: CN=old.example.com 10.0.0.1:443 10.0.0.2:443 (old.example.com)")
+
+	_, err = NewSMTPNotifier(addr, "sentinel@example.com", nil, "", "", "", "")
+	require.NotNil(t, err)
+	_, err = NewSMTPNotifier(addr, "sentinel@example.com", []string{"ops@example.com"}, "", "", "{{", "")
+	require.NotNil(t, err)
+}
+
+func Test_SMTPNotifierTimeout(t *testing.T) {
+	// the server accepts the connections but never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- conn
+	}()
+
+	notifier, err := NewSMTPNotifier(listener.Addr().String(), "sentinel@example.com", []string{"ops@example.com"}, "", "", "", "")
+	require.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	require.NotNil(t, notifier.Notify(ctx, newTestNotifications(t, time.Now(), 20)))
+	require.True(t, time.Since(start) < 5*time.Second)
+	(<-accepted).Close()
+}
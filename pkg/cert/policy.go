@@ -0,0 +1,281 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const policyCheck = "policy"
+
+// a rule of the certificate policy as configured, the constraints left empty are not enforced
+type PolicyRule struct {
+	// identifier of the rule, reported as the ID of its violations
+	ID          string
+	Description string
+	// warning or critical, critical when empty
+	Severity string
+	// DNS zones the rule is restricted to, matched against the server name, the host and the
+	// SANs of the leaf. The rule applies to every certificate when empty.
+	Zones []string
+	// distinguished names or common names of the CAs allowed to issue the leaf
+	IssuerSubjects []string
+	// subject key identifiers, in hex, of the CAs allowed to issue the leaf
+	IssuerSKIs []string
+	// allowed key types of the leaf, e.g. RSA 3072 for RSA keys of 3072 bits or more,
+	// ECDSA P-256 or Ed25519
+	KeyTypes        []string
+	MaxValidityDays int
+	// wildcard names are forbidden in the zones of the rule
+	ForbidWildcards bool
+	// the SANs of the leaf must cover the hostname the certificate is presented for
+	RequireHostname bool
+}
+
+// a key type allowed by a policy rule
+type policyKeyType struct {
+	Algorithm string
+	// minimum RSA size or exact ECDSA curve, any when empty
+	Parameter string
+}
+
+// a validated policy rule
+type policyRule struct {
+	PolicyRule
+	status   Status
+	skis     [][]byte
+	keyTypes []policyKeyType
+}
+
+// evaluates the certificates against the rules of the certificate policy
+type PolicyChecker struct {
+	rules []policyRule
+}
+
+// creates a policy checker, the rules must have distinct identifiers
+func NewPolicyChecker(rules []PolicyRule) (*PolicyChecker, error) {
+	checker := &PolicyChecker{}
+	seen := map[string]bool{}
+	for _, rule := range rules {
+		if rule.ID == "" {
+			return nil, errors.New("policy rule without id")
+		}
+		if seen[rule.ID] {
+			return nil, errors.Errorf("duplicate policy rule %s", rule.ID)
+		}
+		seen[rule.ID] = true
+
+		compiled := policyRule{PolicyRule: rule, status: StatusCritical}
+		switch strings.ToLower(rule.Severity) {
+		case "", "critical":
+		case "warning":
+			compiled.status = StatusWarning
+		default:
+			return nil, errors.Errorf("invalid severity %s for policy rule %s, expected warning or critical", rule.Severity, rule.ID)
+		}
+		compiled.Zones = []string{}
+		for _, zone := range rule.Zones {
+			compiled.Zones = append(compiled.Zones, strings.TrimSuffix(strings.ToLower(strings.TrimPrefix(zone, "*.")), "."))
+		}
+		for _, ski := range rule.IssuerSKIs {
+			decoded, err := hex.DecodeString(strings.NewReplacer(":", "", " ", "").Replace(ski))
+			if err != nil {
+				return nil, errors.Errorf("invalid subject key identifier %s in policy rule %s", ski, rule.ID)
+			}
+			compiled.skis = append(compiled.skis, decoded)
+		}
+		for _, keyType := range rule.KeyTypes {
+			parsed, err := parsePolicyKeyType(keyType)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid policy rule %s", rule.ID)
+			}
+			compiled.keyTypes = append(compiled.keyTypes, parsed)
+		}
+		checker.rules = append(checker.rules, compiled)
+	}
+	return checker, nil
+}
+
+// parses a key type such as RSA, RSA 2048, ECDSA, ECDSA P-384 or Ed25519
+func parsePolicyKeyType(keyType string) (policyKeyType, error) {
+	fields := strings.Fields(keyType)
+	if len(fields) == 0 || len(fields) > 2 {
+		return policyKeyType{}, errors.Errorf("invalid key type %q", keyType)
+	}
+	parsed := policyKeyType{Algorithm: strings.ToUpper(fields[0])}
+	if len(fields) == 2 {
+		parsed.Parameter = strings.ToUpper(fields[1])
+	}
+	switch parsed.Algorithm {
+	case "RSA":
+		if parsed.Parameter != "" {
+			if _, err := strconv.Atoi(parsed.Parameter); err != nil {
+				return policyKeyType{}, errors.Errorf("invalid RSA size in key type %q", keyType)
+			}
+		}
+	case "ECDSA":
+	case "ED25519":
+		if parsed.Parameter != "" {
+			return policyKeyType{}, errors.Errorf("invalid key type %q", keyType)
+		}
+	default:
+		return policyKeyType{}, errors.Errorf("unknown key type %q, expected RSA, ECDSA or Ed25519", keyType)
+	}
+	return parsed, nil
+}
+
+// returns the violations of the rules applying to the leaf of the endpoint
+func (p *PolicyChecker) Check(result Result, now time.Time) []Finding {
+	leaf := result.Leaf()
+	if leaf == nil {
+		return nil
+	}
+	hostname := policyHostname(result)
+
+	findings := []Finding{}
+	for _, rule := range p.rules {
+		if !rule.applies(leaf, hostname) {
+			continue
+		}
+		add := func(format string, args ...interface{}) {
+			findings = append(findings, newFinding(policyCheck, rule.ID, rule.status, 0, format, args...))
+		}
+
+		if (len(rule.IssuerSubjects) != 0 || len(rule.skis) != 0) && !rule.allowsIssuer(leaf) {
+			add("%s is issued by %s, which the policy does not allow", leaf.Subject.CommonName, leaf.Issuer.String())
+		}
+		if len(rule.keyTypes) != 0 && !rule.allowsKey(leaf) {
+			add("%s has a %s key, the policy allows %s", leaf.Subject.CommonName, KeyType(leaf), strings.Join(rule.KeyTypes, ", "))
+		}
+		if rule.MaxValidityDays > 0 {
+			if validity := int(leaf.NotAfter.Sub(leaf.NotBefore).Hours() / 24); validity > rule.MaxValidityDays {
+				add("%s is valid for %d days, the policy allows %d", leaf.Subject.CommonName, validity, rule.MaxValidityDays)
+			}
+		}
+		if rule.ForbidWildcards {
+			for _, name := range leaf.DNSNames {
+				if strings.HasPrefix(name, "*.") && (len(rule.Zones) == 0 || rule.inZones(name[2:])) {
+					add("%s has the wildcard name %s, forbidden by the policy", leaf.Subject.CommonName, name)
+				}
+			}
+		}
+		if rule.RequireHostname && hostname != "" && !coversHostname(leaf, hostname) {
+			add("the SANs of %s do not include %s", leaf.Subject.CommonName, hostname)
+		}
+	}
+	return findings
+}
+
+// returns the name the certificate is presented for, the server name or the host of
+// the network endpoints, empty for the files and manifests
+func policyHostname(result Result) string {
+	if result.ServerName != "" {
+		return strings.TrimSuffix(strings.ToLower(result.ServerName), ".")
+	}
+	if result.Port == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(result.Host), ".")
+}
+
+// returns true if the rule applies to the certificate presented for the hostname
+func (r policyRule) applies(leaf *x509.Certificate, hostname string) bool {
+	if len(r.Zones) == 0 {
+		return true
+	}
+	names := append([]string{hostname}, leaf.DNSNames...)
+	for _, name := range names {
+		if r.inZones(strings.TrimPrefix(strings.ToLower(name), "*.")) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns true if the name belongs to one of the zones of the rule
+func (r policyRule) inZones(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if name == "" {
+		return false
+	}
+	for _, zone := range r.Zones {
+		if name == zone || strings.HasSuffix(name, "."+zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns true if the leaf is issued by one of the allowed CAs, matched by distinguished
+// name, common name or authority key identifier
+func (r policyRule) allowsIssuer(leaf *x509.Certificate) bool {
+	for _, subject := range r.IssuerSubjects {
+		if strings.EqualFold(subject, leaf.Issuer.String()) || strings.EqualFold(subject, leaf.Issuer.CommonName) {
+			return true
+		}
+	}
+	for _, ski := range r.skis {
+		if len(leaf.AuthorityKeyId) != 0 && string(ski) == string(leaf.AuthorityKeyId) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns true if the key of the leaf is one of the allowed types
+func (r policyRule) allowsKey(leaf *x509.Certificate) bool {
+	for _, keyType := range r.keyTypes {
+		switch key := leaf.PublicKey.(type) {
+		case *rsa.PublicKey:
+			if keyType.Algorithm != "RSA" {
+				continue
+			}
+			minBits, _ := strconv.Atoi(keyType.Parameter)
+			if key.N.BitLen() >= minBits {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			if keyType.Algorithm == "ECDSA" && (keyType.Parameter == "" || keyType.Parameter == strings.ToUpper(key.Curve.Params().Name)) {
+				return true
+			}
+		case ed25519.PublicKey:
+			if keyType.Algorithm == "ED25519" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// returns true if the SANs of the leaf cover the hostname, an address must be an IP SAN
+func coversHostname(leaf *x509.Certificate, hostname string) bool {
+	if ip := net.ParseIP(hostname); ip != nil {
+		for _, address := range leaf.IPAddresses {
+			if address.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, name := range leaf.DNSNames {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		if name == hostname {
+			return true
+		}
+		// a wildcard covers a single label
+		if strings.HasPrefix(name, "*.") {
+			if dot := strings.Index(hostname, "."); dot > 0 && hostname[dot+1:] == name[2:] {
+				return true
+			}
+		}
+	}
+	return false
+}
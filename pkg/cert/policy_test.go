@@ -0,0 +1,94 @@
+package cert
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewPolicyChecker(t *testing.T) {
+	_, err := NewPolicyChecker([]PolicyRule{{Description: "no id"}})
+	require.NotNil(t, err)
+	_, err = NewPolicyChecker([]PolicyRule{{ID: "a"}, {ID: "a"}})
+	require.NotNil(t, err)
+	_, err = NewPolicyChecker([]PolicyRule{{ID: "a", Severity: "info"}})
+	require.NotNil(t, err)
+	_, err = NewPolicyChecker([]PolicyRule{{ID: "a", IssuerSKIs: []string{"zz"}}})
+	require.NotNil(t, err)
+	_, err = NewPolicyChecker([]PolicyRule{{ID: "a", KeyTypes: []string{"DSA 2048"}}})
+	require.NotNil(t, err)
+	_, err = NewPolicyChecker([]PolicyRule{{ID: "a", KeyTypes: []string{"RSA large"}}})
+	require.NotNil(t, err)
+
+	zones := []string{"*.Corp.Example.com."}
+	checker, err := NewPolicyChecker([]PolicyRule{{ID: "a", Zones: zones, KeyTypes: []string{"rsa 3072", "ECDSA P-256", "Ed25519"}}})
+	require.Nil(t, err)
+	require.Equal(t, []string{"corp.example.com"}, checker.rules[0].Zones)
+	require.Equal(t, []string{"*.Corp.Example.com."}, zones)
+	require.Equal(t, StatusCritical, checker.rules[0].status)
+}
+
+func Test_PolicyChecker(t *testing.T) {
+	corpCA := newTestCA(t, "Corp Issuing CA")
+	publicCA := newTestCA(t, "Public CA")
+	now := time.Now()
+	checker, err := NewPolicyChecker([]PolicyRule{
+		{
+			ID:              "corp-issuer",
+			Zones:           []string{"corp.example.com"},
+			IssuerSubjects:  []string{"CN=Other CA"},
+			IssuerSKIs:      []string{hex.EncodeToString(corpCA.cert.SubjectKeyId)},
+			ForbidWildcards: true,
+		},
+		{
+			ID:              "corp-keys",
+			Severity:        "warning",
+			KeyTypes:        []string{"ECDSA P-384", "RSA 3072"},
+			MaxValidityDays: 100,
+		},
+		{
+			ID:              "hostname",
+			RequireHostname: true,
+		},
+	})
+	require.Nil(t, err)
+	ids := func(result Result) []string {
+		return findingIDs(checker.Check(result, now))
+	}
+
+	leaf := newTestLeaf(t, corpCA, "www.corp.example.com")
+	require.Equal(t, []string{"corp-keys"}, ids(Result{Target: Target{Host: "192.0.2.1", Port: 443, ServerName: "www.corp.example.com"}, Chain: []*x509.Certificate{leaf.cert}}))
+
+	// the issuer rule only applies to the zone
+	leaf = newTestLeaf(t, publicCA, "www.corp.example.com")
+	require.Equal(t, []string{"corp-issuer", "corp-keys"}, ids(Result{Chain: []*x509.Certificate{leaf.cert}}))
+	leaf = newTestLeaf(t, publicCA, "www.example.com")
+	require.Equal(t, []string{"corp-keys"}, ids(Result{Chain: []*x509.Certificate{leaf.cert}}))
+
+	leaf = newTestLeaf(t, corpCA, "*.corp.example.com", "www.example.com")
+	findings := checker.Check(Result{Chain: []*x509.Certificate{leaf.cert}}, now)
+	require.Equal(t, []string{"corp-issuer", "corp-keys"}, findingIDs(findings))
+	require.Contains(t, findings[0].Message, "wildcard name *.corp.example.com")
+	require.Equal(t, StatusCritical, findings[0].Status)
+	require.Equal(t, StatusWarning, findings[1].Status)
+
+	key, err := rsa.GenerateKey(rand.Reader, 3072)
+	require.Nil(t, err)
+	leaf = newTestCertWithKey(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "api.example.com"},
+		DNSNames:  []string{"*.example.com"},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(90 * 24 * time.Hour),
+	}, publicCA, key)
+	require.Empty(t, ids(Result{Target: Target{Host: "192.0.2.1", Port: 443, ServerName: "api.example.com"}, Chain: []*x509.Certificate{leaf.cert}}))
+	findings = checker.Check(Result{Target: Target{Host: "192.0.2.1", Port: 443, ServerName: "a.b.example.com"}, Chain: []*x509.Certificate{leaf.cert}}, now)
+	require.Equal(t, []string{"hostname"}, findingIDs(findings))
+	require.Equal(t, "the SANs of api.example.com do not include a.b.example.com", findings[0].Message)
+	require.Equal(t, []string{"hostname"}, ids(Result{Target: Target{Host: "192.0.2.1", Port: 443}, Chain: []*x509.Certificate{leaf.cert}}))
+}
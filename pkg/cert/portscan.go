@@ -0,0 +1,165 @@
+package cert
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultPortScanWorkers = 512
+	defaultPortScanTimeout = time.Second
+)
+
+// ports assigned to implicit TLS services, the STARTTLS ports are listed in DefaultStartTLSPorts
+var WellKnownTLSPorts = []int{261, 443, 448, 465, 563, 614, 636, 684, 695, 853, 989, 990, 992, 993, 994, 995, 2484, 5061, 5223, 5986, 6514, 6697, 8443}
+
+// returns true if TLS is expected on the port, either implicit or with STARTTLS
+func IsStandardTLSPort(port int, startTLS map[int]string) bool {
+	if _, ok := startTLS[port]; ok {
+		return true
+	}
+	for _, wellKnown := range WellKnownTLSPorts {
+		if port == wellKnown {
+			return true
+		}
+	}
+	return false
+}
+
+// parses port ranges such as 443, 8000-8999 or 1-1024,8443 and returns the sorted
+// deduplicated ports
+func ParsePortRanges(specs []string) ([]int, error) {
+	seen := map[int]bool{}
+	ports := []int{}
+	for _, spec := range specs {
+		for _, part := range strings.Split(spec, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			bounds := strings.SplitN(part, "-", 2)
+			first, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+			if err != nil {
+				return nil, errors.Errorf("invalid port range %s", part)
+			}
+			last := first
+			if len(bounds) == 2 {
+				last, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+				if err != nil {
+					return nil, errors.Errorf("invalid port range %s", part)
+				}
+			}
+			if first <= 0 || last > 65535 || first > last {
+				return nil, errors.Errorf("invalid port range %s", part)
+			}
+			for port := first; port <= last; port++ {
+				if !seen[port] {
+					seen[port] = true
+					ports = append(ports, port)
+				}
+			}
+		}
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// formats the ports as comma separated ranges, the reverse of ParsePortRanges
+func FormatPortRanges(ports []int) string {
+	sorted := append([]int{}, ports...)
+	sort.Ints(sorted)
+	ranges := []string{}
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+			j++
+		}
+		if sorted[i] == sorted[j] {
+			ranges = append(ranges, strconv.Itoa(sorted[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
+
+// finds the open TCP ports with plain connections before the TLS probes
+type PortScanner struct {
+	Workers int
+	Timeout time.Duration
+	// connection attempts per second over all the workers, unlimited when 0
+	Rate int
+	// targets never probed
+	Exclusions *Exclusions
+	// paces the connections, shared with the TLS scanner
+	Limiter *RateLimiter
+}
+
+// creates a port scanner, zero values get the defaults
+func NewPortScanner(workers int, rate int, timeout time.Duration) *PortScanner {
+	if workers <= 0 || workers > maxWorkers {
+		workers = defaultPortScanWorkers
+	}
+	if timeout <= 0 {
+		timeout = defaultPortScanTimeout
+	}
+	if rate < 0 {
+		rate = 0
+	}
+	return &PortScanner{Workers: workers, Timeout: timeout, Rate: rate}
+}
+
+// connects to all the targets and forwards the ones accepting the connection.
+// The returned channel is closed once every target has been handled.
+func (p *PortScanner) Run(ctx context.Context, targets <-chan Target) <-chan Target {
+	open := make(chan Target)
+	limiter := NewRateLimiter(p.Rate, 0, 0)
+	wg := &sync.WaitGroup{}
+	wg.Add(p.Workers)
+	for i := 0; i < p.Workers; i++ {
+		go func() {
+			defer wg.Done()
+			for target := range targets {
+				if p.Exclusions.Excludes(target) {
+					continue
+				}
+				if limiter.Wait(ctx, target.Host) != nil || p.Limiter.Wait(ctx, target.Host) != nil {
+					return
+				}
+				if !p.IsOpen(ctx, target) {
+					continue
+				}
+				select {
+				case open <- target:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(open)
+	}()
+	return open
+}
+
+// returns true if the target accepts TCP connections
+func (p *PortScanner) IsOpen(ctx context.Context, target Target) bool {
+	dialer := &net.Dialer{Timeout: p.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", target.Address())
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
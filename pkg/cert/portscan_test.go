@@ -0,0 +1,101 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParsePortRanges(t *testing.T) {
+	ports, err := ParsePortRanges([]string{"8443,443", "8000-8002", " 443 "})
+	require.Nil(t, err)
+	require.Equal(t, []int{443, 8000, 8001, 8002, 8443}, ports)
+	require.Equal(t, "443,8000-8002,8443", FormatPortRanges(ports))
+
+	for _, spec := range []string{"0", "65536", "10-5", "https", "1-"} {
+		_, err := ParsePortRanges([]string{spec})
+		require.NotNil(t, err, spec)
+	}
+}
+
+func Test_IsStandardTLSPort(t *testing.T) {
+	startTLS, err := StartTLSPorts(map[string]string{"2525": "smtp"})
+	require.Nil(t, err)
+	require.True(t, IsStandardTLSPort(443, startTLS))
+	require.True(t, IsStandardTLSPort(2525, startTLS))
+	require.True(t, IsStandardTLSPort(587, startTLS))
+	require.False(t, IsStandardTLSPort(8080, startTLS))
+}
+
+func Test_PortScannerRun(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	leaf := newTestLeaf(t, ca, "127.0.0.1")
+	_, tlsPort := startTLSServer(t, &tls.Config{Certificates: []tls.Certificate{tlsCertificate(leaf)}})
+
+	// a plain TCP service
+	plain, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer plain.Close()
+	go func() {
+		for {
+			conn, err := plain.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-OpenSSH_8.2\r\n"))
+			conn.Close()
+		}
+	}()
+	plainPort := plain.Addr().(*net.TCPAddr).Port
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	subnets, err := ParseSubnets([]string{"127.0.0.1"})
+	require.Nil(t, err)
+	ctx := context.Background()
+	ports := []int{tlsPort, plainPort, closedPort}
+	open := map[int]bool{}
+	for target := range NewPortScanner(8, 0, time.Second).Run(ctx, SubnetTargets(ctx, subnets, ports)) {
+		open[target.Port] = true
+	}
+	require.Equal(t, map[int]bool{tlsPort: true, plainPort: true}, open)
+
+	conf, err := NewScanConfig(ports, 2, 2)
+	require.Nil(t, err)
+	result := NewScanner(conf).Probe(ctx, Target{Host: "127.0.0.1", Port: plainPort})
+	require.True(t, result.Reachable)
+	require.True(t, IsNotTLS(result.Err))
+	result = NewScanner(conf).Probe(ctx, Target{Host: "127.0.0.1", Port: tlsPort})
+	require.Nil(t, result.Err)
+}
+
+func Test_PortScannerRate(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	targets := make(chan Target)
+	go func() {
+		for i := 0; i < 5; i++ {
+			targets <- Target{Host: "127.0.0.1", Port: port}
+		}
+		close(targets)
+	}()
+
+	start := time.Now()
+	count := 0
+	for range NewPortScanner(5, 50, time.Second).Run(context.Background(), targets) {
+		count++
+	}
+	require.Equal(t, 5, count)
+	// the first attempt is immediate, the 4 others are spaced by 20ms
+	require.True(t, time.Since(start) >= 80*time.Millisecond)
+}
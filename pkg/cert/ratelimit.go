@@ -0,0 +1,113 @@
+package cert
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// above this number of tracked hosts, the ones whose next slot is past are forgotten
+const maxTrackedHosts = 4096
+
+// paces the connection attempts globally and per host, with an optional random delay.
+// A nil limiter does not limit anything.
+type RateLimiter struct {
+	interval     time.Duration
+	hostInterval time.Duration
+	jitter       time.Duration
+
+	lock sync.Mutex
+	next time.Time
+	// next allowed attempt by host
+	hosts map[string]time.Time
+}
+
+// returns a limiter allowing rate attempts per second overall and hostRate attempts per
+// second to the same host, each attempt is delayed by up to jitter. Zero values disable
+// the corresponding limit, nil is returned when none is set.
+func NewRateLimiter(rate int, hostRate int, jitter time.Duration) *RateLimiter {
+	if rate <= 0 && hostRate <= 0 && jitter <= 0 {
+		return nil
+	}
+	l := &RateLimiter{hosts: map[string]time.Time{}}
+	if rate > 0 {
+		l.interval = time.Second / time.Duration(rate)
+	}
+	if hostRate > 0 {
+		l.hostInterval = time.Second / time.Duration(hostRate)
+	}
+	if jitter > 0 {
+		l.jitter = jitter
+	}
+	return l
+}
+
+// blocks until an attempt to the host is allowed or the context is done
+func (l *RateLimiter) Wait(ctx context.Context, host string) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	if l.hostInterval > 0 {
+		if err := sleep(ctx, l.reserveHost(host)); err != nil {
+			return err
+		}
+	}
+	if l.interval > 0 {
+		if err := sleep(ctx, l.reserve()); err != nil {
+			return err
+		}
+	}
+	if l.jitter > 0 {
+		return sleep(ctx, time.Duration(rand.Int63n(int64(l.jitter))))
+	}
+	return ctx.Err()
+}
+
+// reserves the next global slot and returns the delay until it
+func (l *RateLimiter) reserve() time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	slot := l.next
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next = slot.Add(l.interval)
+	return slot.Sub(now)
+}
+
+// reserves the next slot of the host and returns the delay until it
+func (l *RateLimiter) reserveHost(host string) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	if len(l.hosts) > maxTrackedHosts {
+		for tracked, next := range l.hosts {
+			if next.Before(now) {
+				delete(l.hosts, tracked)
+			}
+		}
+	}
+	slot := l.hosts[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	l.hosts[host] = slot.Add(l.hostInterval)
+	return slot.Sub(now)
+}
+
+// waits for the given duration or until the context is done
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,40 @@
+package cert
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewRateLimiter(t *testing.T) {
+	require.Nil(t, NewRateLimiter(0, 0, 0))
+	var limiter *RateLimiter
+	require.Nil(t, limiter.Wait(context.Background(), "10.0.0.1"))
+}
+
+func Test_RateLimiterHost(t *testing.T) {
+	limiter := NewRateLimiter(0, 20, 0)
+	ctx := context.Background()
+
+	// other hosts are not delayed by the per host rate
+	start := time.Now()
+	for _, host := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		require.Nil(t, limiter.Wait(ctx, host))
+	}
+	require.True(t, time.Since(start) < 50*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		require.Nil(t, limiter.Wait(ctx, "10.0.0.1"))
+	}
+	require.True(t, time.Since(start) >= 100*time.Millisecond)
+}
+
+func Test_RateLimiterCancel(t *testing.T) {
+	limiter := NewRateLimiter(1, 0, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	require.Nil(t, limiter.Wait(ctx, "10.0.0.1"))
+	cancel()
+	require.NotNil(t, limiter.Wait(ctx, "10.0.0.1"))
+}
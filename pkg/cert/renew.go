@@ -0,0 +1,327 @@
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+)
+
+// a certificate renewed from an ACME directory when the scans find it expiring
+type RenewalTarget struct {
+	// names of the certificate, the first one is its common name
+	Domains []string
+	// PEM file receiving the chain, and the key when KeyPath is empty
+	CertPath string
+	KeyPath  string
+	// shell command run after the files are written, e.g. to reload the web server
+	Hook string
+}
+
+// returns true if the endpoint presents a certificate for one of the domains of the
+// target, or is reached by one of them
+func (t RenewalTarget) Matches(result Result) bool {
+	names := []string{result.ServerName, result.Host}
+	if leaf := result.Leaf(); leaf != nil {
+		names = append(names, leaf.DNSNames...)
+	}
+	for _, name := range names {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		for _, domain := range t.Domains {
+			if name != "" && name == strings.ToLower(domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// returns true if the target matches an expiring certificate of the results
+func (t RenewalTarget) Expiring(results []Result, expiry ExpiryConfig, now time.Time) bool {
+	for _, result := range results {
+		leaf := result.Leaf()
+		if leaf != nil && expiry.Evaluate(leaf, now) != StatusOK && t.Matches(result) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the targets matching the expiring certificates of the results, each one once
+func ExpiringTargets(results []Result, targets []RenewalTarget, expiry ExpiryConfig, now time.Time) []RenewalTarget {
+	selected := []RenewalTarget{}
+	for _, target := range targets {
+		if target.Expiring(results, expiry, now) {
+			selected = append(selected, target)
+		}
+	}
+	return selected
+}
+
+// requests certificates from an ACME directory (RFC 8555)
+type Renewer struct {
+	Client *acme.Client
+	// contact of the account, e.g. mailto:admin@example.com
+	Contact  []string
+	Provider ChallengeProvider
+
+	registered bool
+}
+
+// creates a renewer of the ACME directory authenticated with the account key
+func NewRenewer(directoryURL string, accountKey crypto.Signer, provider ChallengeProvider, contact []string) *Renewer {
+	return &Renewer{
+		Client:   &acme.Client{Key: accountKey, DirectoryURL: directoryURL, UserAgent: "sentinel"},
+		Contact:  contact,
+		Provider: provider,
+	}
+}
+
+// orders a certificate for the domains with a new ECDSA P-256 key, returns the chain
+// issued and the key
+func (r *Renewer) Renew(ctx context.Context, domains []string) ([][]byte, crypto.Signer, error) {
+	if len(domains) == 0 {
+		return nil, nil, errors.New("no domain to renew")
+	}
+	if err := r.register(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	order, err := r.Client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "cannot order a certificate for %s", strings.Join(domains, ", "))
+	}
+	for _, url := range order.AuthzURLs {
+		if err := r.authorize(ctx, url); err != nil {
+			return nil, nil, err
+		}
+	}
+	if order, err = r.Client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, nil, errors.Wrapf(err, "order of %s failed", strings.Join(domains, ", "))
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, _, err := r.Client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "cannot finalize the order of %s", strings.Join(domains, ", "))
+	}
+	return chain, key, nil
+}
+
+// registers the account key once, an existing account is reused
+func (r *Renewer) register(ctx context.Context) error {
+	if r.registered {
+		return nil
+	}
+	_, err := r.Client.Register(ctx, &acme.Account{Contact: r.Contact}, acme.AcceptTOS)
+	if err != nil && err != acme.ErrAccountAlreadyExists {
+		return errors.Wrapf(err, "cannot register with %s", r.Client.DirectoryURL)
+	}
+	r.registered = true
+	return nil
+}
+
+// fulfills the challenge of the authorization supported by the provider
+func (r *Renewer) authorize(ctx context.Context, url string) error {
+	authz, err := r.Client.GetAuthorization(ctx, url)
+	if err != nil {
+		return errors.Wrap(err, "cannot get authorization")
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	domain := authz.Identifier.Value
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == r.Provider.Type() {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return errors.Errorf("no %s challenge offered for %s", r.Provider.Type(), domain)
+	}
+
+	var value string
+	switch challenge.Type {
+	case ChallengeHTTP01:
+		value, err = r.Client.HTTP01ChallengeResponse(challenge.Token)
+	case ChallengeDNS01:
+		value, err = r.Client.DNS01ChallengeRecord(challenge.Token)
+	}
+	if err != nil {
+		return err
+	}
+	if err := r.Provider.Present(ctx, domain, challenge.Token, value); err != nil {
+		return errors.Wrapf(err, "cannot present the %s challenge of %s", challenge.Type, domain)
+	}
+	defer r.Provider.CleanUp(ctx, domain, challenge.Token, value)
+
+	if _, err := r.Client.Accept(ctx, challenge); err != nil {
+		return errors.Wrapf(err, "cannot accept the %s challenge of %s", challenge.Type, domain)
+	}
+	if _, err := r.Client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return errors.Wrapf(err, "authorization of %s failed", domain)
+	}
+	return nil
+}
+
+// reads the PEM account key at path, a new ECDSA P-256 key is generated and saved
+// when the file does not exist
+func LoadAccountKey(path string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+			return nil, errors.Wrapf(err, "cannot save account key %s", path)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read account key %s", path)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf("no PEM block in account key %s", path)
+	}
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid account key %s", path)
+	}
+	return key, nil
+}
+
+// returns the PKCS#8, PKCS#1 or SEC 1 private key
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, errors.New("unsupported private key type")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return x509.ParseECPrivateKey(der)
+}
+
+// writes the chain and the key of the renewed certificate to the files of the target.
+// Both files are replaced only once both are written, the key never gets ahead of the
+// certificate.
+func (t RenewalTarget) Write(chain [][]byte, key crypto.Signer) error {
+	certPEM := &bytes.Buffer{}
+	for _, der := range chain {
+		pem.Encode(certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	if t.KeyPath == "" {
+		// a single file with the chain and the key, as expected by HAProxy
+		return writeFileAtomic(t.CertPath, append(certPEM.Bytes(), keyPEM...), 0600)
+	}
+	keyTmp, err := writeTempFile(t.KeyPath, keyPEM, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(keyTmp)
+	certTmp, err := writeTempFile(t.CertPath, certPEM.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(certTmp)
+	if err := os.Rename(certTmp, t.CertPath); err != nil {
+		return err
+	}
+	return os.Rename(keyTmp, t.KeyPath)
+}
+
+// runs the hook of the target with the shell, the domains and the paths are given in
+// the SENTINEL_RENEWED_DOMAINS, SENTINEL_CERT_PATH and SENTINEL_KEY_PATH variables
+func (t RenewalTarget) RunHook(ctx context.Context) error {
+	if t.Hook == "" {
+		return nil
+	}
+	command := exec.CommandContext(ctx, "/bin/sh", "-c", t.Hook)
+	command.Env = append(os.Environ(),
+		"SENTINEL_RENEWED_DOMAINS="+strings.Join(t.Domains, " "),
+		"SENTINEL_CERT_PATH="+t.CertPath,
+		"SENTINEL_KEY_PATH="+t.KeyPath,
+	)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "hook %q failed: %s", t.Hook, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// replaces the file with the data through a temporary file in the same directory
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := writeTempFile(path, data, mode)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Rename(tmp, path)
+}
+
+// writes the data to a temporary file in the directory of path and returns its name,
+// the caller renames or removes it
+func writeTempFile(path string, data []byte, mode os.FileMode) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
@@ -0,0 +1,228 @@
+package cert
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// a minimal ACME directory (RFC 8555) issuing certificates from a test CA once the
+// challenges pass the validation
+type testACMEServer struct {
+	*httptest.Server
+	ca *testCert
+	// returns true if the response to the challenge of the domain is published
+	validate func(challengeType string, domain string, token string) bool
+
+	lock   sync.Mutex
+	order  map[string]interface{}
+	authzs map[string]string
+	chain  []byte
+}
+
+func startACMEServer(t *testing.T, ca *testCert, validate func(string, string, string) bool) *testACMEServer {
+	s := &testACMEServer{ca: ca, validate: validate, authzs: map[string]string{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testACMEServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	if r.Method == http.MethodHead {
+		return
+	}
+	reply := func(status int, location string, v interface{}) {
+		if location != "" {
+			w.Header().Set("Location", s.URL+location)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	if r.URL.Path == "/directory" {
+		reply(http.StatusOK, "", map[string]string{"newNonce": s.URL + "/nonce", "newAccount": s.URL + "/account", "newOrder": s.URL + "/order"})
+		return
+	}
+
+	jws := struct{ Payload string }{}
+	json.NewDecoder(r.Body).Decode(&jws)
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	switch {
+	case r.URL.Path == "/account":
+		reply(http.StatusCreated, "/account/1", map[string]string{"status": "valid"})
+	case r.URL.Path == "/order":
+		request := struct{ Identifiers []map[string]string }{}
+		json.Unmarshal(payload, &request)
+		authorizations := []string{}
+		for _, identifier := range request.Identifiers {
+			s.authzs[identifier["value"]] = "pending"
+			authorizations = append(authorizations, s.URL+"/authz/"+identifier["value"])
+		}
+		s.order = map[string]interface{}{"status": "pending", "identifiers": request.Identifiers, "authorizations": authorizations, "finalize": s.URL + "/finalize"}
+		reply(http.StatusCreated, "/order/1", s.order)
+	case r.URL.Path == "/order/1":
+		reply(http.StatusOK, "/order/1", s.order)
+	case strings.HasPrefix(r.URL.Path, "/authz/"):
+		domain := strings.TrimPrefix(r.URL.Path, "/authz/")
+		challenges := []map[string]string{}
+		for _, challengeType := range []string{ChallengeHTTP01, ChallengeDNS01} {
+			challenges = append(challenges, map[string]string{"type": challengeType, "url": s.URL + "/challenge/" + challengeType + "/" + domain, "token": "token-" + domain})
+		}
+		reply(http.StatusOK, "", map[string]interface{}{"identifier": map[string]string{"type": "dns", "value": domain}, "status": s.authzs[domain], "challenges": challenges})
+	case strings.HasPrefix(r.URL.Path, "/challenge/"):
+		parts := strings.Split(r.URL.Path, "/")
+		challengeType, domain := parts[2], parts[3]
+		status := "valid"
+		if !s.validate(challengeType, domain, "token-"+domain) {
+			status = "invalid"
+		}
+		s.authzs[domain] = status
+		ready := true
+		for _, authz := range s.authzs {
+			ready = ready && authz == "valid"
+		}
+		if ready {
+			s.order["status"] = "ready"
+		} else if status == "invalid" {
+			s.order["status"] = "invalid"
+		}
+		reply(http.StatusOK, "", map[string]string{"type": challengeType, "url": s.URL + r.URL.Path, "token": "token-" + domain, "status": status})
+	case r.URL.Path == "/finalize":
+		request := struct{ CSR string }{}
+		json.Unmarshal(payload, &request)
+		der, _ := base64.RawURLEncoding.DecodeString(request.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		template := &x509.Certificate{
+			SerialNumber: s.ca.cert.SerialNumber,
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		}
+		leaf, err := x509.CreateCertificate(rand.Reader, template, s.ca.cert, csr.PublicKey, s.ca.key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.chain = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.ca.cert.Raw})...)
+		s.order["status"] = "valid"
+		s.order["certificate"] = s.URL + "/certificate"
+		reply(http.StatusOK, "/order/1", s.order)
+	case r.URL.Path == "/certificate":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(s.chain)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func Test_Renewer(t *testing.T) {
+	ca := newTestCA(t, "Sentinel ACME CA")
+	root := tempDir(t)
+	accountKey, err := LoadAccountKey(filepath.Join(root, "account", "key.pem"))
+	require.Nil(t, err)
+	// the saved key is reused
+	reloaded, err := LoadAccountKey(filepath.Join(root, "account", "key.pem"))
+	require.Nil(t, err)
+	require.Equal(t, accountKey.Public(), reloaded.Public())
+
+	webroot := &WebrootProvider{Root: filepath.Join(root, "www")}
+	server := startACMEServer(t, ca, nil)
+	renewer := NewRenewer(server.URL+"/directory", accountKey, webroot, []string{"mailto:admin@example.com"})
+	presented := map[string]bool{}
+	server.validate = func(challengeType string, domain string, token string) bool {
+		expected, err := renewer.Client.HTTP01ChallengeResponse(token)
+		require.Nil(t, err)
+		data, err := ioutil.ReadFile(filepath.Join(webroot.Root, ".well-known", "acme-challenge", token))
+		presented[domain] = err == nil && string(data) == expected
+		return challengeType == ChallengeHTTP01 && presented[domain]
+	}
+
+	chain, key, err := renewer.Renew(context.Background(), []string{"www.example.com", "example.com"})
+	require.Nil(t, err)
+	require.Equal(t, map[string]bool{"www.example.com": true, "example.com": true}, presented)
+	require.Len(t, chain, 2)
+	leaf, err := x509.ParseCertificate(chain[0])
+	require.Nil(t, err)
+	require.Equal(t, []string{"www.example.com", "example.com"}, leaf.DNSNames)
+	require.Equal(t, key.Public(), leaf.PublicKey)
+	// the challenge responses are removed
+	files, err := ioutil.ReadDir(filepath.Join(webroot.Root, ".well-known", "acme-challenge"))
+	require.Nil(t, err)
+	require.Empty(t, files)
+
+	// the challenge is refused
+	server.validate = func(string, string, string) bool { return false }
+	_, _, err = renewer.Renew(context.Background(), []string{"www.example.com"})
+	require.NotNil(t, err)
+}
+
+func Test_RenewalTarget(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	expiring := newTestCert(t, &x509.Certificate{DNSNames: []string{"www.example.com"}, NotAfter: time.Now().Add(5 * 24 * time.Hour)}, ca)
+	valid := newTestLeaf(t, ca, "api.example.com")
+	expiry, err := NewExpiryConfig(30, 7)
+	require.Nil(t, err)
+
+	dir := tempDir(t)
+	www := RenewalTarget{Domains: []string{"www.example.com"}, CertPath: filepath.Join(dir, "www.pem"), Hook: "echo $SENTINEL_RENEWED_DOMAINS > " + filepath.Join(dir, "hook")}
+	api := RenewalTarget{Domains: []string{"api.example.com"}, CertPath: filepath.Join(dir, "api.crt"), KeyPath: filepath.Join(dir, "api.key")}
+	results := []Result{
+		{Target: Target{Host: "192.0.2.1", Port: 443}, Chain: []*x509.Certificate{expiring.cert, ca.cert}},
+		{Target: Target{Host: "192.0.2.2", Port: 443, ServerName: "api.example.com"}, Chain: []*x509.Certificate{valid.cert, ca.cert}},
+	}
+	require.Equal(t, []RenewalTarget{www}, ExpiringTargets(results, []RenewalTarget{www, api}, expiry, time.Now()))
+
+	chain := [][]byte{expiring.cert.Raw, ca.cert.Raw}
+	for _, target := range []RenewalTarget{www, api} {
+		require.Nil(t, target.Write(chain, expiring.key))
+		keyFile := target.KeyPath
+		if keyFile == "" {
+			keyFile = target.CertPath
+		}
+		pair, err := tls.LoadX509KeyPair(target.CertPath, keyFile)
+		require.Nil(t, err)
+		require.Len(t, pair.Certificate, 2)
+		info, err := os.Stat(keyFile)
+		require.Nil(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// the key is not replaced when the certificate cannot be written
+	previousKey, err := ioutil.ReadFile(api.KeyPath)
+	require.Nil(t, err)
+	broken := RenewalTarget{CertPath: filepath.Join(api.KeyPath, "api.crt"), KeyPath: api.KeyPath}
+	require.NotNil(t, broken.Write(chain, valid.key))
+	key, err := ioutil.ReadFile(api.KeyPath)
+	require.Nil(t, err)
+	require.Equal(t, previousKey, key)
+
+	require.Nil(t, www.RunHook(context.Background()))
+	output, err := ioutil.ReadFile(filepath.Join(dir, "hook"))
+	require.Nil(t, err)
+	require.Equal(t, "www.example.com\n", string(output))
+	require.NotNil(t, RenewalTarget{Hook: "exit 3"}.RunHook(context.Background()))
+}
@@ -0,0 +1,393 @@
+package cert
+
+import (
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// formats of the reports besides the default table
+var ReportFormats = []string{"json", "ndjson", "csv", "html", "junit"}
+
+// expiry buckets of the HTML report, by increasing remaining validity
+var reportBuckets = []Status{StatusExpired, StatusCritical, StatusWarning, StatusOK}
+
+// the outcome of a scan in a machine readable form
+type Report struct {
+	// the Nagios/Icinga plugin output line
+	Summary      string            `json:"summary"`
+	Status       string            `json:"status"`
+	GeneratedAt  time.Time         `json:"generatedAt"`
+	WarningDays  int               `json:"warningDays"`
+	CriticalDays int               `json:"criticalDays"`
+	Endpoints    []*ReportEndpoint `json:"endpoints"`
+
+	expiry ExpiryConfig
+}
+
+// an endpoint, file or Kubernetes resource and its certificates
+type ReportEndpoint struct {
+	// unique name of the endpoint, e.g. 10.0.0.1:443 (www.example.com)
+	Name       string `json:"name"`
+	Host       string `json:"host,omitempty"`
+	Port       int    `json:"port,omitempty"`
+	ServerName string `json:"serverName,omitempty"`
+	Protocol   string `json:"protocol,omitempty"`
+	Path       string `json:"path,omitempty"`
+	// the server name is answered with the default certificate not covering it
+	Fallback bool `json:"fallback,omitempty"`
+	// client authentication requested by the endpoint and the CAs it advertises
+	ClientAuth          string               `json:"clientAuth,omitempty"`
+	ClientCAs           []string             `json:"clientCAs,omitempty"`
+	ClientCertPresented bool                 `json:"clientCertPresented,omitempty"`
+	Status              string               `json:"status"`
+	Certificates        []*ReportCertificate `json:"certificates"`
+	Findings            []*ReportFinding     `json:"findings"`
+
+	status Status
+}
+
+type ReportCertificate struct {
+	Depth       int       `json:"depth"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Serial      string    `json:"serial"`
+	Fingerprint string    `json:"fingerprint"`
+	SANs        []string  `json:"sans"`
+	Key         string    `json:"key"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	DaysLeft    int       `json:"daysLeft"`
+	Status      string    `json:"status"`
+	Revocation  string    `json:"revocation,omitempty"`
+
+	status Status
+}
+
+type ReportFinding struct {
+	Check   string `json:"check"`
+	ID      string `json:"id"`
+	Status  string `json:"status"`
+	Depth   int    `json:"depth"`
+	Message string `json:"message"`
+}
+
+// creates an empty report with the given expiry thresholds
+func NewReport(expiry ExpiryConfig, now time.Time) *Report {
+	return &Report{
+		GeneratedAt:  now,
+		WarningDays:  expiry.WarningDays,
+		CriticalDays: expiry.CriticalDays,
+		Endpoints:    []*ReportEndpoint{},
+		expiry:       expiry,
+	}
+}
+
+// adds the endpoint of a scan result with its chain and its findings
+func (r *Report) AddResult(result Result) *ReportEndpoint {
+	name := result.Address()
+	if result.ServerName != "" {
+		name += " (" + result.ServerName + ")"
+	}
+	endpoint := r.AddEndpoint(name, result.Chain, result.Findings)
+	endpoint.Host, endpoint.Port, endpoint.ServerName, endpoint.Protocol = result.Host, result.Port, result.ServerName, result.Protocol
+	endpoint.Fallback = result.Fallback
+	endpoint.ClientAuth, endpoint.ClientCAs, endpoint.ClientCertPresented = result.ClientAuth, result.ClientCAs, result.ClientCertPresented
+	for depth, c := range endpoint.Certificates {
+		c.Revocation = RevocationState(result.Findings, depth)
+	}
+	return endpoint
+}
+
+// adds an endpoint with its certificates and findings, its status is the most severe one
+func (r *Report) AddEndpoint(name string, chain []*x509.Certificate, findings []Finding) *ReportEndpoint {
+	endpoint := &ReportEndpoint{Name: name, Status: StatusOK.String(), Certificates: []*ReportCertificate{}, Findings: []*ReportFinding{}}
+	for depth, c := range chain {
+		sans := append([]string{}, c.DNSNames...)
+		for _, ip := range c.IPAddresses {
+			sans = append(sans, ip.String())
+		}
+		sans = append(sans, c.EmailAddresses...)
+		status := r.expiry.Evaluate(c, r.GeneratedAt)
+		endpoint.Certificates = append(endpoint.Certificates, &ReportCertificate{
+			Depth:       depth,
+			Subject:     c.Subject.String(),
+			Issuer:      c.Issuer.String(),
+			Serial:      c.SerialNumber.String(),
+			Fingerprint: Fingerprint(c),
+			SANs:        sans,
+			Key:         KeyType(c),
+			NotBefore:   c.NotBefore.UTC(),
+			NotAfter:    c.NotAfter.UTC(),
+			DaysLeft:    DaysLeft(c, r.GeneratedAt),
+			Status:      status.String(),
+			status:      status,
+		})
+		endpoint.Raise(status)
+	}
+	for _, finding := range findings {
+		if finding.Status == StatusOK {
+			continue
+		}
+		endpoint.Findings = append(endpoint.Findings, &ReportFinding{
+			Check:   finding.Check,
+			ID:      finding.ID,
+			Status:  finding.Status.String(),
+			Depth:   finding.Depth,
+			Message: finding.Message,
+		})
+		endpoint.Raise(finding.Status)
+	}
+	r.Endpoints = append(r.Endpoints, endpoint)
+	return endpoint
+}
+
+// raises the status of the endpoint to the given one if it is more severe
+func (e *ReportEndpoint) Raise(status Status) {
+	if status > e.status {
+		e.status = status
+	}
+	e.Status = e.status.String()
+}
+
+// sets the plugin output line and the overall status of the report
+func (r *Report) SetSummary(summary *Summary) {
+	r.Summary = summary.String()
+	r.Status = summary.Status.String()
+	if summary.Total == 0 {
+		r.Status = "UNKNOWN"
+	}
+}
+
+// writes the report in one of ReportFormats
+func WriteReport(w io.Writer, format string, report *Report) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case "ndjson":
+		// one endpoint per line
+		encoder := json.NewEncoder(w)
+		for _, endpoint := range report.Endpoints {
+			if err := encoder.Encode(endpoint); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		return writeCSVReport(w, report)
+	case "html":
+		return writeHTMLReport(w, report)
+	case "junit":
+		return writeJUnitReport(w, report)
+	}
+	return errors.Errorf("unknown output format %s, expected table, %s", format, strings.Join(ReportFormats, ", "))
+}
+
+// writes one row per certificate, the findings of the endpoint are repeated on each row
+func writeCSVReport(w io.Writer, report *Report) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"endpoint", "host", "port", "sni", "path", "depth", "subject", "issuer", "serial", "fingerprint",
+		"sans", "key", "not_before", "not_after", "days_left", "status", "revocation", "endpoint_status", "findings"})
+	for _, endpoint := range report.Endpoints {
+		port := ""
+		if endpoint.Port != 0 {
+			port = strconv.Itoa(endpoint.Port)
+		}
+		findings := []string{}
+		for _, finding := range endpoint.Findings {
+			findings = append(findings, finding.Check+"/"+finding.ID)
+		}
+		row := func(c *ReportCertificate) []string {
+			if c == nil {
+				return []string{endpoint.Name, endpoint.Host, port, endpoint.ServerName, endpoint.Path,
+					"", "", "", "", "", "", "", "", "", "", "", "", endpoint.Status, strings.Join(findings, " ")}
+			}
+			return []string{endpoint.Name, endpoint.Host, port, endpoint.ServerName, endpoint.Path,
+				strconv.Itoa(c.Depth), c.Subject, c.Issuer, c.Serial, c.Fingerprint, strings.Join(c.SANs, " "), c.Key,
+				c.NotBefore.Format(time.RFC3339), c.NotAfter.Format(time.RFC3339), strconv.Itoa(c.DaysLeft), c.Status,
+				c.Revocation, endpoint.Status, strings.Join(findings, " ")}
+		}
+		if len(endpoint.Certificates) == 0 {
+			writer.Write(row(nil))
+		}
+		for _, c := range endpoint.Certificates {
+			writer.Write(row(c))
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// a row of the HTML report
+type htmlReportRow struct {
+	Endpoint *ReportEndpoint
+	*ReportCertificate
+}
+
+type htmlReportBucket struct {
+	Status string
+	Title  string
+	Rows   []htmlReportRow
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Certificate report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; font-size: 0.9em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #eee; }
+h2 { padding: 0.3em 0.6em; color: #fff; }
+h2.EXPIRED { background: #7b1c1c; }
+h2.CRITICAL { background: #c62828; }
+h2.WARNING { background: #ef8f00; }
+h2.OK { background: #2e7d32; }
+ul { margin: 0; padding-left: 1.2em; }
+</style>
+</head>
+<body>
+<h1>Certificate report</h1>
+<p>{{.Report.Summary}}</p>
+<p>Generated at {{.Report.GeneratedAt.UTC.Format "2006-01-02 15:04:05"}} UTC, warning at {{.Report.WarningDays}} days, critical at {{.Report.CriticalDays}} days.</p>
+{{range .Buckets}}<h2 class="{{.Status}}">{{.Title}} ({{len .Rows}})</h2>
+{{if .Rows}}<table>
+<tr><th>Endpoint</th><th>Depth</th><th>Subject</th><th>Issuer</th><th>SANs</th><th>Not After</th><th>Days Left</th><th>Revocation</th><th>Findings</th></tr>
+{{range .Rows}}<tr><td>{{.Endpoint.Name}}</td><td>{{.Depth}}</td><td>{{.Subject}}</td><td>{{.Issuer}}</td><td>{{range .SANs}}{{.}}<br>{{end}}</td><td>{{.NotAfter.Format "2006-01-02 15:04:05"}}</td><td>{{.DaysLeft}}</td><td>{{.Revocation}}</td><td>{{if eq .Depth 0}}<ul>{{range .Endpoint.Findings}}<li>{{.Status}} {{.Check}}/{{.ID}}: {{.Message}}</li>{{end}}</ul>{{end}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}</body>
+</html>
+`))
+
+// writes a self-contained HTML page listing the certificates by expiry bucket, the
+// soonest expiries first
+func writeHTMLReport(w io.Writer, report *Report) error {
+	buckets := []*htmlReportBucket{}
+	byStatus := map[Status]*htmlReportBucket{}
+	for _, status := range reportBuckets {
+		bucket := &htmlReportBucket{Status: status.String()}
+		switch status {
+		case StatusExpired:
+			bucket.Title = "Expired"
+		case StatusCritical:
+			bucket.Title = fmt.Sprintf("Expiring within %d days", report.CriticalDays)
+		case StatusWarning:
+			bucket.Title = fmt.Sprintf("Expiring within %d days", report.WarningDays)
+		case StatusOK:
+			bucket.Title = fmt.Sprintf("Valid for more than %d days", report.WarningDays)
+		}
+		buckets = append(buckets, bucket)
+		byStatus[status] = bucket
+	}
+	for _, endpoint := range report.Endpoints {
+		for _, c := range endpoint.Certificates {
+			bucket := byStatus[c.status]
+			bucket.Rows = append(bucket.Rows, htmlReportRow{Endpoint: endpoint, ReportCertificate: c})
+		}
+	}
+	for _, bucket := range buckets {
+		sort.SliceStable(bucket.Rows, func(i int, j int) bool {
+			return bucket.Rows[i].NotAfter.Before(bucket.Rows[j].NotAfter)
+		})
+	}
+	return htmlReportTemplate.Execute(w, struct {
+		Report  *Report
+		Buckets []*htmlReportBucket
+	}{report, buckets})
+}
+
+// the JUnit XML elements understood by the CI servers
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writes one testcase per endpoint, failed when the endpoint is not OK
+func writeJUnitReport(w io.Writer, report *Report) error {
+	suite := junitTestSuite{Name: "certificates", Timestamp: report.GeneratedAt.UTC().Format(time.RFC3339)}
+	for _, endpoint := range report.Endpoints {
+		testCase := junitTestCase{Name: endpoint.Name, ClassName: junitClassName(endpoint)}
+		lines := []string{}
+		for _, c := range endpoint.Certificates {
+			lines = append(lines, fmt.Sprintf("%d %s: %s, expires %s (%d days)", c.Depth, c.Status, c.Subject,
+				c.NotAfter.Format("2006-01-02 15:04:05"), c.DaysLeft))
+		}
+		if endpoint.status != StatusOK {
+			problems := []string{}
+			for _, c := range endpoint.Certificates {
+				if c.status != StatusOK {
+					problems = append(problems, fmt.Sprintf("certificate %s expires in %d days", c.Subject, c.DaysLeft))
+				}
+			}
+			for _, finding := range endpoint.Findings {
+				problems = append(problems, finding.Status+" "+finding.Check+"/"+finding.ID+": "+finding.Message)
+			}
+			message := endpoint.Status
+			if len(problems) != 0 {
+				message = problems[0]
+			}
+			testCase.Failure = &junitFailure{Message: message, Type: endpoint.Status, Text: strings.Join(problems, "\n")}
+			suite.Failures++
+		}
+		testCase.SystemOut = strings.Join(lines, "\n")
+		suite.Cases = append(suite.Cases, testCase)
+		suite.Tests++
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// groups the testcases by host or path
+func junitClassName(endpoint *ReportEndpoint) string {
+	switch {
+	case endpoint.Host != "":
+		return endpoint.Host
+	case endpoint.Path != "":
+		return endpoint.Path
+	}
+	return "certificates"
+}
@@ -0,0 +1,113 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestReport(t *testing.T) *Report {
+	now := time.Now()
+	ca := newTestCA(t, "Sentinel Test CA")
+	leaf := newTestLeaf(t, ca, "www.example.com")
+	expiring := newTestCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "old.example.com"},
+		DNSNames:  []string{"old.example.com"},
+		NotBefore: now.Add(-24 * time.Hour),
+		NotAfter:  now.Add(3 * 24 * time.Hour),
+	}, ca)
+
+	expiry, err := NewExpiryConfig(30, 7)
+	require.Nil(t, err)
+	report := NewReport(expiry, now)
+	report.AddResult(Result{
+		Target: Target{Host: "10.0.0.1", Port: 443, ServerName: "www.example.com"},
+		Chain:  []*x509.Certificate{leaf.cert, ca.cert},
+	})
+	report.AddResult(Result{
+		Target: Target{Host: "10.0.0.2", Port: 8443},
+		Chain:  []*x509.Certificate{expiring.cert},
+		Findings: []Finding{
+			newFinding(hygieneCheck, "weak-key", StatusCritical, 0, "RSA key of 1024 bits"),
+			newFinding(chainCheck, "trusted", StatusOK, -1, "chain is trusted"),
+		},
+	})
+
+	summary := NewSummary(expiry)
+	summary.Add(StatusCritical, 3)
+	report.SetSummary(summary)
+	return report
+}
+
+func Test_WriteReportJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.Nil(t, WriteReport(buf, "json", newTestReport(t)))
+	decoded := Report{}
+	require.Nil(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, "CRITICAL", decoded.Status)
+	require.Len(t, decoded.Endpoints, 2)
+	require.Equal(t, "10.0.0.1:443 (www.example.com)", decoded.Endpoints[0].Name)
+	require.Equal(t, "OK", decoded.Endpoints[0].Status)
+	require.Len(t, decoded.Endpoints[0].Certificates, 2)
+	require.Equal(t, []string{"www.example.com"}, decoded.Endpoints[0].Certificates[0].SANs)
+	// OK findings are omitted
+	require.Len(t, decoded.Endpoints[1].Findings, 1)
+	require.Equal(t, "CRITICAL", decoded.Endpoints[1].Status)
+
+	buf.Reset()
+	require.Nil(t, WriteReport(buf, "ndjson", newTestReport(t)))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	endpoint := ReportEndpoint{}
+	require.Nil(t, json.Unmarshal([]byte(lines[1]), &endpoint))
+	require.Equal(t, 8443, endpoint.Port)
+
+	require.NotNil(t, WriteReport(buf, "yaml", newTestReport(t)))
+}
+
+func Test_WriteReportCSV(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.Nil(t, WriteReport(buf, "csv", newTestReport(t)))
+	rows, err := csv.NewReader(buf).ReadAll()
+	require.Nil(t, err)
+	require.Len(t, rows, 4)
+	require.Equal(t, "endpoint", rows[0][0])
+	require.Equal(t, "8443", rows[3][2])
+	require.Equal(t, "CRITICAL", rows[3][15])
+	require.Equal(t, "hygiene/weak-key", rows[3][18])
+}
+
+func Test_WriteReportHTML(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.Nil(t, WriteReport(buf, "html", newTestReport(t)))
+	html := buf.String()
+	require.Contains(t, html, `<h2 class="CRITICAL">Expiring within 7 days (1)</h2>`)
+	require.Contains(t, html, `<h2 class="OK">Valid for more than 30 days (2)</h2>`)
+	require.Contains(t, html, `<h2 class="EXPIRED">Expired (0)</h2>`)
+	// the critical bucket is listed before the valid certificates
+	require.True(t, strings.Index(html, "old.example.com") < strings.Index(html, "www.example.com"))
+	require.Contains(t, html, "RSA key of 1024 bits")
+}
+
+func Test_WriteReportJUnit(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.Nil(t, WriteReport(buf, "junit", newTestReport(t)))
+	suites := junitTestSuites{}
+	require.Nil(t, xml.Unmarshal(buf.Bytes(), &suites))
+	require.Len(t, suites.Suites, 1)
+	suite := suites.Suites[0]
+	require.Equal(t, 2, suite.Tests)
+	require.Equal(t, 1, suite.Failures)
+	require.Nil(t, suite.Cases[0].Failure)
+	require.NotNil(t, suite.Cases[1].Failure)
+	require.Equal(t, "CRITICAL", suite.Cases[1].Failure.Type)
+	require.Contains(t, suite.Cases[1].Failure.Text, "hygiene/weak-key")
+}
@@ -0,0 +1,256 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	revocationCheck = "revocation"
+	// maximum size of a downloaded CRL or OCSP response
+	maxRevocationDownload = 64 * 1024 * 1024
+)
+
+// checks the revocation status of the presented certificates with the stapled OCSP
+// response, the OCSP responder or the CRL distribution points, in this order
+type RevocationChecker struct {
+	Client *http.Client
+	// replace the responder and distribution points of the certificates when not empty
+	OCSPURL string
+	CRLURL  string
+	// directory where the downloaded CRLs are kept until their next update, no disk cache when empty
+	CacheDir string
+
+	lock sync.Mutex
+	crls map[string]*pkix.CertificateList
+}
+
+// the revocation status of a certificate, as reported by one of the sources
+type revocationStatus struct {
+	revoked   bool
+	revokedAt time.Time
+	source    string
+}
+
+// returns a finding per certificate followed by its issuer in the chain. A certificate
+// neither revoked nor in error gets an OK finding recording where its status comes from.
+func (c *RevocationChecker) Check(result Result, now time.Time) []Finding {
+	findings := []Finding{}
+	for depth := 0; depth < len(result.Chain)-1; depth++ {
+		certificate, issuer := result.Chain[depth], result.Chain[depth+1]
+		if !issuedBy(certificate, issuer) {
+			continue
+		}
+		var staple []byte
+		if depth == 0 {
+			staple = result.OCSPStaple
+		}
+		status, problems, errs := c.status(certificate, issuer, staple, now)
+		for _, problem := range problems {
+			problem.Check, problem.Depth = revocationCheck, depth
+			findings = append(findings, problem)
+		}
+		switch {
+		case status == nil:
+			// certificates without any revocation information are not reported
+			if len(errs) != 0 {
+				findings = append(findings, newFinding(revocationCheck, "revocation-unknown", StatusWarning, depth,
+					"the revocation status of %s could not be determined: %s", certificate.Subject.CommonName, strings.Join(errs, "; ")))
+			}
+		case status.revoked:
+			findings = append(findings, newFinding(revocationCheck, "revoked", StatusCritical, depth,
+				"%s was revoked on %s (%s)", certificate.Subject.CommonName, status.revokedAt.UTC().Format(time.RFC3339), status.source))
+		default:
+			findings = append(findings, newFinding(revocationCheck, "good", StatusOK, depth, "not revoked (%s)", status.source))
+		}
+	}
+	return findings
+}
+
+// returns the revocation status of the certificate, nil if unknown, the problems of the
+// stapled response and the errors met while querying the responders and distribution points
+func (c *RevocationChecker) status(certificate *x509.Certificate, issuer *x509.Certificate, staple []byte, now time.Time) (*revocationStatus, []Finding, []string) {
+	problems := []Finding{}
+	errs := []string{}
+
+	if len(staple) != 0 {
+		response, err := ocsp.ParseResponseForCert(staple, certificate, issuer)
+		switch {
+		case err != nil:
+			problems = append(problems, Finding{ID: "invalid-staple", Status: StatusWarning, Message: "the stapled OCSP response is invalid: " + err.Error()})
+		case !response.NextUpdate.IsZero() && now.After(response.NextUpdate):
+			problems = append(problems, Finding{ID: "stale-staple", Status: StatusWarning,
+				Message: "the stapled OCSP response expired on " + response.NextUpdate.UTC().Format(time.RFC3339)})
+		case response.Status != ocsp.Unknown:
+			return ocspStatus(response, "stapled OCSP"), problems, errs
+		}
+	}
+
+	ocspURLs := certificate.OCSPServer
+	if c.OCSPURL != "" {
+		ocspURLs = []string{c.OCSPURL}
+	}
+	for _, ocspURL := range ocspURLs {
+		response, err := c.queryOCSP(ocspURL, certificate, issuer)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if response.Status != ocsp.Unknown {
+			return ocspStatus(response, "OCSP "+ocspURL), problems, errs
+		}
+		errs = append(errs, "OCSP responder "+ocspURL+" does not know the certificate")
+	}
+
+	crlURLs := certificate.CRLDistributionPoints
+	if c.CRLURL != "" {
+		crlURLs = []string{c.CRLURL}
+	}
+	for _, crlURL := range crlURLs {
+		crl, err := c.getCRL(crlURL, issuer, now)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		status := &revocationStatus{source: "CRL " + crlURL}
+		for _, revoked := range crl.TBSCertList.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(certificate.SerialNumber) == 0 {
+				status.revoked = true
+				status.revokedAt = revoked.RevocationTime
+			}
+		}
+		return status, problems, errs
+	}
+	return nil, problems, errs
+}
+
+// returns the revocation state of the certificate at the given depth recorded in the
+// findings: good, revoked, unknown or empty when it was not checked
+func RevocationState(findings []Finding, depth int) string {
+	state := ""
+	for _, finding := range findings {
+		if finding.Check != revocationCheck || finding.Depth != depth {
+			continue
+		}
+		switch finding.ID {
+		case "good", "revoked":
+			return finding.ID
+		case "revocation-unknown":
+			state = "unknown"
+		}
+	}
+	return state
+}
+
+func ocspStatus(response *ocsp.Response, source string) *revocationStatus {
+	return &revocationStatus{
+		revoked:   response.Status == ocsp.Revoked,
+		revokedAt: response.RevokedAt,
+		source:    source,
+	}
+}
+
+// sends an OCSP request for the certificate to the responder
+func (c *RevocationChecker) queryOCSP(responder string, certificate *x509.Certificate, issuer *x509.Certificate) (*ocsp.Response, error) {
+	request, err := ocsp.CreateRequest(certificate, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Client.Post(responder, "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot query OCSP responder %s", responder)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("OCSP responder %s returned %s", responder, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRevocationDownload))
+	if err != nil {
+		return nil, err
+	}
+	response, err := ocsp.ParseResponseForCert(body, certificate, issuer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid response from OCSP responder %s", responder)
+	}
+	return response, nil
+}
+
+// returns the CRL published at the given URL, from the cache while it is not outdated
+func (c *RevocationChecker) getCRL(crlURL string, issuer *x509.Certificate, now time.Time) (*pkix.CertificateList, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.crls == nil {
+		c.crls = map[string]*pkix.CertificateList{}
+	}
+
+	key := crlURL + "\x00" + string(issuer.RawSubject)
+	if crl, ok := c.crls[key]; ok && !crl.HasExpired(now) {
+		return crl, nil
+	}
+
+	cachePath := ""
+	if c.CacheDir != "" {
+		sum := sha256.Sum256([]byte(key))
+		cachePath = filepath.Join(c.CacheDir, hex.EncodeToString(sum[:])+".crl")
+		if der, err := ioutil.ReadFile(cachePath); err == nil {
+			if crl, err := parseCRL(der, issuer); err == nil && !crl.HasExpired(now) {
+				c.crls[key] = crl
+				return crl, nil
+			}
+		}
+	}
+
+	der, err := c.download(crlURL)
+	if err != nil {
+		return nil, err
+	}
+	crl, err := parseCRL(der, issuer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid CRL %s", crlURL)
+	}
+	c.crls[key] = crl
+	if cachePath != "" {
+		if err := os.MkdirAll(c.CacheDir, 0750); err == nil {
+			ioutil.WriteFile(cachePath, der, 0640)
+		}
+	}
+	return crl, nil
+}
+
+// parses a CRL and checks it was signed by the issuer
+func parseCRL(der []byte, issuer *x509.Certificate) (*pkix.CertificateList, error) {
+	crl, err := x509.ParseCRL(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := issuer.CheckCRLSignature(crl); err != nil {
+		return nil, errors.Wrap(err, "the CRL is not signed by the issuer")
+	}
+	return crl, nil
+}
+
+func (c *RevocationChecker) download(rawURL string) ([]byte, error) {
+	resp, err := c.Client.Get(rawURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot download %s", rawURL)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("GET %s returned %s", rawURL, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxRevocationDownload))
+}
@@ -0,0 +1,134 @@
+package cert
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+// returns an OCSP response signed by the issuer for the certificate
+func newOCSPResponse(t *testing.T, issuer *testCert, certificate *testCert, status int, nextUpdate time.Time) []byte {
+	response, err := ocsp.CreateResponse(issuer.cert, issuer.cert, ocsp.Response{
+		Status:       status,
+		SerialNumber: certificate.cert.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Hour),
+		NextUpdate:   nextUpdate,
+		RevokedAt:    time.Now().Add(-time.Hour),
+	}, issuer.key)
+	require.Nil(t, err)
+	return response
+}
+
+// starts an OCSP responder answering with the given status for every request
+func startOCSPResponder(t *testing.T, issuer *testCert, certificate *testCert, status int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.Nil(t, err)
+		_, err = ocsp.ParseRequest(body)
+		require.Nil(t, err)
+		w.Write(newOCSPResponse(t, issuer, certificate, status, time.Now().Add(time.Hour)))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func Test_RevocationCheckerOCSP(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	leaf := newTestLeaf(t, ca, "www.example.com")
+	result := Result{Chain: []*x509.Certificate{leaf.cert, ca.cert}}
+
+	good := startOCSPResponder(t, ca, leaf, ocsp.Good)
+	findings := (&RevocationChecker{Client: http.DefaultClient, OCSPURL: good.URL}).Check(result, time.Now())
+	require.Equal(t, []string{"good"}, findingIDs(findings))
+	require.Equal(t, StatusOK, WorstStatus(findings))
+	require.Equal(t, "good", RevocationState(findings, 0))
+
+	revoked := startOCSPResponder(t, ca, leaf, ocsp.Revoked)
+	findings = (&RevocationChecker{Client: http.DefaultClient, OCSPURL: revoked.URL}).Check(result, time.Now())
+	require.Equal(t, []string{"revoked"}, findingIDs(findings))
+	require.Equal(t, StatusCritical, WorstStatus(findings))
+	require.Equal(t, "revoked", RevocationState(findings, 0))
+
+	broken := httptest.NewServer(http.NotFoundHandler())
+	defer broken.Close()
+	findings = (&RevocationChecker{Client: http.DefaultClient, OCSPURL: broken.URL}).Check(result, time.Now())
+	require.Equal(t, []string{"revocation-unknown"}, findingIDs(findings))
+	require.Equal(t, StatusWarning, WorstStatus(findings))
+
+	// no revocation information at all
+	require.Empty(t, (&RevocationChecker{Client: http.DefaultClient}).Check(result, time.Now()))
+}
+
+func Test_RevocationCheckerCRL(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	leaf := newTestLeaf(t, ca, "www.example.com")
+	other := newTestLeaf(t, ca, "mail.example.com")
+
+	crl, err := ca.cert.CreateCRL(rand.Reader, ca.key, []pkix.RevokedCertificate{
+		{SerialNumber: leaf.cert.SerialNumber, RevocationTime: time.Now().Add(-time.Hour)},
+	}, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	require.Nil(t, err)
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		w.Write(crl)
+	}))
+	defer server.Close()
+
+	cacheDir := tempDir(t)
+	checker := &RevocationChecker{Client: http.DefaultClient, CRLURL: server.URL + "/ca.crl", CacheDir: cacheDir}
+	findings := checker.Check(Result{Chain: []*x509.Certificate{leaf.cert, ca.cert}}, time.Now())
+	require.Equal(t, []string{"revoked"}, findingIDs(findings))
+	findings = checker.Check(Result{Chain: []*x509.Certificate{other.cert, ca.cert}}, time.Now())
+	require.Equal(t, []string{"good"}, findingIDs(findings))
+	require.Equal(t, 1, downloads)
+
+	// a new checker reuses the CRL cached on disk
+	checker = &RevocationChecker{Client: http.DefaultClient, CRLURL: server.URL + "/ca.crl", CacheDir: cacheDir}
+	findings = checker.Check(Result{Chain: []*x509.Certificate{leaf.cert, ca.cert}}, time.Now())
+	require.Equal(t, []string{"revoked"}, findingIDs(findings))
+	require.Equal(t, 1, downloads)
+
+	// the CRL of another issuer is rejected
+	otherCA := newTestCA(t, "Sentinel Other CA")
+	otherLeaf := newTestLeaf(t, otherCA, "www.example.com")
+	findings = checker.Check(Result{Chain: []*x509.Certificate{otherLeaf.cert, otherCA.cert}}, time.Now())
+	require.Equal(t, []string{"revocation-unknown"}, findingIDs(findings))
+}
+
+func Test_RevocationCheckerStaple(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	leaf := newTestLeaf(t, ca, "www.example.com")
+	certificate := tlsCertificate(leaf, ca)
+	certificate.OCSPStaple = newOCSPResponse(t, ca, leaf, ocsp.Revoked, time.Now().Add(time.Hour))
+
+	host, port := startTLSServer(t, &tls.Config{Certificates: []tls.Certificate{certificate}})
+	conf, err := NewScanConfig([]int{port}, 1, 2)
+	require.Nil(t, err)
+	result := NewScanner(conf).Probe(context.Background(), Target{Host: host, Port: port})
+	require.Nil(t, result.Err)
+	require.NotEmpty(t, result.OCSPStaple)
+
+	checker := &RevocationChecker{Client: http.DefaultClient}
+	findings := checker.Check(result, time.Now())
+	require.Equal(t, []string{"revoked"}, findingIDs(findings))
+	require.Contains(t, findings[0].Message, "stapled OCSP")
+
+	// an outdated staple falls back to the responder
+	result.OCSPStaple = newOCSPResponse(t, ca, leaf, ocsp.Revoked, time.Now().Add(-time.Minute))
+	checker.OCSPURL = startOCSPResponder(t, ca, leaf, ocsp.Good).URL
+	require.Equal(t, []string{"stale-staple", "good"}, findingIDs(checker.Check(result, time.Now())))
+
+	result.OCSPStaple = []byte("garbage")
+	require.Equal(t, []string{"invalid-staple", "good"}, findingIDs(checker.Check(result, time.Now())))
+}
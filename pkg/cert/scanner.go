@@ -0,0 +1,273 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultWorkers = 64
+	maxWorkers     = 4096
+	defaultTimeout = 5
+
+	// wait for the rejection of the client certificate once a TLS 1.3 handshake completed
+	clientAuthTimeout = time.Second
+)
+
+// default ports probed when none is configured
+var DefaultPorts = []int{443}
+
+type ScanConfig struct {
+	Ports   []int
+	Workers int
+	Timeout time.Duration
+	// STARTTLS protocol negotiated before the handshake, by port
+	StartTLS map[int]string
+	// targets never probed
+	Exclusions *Exclusions
+	// paces the connections, shared with the port scanner
+	Limiter *RateLimiter
+	// client certificates presented to the endpoints requesting one
+	ClientCertificates ClientCertificates
+}
+
+// creates a scan configuration, the timeout is given in seconds
+func NewScanConfig(ports []int, workers int, timeout int) (ScanConfig, error) {
+	if len(ports) == 0 {
+		ports = DefaultPorts
+	}
+	for _, port := range ports {
+		if port <= 0 || port > 65535 {
+			return ScanConfig{}, errors.Errorf("invalid port %d", port)
+		}
+	}
+
+	// set default values
+	if workers <= 0 || workers > maxWorkers {
+		workers = defaultWorkers
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	startTLS, err := StartTLSPorts(nil)
+	if err != nil {
+		return ScanConfig{}, err
+	}
+
+	return ScanConfig{
+		Ports:    ports,
+		Workers:  workers,
+		Timeout:  time.Duration(timeout) * time.Second,
+		StartTLS: startTLS,
+	}, nil
+}
+
+// an endpoint to probe, ServerName is sent as SNI when not empty
+type Target struct {
+	Host       string
+	Port       int
+	ServerName string
+}
+
+// returns the host:port address of the target
+func (t Target) Address() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+// outcome of a single TLS probe
+type Result struct {
+	Target
+	// Reachable is true when the TCP connection succeeded
+	Reachable bool
+	// Fallback is true when the endpoint answered the server name with its default
+	// certificate, which does not cover it
+	Fallback bool
+	// STARTTLS protocol negotiated before the handshake, empty for implicit TLS
+	Protocol string
+	Chain    []*x509.Certificate
+	// OCSP response stapled by the server during the handshake
+	OCSPStaple  []byte
+	Version     uint16
+	CipherSuite uint16
+	ScannedAt   time.Time
+	// client authentication requested by the endpoint, see ClientAuthRequested
+	ClientAuth string
+	// distinguished names of the CAs advertised in the CertificateRequest
+	ClientCAs []string
+	// true when a configured client certificate was presented
+	ClientCertPresented bool
+	Err                 error
+	// problems detected by the evaluations of the endpoint
+	Findings []Finding
+}
+
+// returns the leaf certificate or nil if the handshake failed
+func (r Result) Leaf() *x509.Certificate {
+	if len(r.Chain) == 0 {
+		return nil
+	}
+	return r.Chain[0]
+}
+
+type Scanner struct {
+	conf ScanConfig
+}
+
+func NewScanner(conf ScanConfig) *Scanner {
+	return &Scanner{conf: conf}
+}
+
+// probes all the targets with a bounded pool of workers.
+// The returned channel is closed once every target has been handled.
+func (s *Scanner) Run(ctx context.Context, targets <-chan Target) <-chan Result {
+	results := make(chan Result)
+	wg := &sync.WaitGroup{}
+	wg.Add(s.conf.Workers)
+	for i := 0; i < s.conf.Workers; i++ {
+		go func() {
+			defer wg.Done()
+			for target := range targets {
+				result := s.Probe(ctx, target)
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// connects to the target, completes a TLS handshake and collects the peer certificates
+func (s *Scanner) Probe(ctx context.Context, target Target) Result {
+	result := Result{Target: target, ScannedAt: time.Now()}
+	if s.conf.Exclusions.Excludes(target) {
+		result.Err = errors.Errorf("%s is excluded from the scans", target.Address())
+		return result
+	}
+	if err := s.conf.Limiter.Wait(ctx, target.Host); err != nil {
+		result.Err = errors.Wrapf(err, "cannot connect to %s", target.Address())
+		return result
+	}
+	result.ScannedAt = time.Now()
+
+	dialer := &net.Dialer{Timeout: s.conf.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", target.Address())
+	if err != nil {
+		result.Err = errors.Wrapf(err, "cannot connect to %s", target.Address())
+		return result
+	}
+	defer conn.Close()
+	result.Reachable = true
+
+	conn.SetDeadline(time.Now().Add(s.conf.Timeout))
+	if protocol, ok := s.conf.StartTLS[target.Port]; ok {
+		negotiator, err := GetNegotiator(protocol)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		if err := negotiator.Negotiate(conn, target.ServerName); err != nil {
+			result.Err = errors.Wrapf(err, "%s STARTTLS negotiation with %s failed", protocol, target.Address())
+			return result
+		}
+		result.Protocol = protocol
+	}
+
+	clientCert := s.conf.ClientCertificates.Select(target)
+	var rawChain [][]byte
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName: target.ServerName,
+		// the chain is only collected here, its validation happens later
+		InsecureSkipVerify: true,
+		// deprecated protocols and weak suites are accepted to be reported
+		MinVersion:   tls.VersionTLS10,
+		CipherSuites: allCipherSuites(),
+		// the chain is known before the server verifies the client certificate
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			rawChain = rawCerts
+			return nil
+		},
+		GetClientCertificate: func(request *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			result.ClientAuth = ClientAuthRequested
+			result.ClientCAs = acceptableCANames(request.AcceptableCAs)
+			if clientCert == nil {
+				return &tls.Certificate{}, nil
+			}
+			result.ClientCertPresented = true
+			return clientCert, nil
+		},
+	})
+	err = tlsConn.Handshake()
+	if err == nil && result.ClientAuth != "" && tlsConn.ConnectionState().Version == tls.VersionTLS13 {
+		// TLS 1.3 servers reject the client certificate after the client completed the handshake
+		wait := clientAuthTimeout
+		if s.conf.Timeout < wait {
+			wait = s.conf.Timeout
+		}
+		tlsConn.SetReadDeadline(time.Now().Add(wait))
+		if _, readErr := tlsConn.Read(make([]byte, 1)); isRemoteAlert(readErr) {
+			err = readErr
+		}
+	}
+	if err != nil && (result.ClientAuth == "" || len(rawChain) == 0) {
+		result.Err = errors.Wrapf(err, "TLS handshake with %s failed", target.Address())
+		return result
+	}
+
+	state := tlsConn.ConnectionState()
+	result.Version = state.Version
+	result.CipherSuite = state.CipherSuite
+	if err != nil {
+		// the server certificates are reported despite the failed client authentication
+		result.ClientAuth = ClientAuthRequired
+		if result.ClientCertPresented {
+			result.ClientAuth = ClientAuthRejected
+		}
+		for _, der := range rawChain {
+			c, err := x509.ParseCertificate(der)
+			if err != nil {
+				result.Err = errors.Wrapf(err, "invalid certificate presented by %s", target.Address())
+				return result
+			}
+			result.Chain = append(result.Chain, c)
+		}
+		return result
+	}
+	result.Chain = state.PeerCertificates
+	result.OCSPStaple = state.OCSPResponse
+	return result
+}
+
+// returns true if the probe failed because the endpoint answered something else than
+// TLS, e.g. a plain HTTP or SSH server found by the port discovery
+func IsNotTLS(err error) bool {
+	_, ok := errors.Cause(err).(tls.RecordHeaderError)
+	return ok
+}
+
+// returns every cipher suite implemented, the insecure ones last so that they are
+// only negotiated with endpoints supporting nothing better
+func allCipherSuites() []uint16 {
+	suites := []uint16{}
+	for _, suite := range tls.CipherSuites() {
+		suites = append(suites, suite.ID)
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		suites = append(suites, suite.ID)
+	}
+	return suites
+}
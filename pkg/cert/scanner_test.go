@@ -0,0 +1,71 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewScanConfig(t *testing.T) {
+	conf, err := NewScanConfig(nil, 0, 0)
+	require.Nil(t, err)
+	require.Equal(t, DefaultPorts, conf.Ports)
+	require.Equal(t, defaultWorkers, conf.Workers)
+	require.Equal(t, defaultTimeout*time.Second, conf.Timeout)
+
+	_, err = NewScanConfig([]int{443, 70000}, 10, 1)
+	require.NotNil(t, err)
+}
+
+func Test_Probe(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	leaf := newTestLeaf(t, ca, "127.0.0.1")
+	host, port := startTLSServer(t, &tls.Config{Certificates: []tls.Certificate{tlsCertificate(leaf, ca)}})
+
+	conf, err := NewScanConfig([]int{port}, 1, 2)
+	require.Nil(t, err)
+	result := NewScanner(conf).Probe(context.Background(), Target{Host: host, Port: port})
+	require.Nil(t, result.Err)
+	require.True(t, result.Reachable)
+	require.Len(t, result.Chain, 2)
+	require.Equal(t, leaf.cert.Raw, result.Leaf().Raw)
+	require.Equal(t, ca.cert.Raw, result.Chain[1].Raw)
+}
+
+func Test_ProbeClosedPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	conf, err := NewScanConfig([]int{port}, 1, 1)
+	require.Nil(t, err)
+	result := NewScanner(conf).Probe(context.Background(), Target{Host: "127.0.0.1", Port: port})
+	require.NotNil(t, result.Err)
+	require.False(t, result.Reachable)
+	require.Nil(t, result.Leaf())
+}
+
+func Test_RunSubnet(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	leaf := newTestLeaf(t, ca, "127.0.0.1")
+	_, port := startTLSServer(t, &tls.Config{Certificates: []tls.Certificate{tlsCertificate(leaf)}})
+
+	subnets, err := ParseSubnets([]string{"127.0.0.1"})
+	require.Nil(t, err)
+	conf, err := NewScanConfig([]int{port}, 4, 2)
+	require.Nil(t, err)
+
+	ctx := context.Background()
+	results := []Result{}
+	for result := range NewScanner(conf).Run(ctx, SubnetTargets(ctx, subnets, conf.Ports)) {
+		results = append(results, result)
+	}
+	require.Len(t, results, 1)
+	require.Nil(t, results[0].Err)
+	require.Equal(t, "127.0.0.1", results[0].Host)
+}
@@ -0,0 +1,301 @@
+package cert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	sctCheck = "sct"
+
+	// SCTs required by default, the minimum of the CT policies of the browsers
+	defaultMinSCTs = 2
+)
+
+var oidExtensionSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// a Certificate Transparency log trusted to sign the SCTs
+type CTLog struct {
+	Name string
+	// SHA-256 hash of the public key of the log
+	ID  [32]byte
+	Key crypto.PublicKey
+}
+
+// a Signed Certificate Timestamp (RFC 6962)
+type SignedCertificateTimestamp struct {
+	Version            uint8
+	LogID              [32]byte
+	Timestamp          uint64
+	Extensions         []byte
+	HashAlgorithm      uint8
+	SignatureAlgorithm uint8
+	Signature          []byte
+}
+
+// returns the time of the timestamp, given in milliseconds
+func (s SignedCertificateTimestamp) Time() time.Time {
+	return time.Unix(0, int64(s.Timestamp)*int64(time.Millisecond))
+}
+
+// parses the base64 DER public key of a CT log, as published in the log lists
+func ParseCTLog(name string, key string) (*CTLog, error) {
+	der, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid key of CT log %s", name)
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid key of CT log %s", name)
+	}
+	return &CTLog{Name: name, ID: sha256.Sum256(der), Key: publicKey}, nil
+}
+
+// returns the SCTs embedded in the certificate, none if it has no SCT list extension
+func EmbeddedSCTs(c *x509.Certificate) ([]SignedCertificateTimestamp, error) {
+	var list []byte
+	for _, extension := range c.Extensions {
+		if extension.Id.Equal(oidExtensionSCTList) {
+			if _, err := asn1.Unmarshal(extension.Value, &list); err != nil {
+				return nil, errors.Wrap(err, "invalid SCT list extension")
+			}
+		}
+	}
+	if list == nil {
+		return nil, nil
+	}
+
+	list, err := readUint16Prefixed(list)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid SCT list")
+	}
+	scts := []SignedCertificateTimestamp{}
+	for len(list) != 0 {
+		var serialized []byte
+		serialized, list, err = splitUint16Prefixed(list)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid SCT list")
+		}
+		sct, err := parseSCT(serialized)
+		if err != nil {
+			return nil, err
+		}
+		scts = append(scts, sct)
+	}
+	return scts, nil
+}
+
+// parses a serialized SCT of version 1
+func parseSCT(data []byte) (SignedCertificateTimestamp, error) {
+	sct := SignedCertificateTimestamp{}
+	if len(data) < 1+32+8 {
+		return sct, errors.New("truncated SCT")
+	}
+	sct.Version = data[0]
+	if sct.Version != 0 {
+		return sct, errors.Errorf("unsupported SCT version %d", sct.Version+1)
+	}
+	copy(sct.LogID[:], data[1:33])
+	sct.Timestamp = binary.BigEndian.Uint64(data[33:41])
+	extensions, rest, err := splitUint16Prefixed(data[41:])
+	if err != nil || len(rest) < 2 {
+		return sct, errors.New("truncated SCT")
+	}
+	sct.Extensions = extensions
+	sct.HashAlgorithm, sct.SignatureAlgorithm = rest[0], rest[1]
+	signature, rest, err := splitUint16Prefixed(rest[2:])
+	if err != nil || len(rest) != 0 {
+		return sct, errors.New("truncated SCT signature")
+	}
+	sct.Signature = signature
+	return sct, nil
+}
+
+// returns the TBSCertificate of the precertificate the SCTs were issued for, the one
+// of the certificate without the SCT list extension
+func precertTBS(c *x509.Certificate) ([]byte, error) {
+	tbs := asn1.RawValue{}
+	if _, err := asn1.Unmarshal(c.RawTBSCertificate, &tbs); err != nil {
+		return nil, err
+	}
+	body := []byte{}
+	for rest := tbs.Bytes; len(rest) != 0; {
+		field := asn1.RawValue{}
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+			return nil, err
+		}
+		// the extensions are the explicitly tagged [3] field
+		if field.Class == asn1.ClassContextSpecific && field.Tag == 3 {
+			extensions := []asn1.RawValue{}
+			if _, err := asn1.Unmarshal(field.Bytes, &extensions); err != nil {
+				return nil, err
+			}
+			kept := []byte{}
+			for _, extension := range extensions {
+				id := asn1.ObjectIdentifier{}
+				if _, err := asn1.Unmarshal(extension.Bytes, &id); err != nil {
+					return nil, err
+				}
+				if !id.Equal(oidExtensionSCTList) {
+					kept = append(kept, extension.FullBytes...)
+				}
+			}
+			sequence, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: kept})
+			if err != nil {
+				return nil, err
+			}
+			if field.FullBytes, err = asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 3, IsCompound: true, Bytes: sequence}); err != nil {
+				return nil, err
+			}
+		}
+		body = append(body, field.FullBytes...)
+	}
+	return asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: body})
+}
+
+// verifies the signature of an SCT embedded in the certificate issued by issuer
+func (l *CTLog) Verify(sct SignedCertificateTimestamp, c *x509.Certificate, issuer *x509.Certificate) error {
+	tbs, err := precertTBS(c)
+	if err != nil {
+		return errors.Wrap(err, "cannot rebuild the precertificate")
+	}
+
+	// only SHA-256 is allowed by RFC 6962
+	if sct.HashAlgorithm != 4 {
+		return errors.Errorf("unsupported SCT hash algorithm %d", sct.HashAlgorithm)
+	}
+	digest := sha256.Sum256(sctSignedData(sct, tbs, issuer))
+	switch key := l.Key.(type) {
+	case *ecdsa.PublicKey:
+		signature := struct{ R, S *big.Int }{}
+		if _, err := asn1.Unmarshal(sct.Signature, &signature); err != nil {
+			return errors.Wrap(err, "invalid ECDSA signature")
+		}
+		if !ecdsa.Verify(key, digest[:], signature.R, signature.S) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sct.Signature)
+	}
+	return errors.Errorf("unsupported key type of CT log %s", l.Name)
+}
+
+// returns the digitally-signed struct of the SCT of a precert_entry
+func sctSignedData(sct SignedCertificateTimestamp, tbs []byte, issuer *x509.Certificate) []byte {
+	signed := &bytes.Buffer{}
+	signed.WriteByte(sct.Version)
+	signed.WriteByte(0) // certificate_timestamp
+	binary.Write(signed, binary.BigEndian, sct.Timestamp)
+	binary.Write(signed, binary.BigEndian, uint16(1)) // precert_entry
+	issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	signed.Write(issuerKeyHash[:])
+	signed.Write([]byte{byte(len(tbs) >> 16), byte(len(tbs) >> 8), byte(len(tbs))})
+	signed.Write(tbs)
+	binary.Write(signed, binary.BigEndian, uint16(len(sct.Extensions)))
+	signed.Write(sct.Extensions)
+	return signed.Bytes()
+}
+
+// checks that the leaves carry enough embedded SCTs signed by the known CT logs
+type SCTChecker struct {
+	// no SCT is counted as valid when there is no log to verify it against
+	Logs    map[[32]byte]*CTLog
+	MinSCTs int
+}
+
+// creates an SCT checker trusting the given logs, at least minSCTs valid SCTs are required
+func NewSCTChecker(logs []*CTLog, minSCTs int) *SCTChecker {
+	if minSCTs <= 0 {
+		minSCTs = defaultMinSCTs
+	}
+	checker := &SCTChecker{Logs: map[[32]byte]*CTLog{}, MinSCTs: minSCTs}
+	for _, log := range logs {
+		checker.Logs[log.ID] = log
+	}
+	return checker
+}
+
+func (c *SCTChecker) Check(result Result, now time.Time) []Finding {
+	leaf := result.Leaf()
+	if leaf == nil {
+		return nil
+	}
+	scts, err := EmbeddedSCTs(leaf)
+	if err != nil {
+		return []Finding{newFinding(sctCheck, "sct-invalid", StatusCritical, 0, "%s", err)}
+	}
+	if len(scts) == 0 {
+		return []Finding{newFinding(sctCheck, "sct-missing", StatusWarning, 0, "the certificate has no embedded SCT")}
+	}
+	if len(c.Logs) == 0 {
+		return []Finding{newFinding(sctCheck, "sct-unverified", StatusWarning, 0,
+			"the %d embedded SCTs cannot be verified without a configured CT log", len(scts))}
+	}
+
+	findings := []Finding{}
+	valid := 0
+	for _, sct := range scts {
+		logID := base64.StdEncoding.EncodeToString(sct.LogID[:])
+		if sct.Time().After(now) {
+			findings = append(findings, newFinding(sctCheck, "sct-invalid", StatusCritical, 0,
+				"the SCT of log %s is dated in the future (%s)", logID, sct.Time().UTC().Format(time.RFC3339)))
+			continue
+		}
+		log, ok := c.Logs[sct.LogID]
+		if !ok {
+			findings = append(findings, newFinding(sctCheck, "sct-unknown-log", StatusWarning, 0, "the SCT is signed by the unknown log %s", logID))
+			continue
+		}
+		if len(result.Chain) < 2 {
+			findings = append(findings, newFinding(sctCheck, "sct-unverified", StatusWarning, 0,
+				"the SCT of log %s cannot be verified without the issuer", log.Name))
+			continue
+		}
+		if err := log.Verify(sct, leaf, result.Chain[1]); err != nil {
+			findings = append(findings, newFinding(sctCheck, "sct-invalid", StatusCritical, 0, "the SCT of log %s is invalid: %s", log.Name, err))
+			continue
+		}
+		valid++
+	}
+	if valid < c.MinSCTs {
+		findings = append(findings, newFinding(sctCheck, "sct-insufficient", StatusWarning, 0,
+			"the certificate carries %d valid SCTs, %d are required", valid, c.MinSCTs))
+	} else {
+		findings = append(findings, newFinding(sctCheck, "sct-valid", StatusOK, 0, "the certificate carries %d valid SCTs", valid))
+	}
+	return findings
+}
+
+// returns the content of a TLS vector with a 2-byte length, the data must end with it
+func readUint16Prefixed(data []byte) ([]byte, error) {
+	content, rest, err := splitUint16Prefixed(data)
+	if err == nil && len(rest) != 0 {
+		err = errors.New("trailing data")
+	}
+	return content, err
+}
+
+// returns the content of a TLS vector with a 2-byte length and the data following it
+func splitUint16Prefixed(data []byte) ([]byte, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, errors.New("truncated data")
+	}
+	length := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+length {
+		return nil, nil, errors.New("truncated data")
+	}
+	return data[2 : 2+length], data[2+length:], nil
+}
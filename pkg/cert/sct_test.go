@@ -0,0 +1,119 @@
+package cert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// returns a CT log with a new key
+func newTestCTLog(t *testing.T, name string) (*CTLog, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.Nil(t, err)
+	log, err := ParseCTLog(name, base64.StdEncoding.EncodeToString(der))
+	require.Nil(t, err)
+	return log, key
+}
+
+// issues a leaf embedding an SCT of every log, dated at the given time
+func newTestLeafWithSCTs(t *testing.T, ca *testCert, timestamp time.Time, logs map[*CTLog]crypto.Signer) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{Subject: pkix.Name{CommonName: "www.example.com"}, DNSNames: []string{"www.example.com"}}
+	precert := newTestCertWithKey(t, template, ca, key)
+
+	list := &bytes.Buffer{}
+	for log, logKey := range logs {
+		sct := SignedCertificateTimestamp{LogID: log.ID, Timestamp: uint64(timestamp.UnixNano() / int64(time.Millisecond)), HashAlgorithm: 4, SignatureAlgorithm: 3}
+		digest := sha256.Sum256(sctSignedData(sct, precert.cert.RawTBSCertificate, ca.cert))
+		signature, err := logKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+		require.Nil(t, err)
+
+		serialized := &bytes.Buffer{}
+		serialized.WriteByte(sct.Version)
+		serialized.Write(sct.LogID[:])
+		binary.Write(serialized, binary.BigEndian, sct.Timestamp)
+		binary.Write(serialized, binary.BigEndian, uint16(0))
+		serialized.Write([]byte{sct.HashAlgorithm, sct.SignatureAlgorithm})
+		binary.Write(serialized, binary.BigEndian, uint16(len(signature)))
+		serialized.Write(signature)
+		binary.Write(list, binary.BigEndian, uint16(serialized.Len()))
+		list.Write(serialized.Bytes())
+	}
+	value, err := asn1.Marshal(append([]byte{byte(list.Len() >> 8), byte(list.Len())}, list.Bytes()...))
+	require.Nil(t, err)
+
+	// same serial, validity and key as the precertificate
+	template.ExtraExtensions = []pkix.Extension{{Id: oidExtensionSCTList, Value: value}}
+	return newTestCertWithKey(t, template, ca, key)
+}
+
+func Test_EmbeddedSCTs(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	log, logKey := newTestCTLog(t, "test log")
+	now := time.Now().Truncate(time.Millisecond)
+	leaf := newTestLeafWithSCTs(t, ca, now, map[*CTLog]crypto.Signer{log: logKey})
+
+	scts, err := EmbeddedSCTs(leaf.cert)
+	require.Nil(t, err)
+	require.Len(t, scts, 1)
+	require.Equal(t, log.ID, scts[0].LogID)
+	require.True(t, now.Equal(scts[0].Time()))
+	require.Nil(t, log.Verify(scts[0], leaf.cert, ca.cert))
+
+	// signed for another issuer
+	require.NotNil(t, log.Verify(scts[0], leaf.cert, newTestCA(t, "Other CA").cert))
+
+	scts, err = EmbeddedSCTs(newTestLeaf(t, ca, "www.example.com").cert)
+	require.Nil(t, err)
+	require.Empty(t, scts)
+}
+
+func Test_SCTChecker(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	first, firstKey := newTestCTLog(t, "first")
+	second, secondKey := newTestCTLog(t, "second")
+	unknown, unknownKey := newTestCTLog(t, "unknown")
+	now := time.Now()
+	checker := NewSCTChecker([]*CTLog{first, second}, 0)
+	require.Equal(t, defaultMinSCTs, checker.MinSCTs)
+
+	leaf := newTestLeafWithSCTs(t, ca, now.Add(-time.Hour), map[*CTLog]crypto.Signer{first: firstKey, second: secondKey})
+	findings := checker.Check(Result{Chain: []*x509.Certificate{leaf.cert, ca.cert}}, now)
+	require.Equal(t, []string{"sct-valid"}, findingIDs(findings))
+
+	leaf = newTestLeafWithSCTs(t, ca, now.Add(-time.Hour), map[*CTLog]crypto.Signer{first: firstKey, unknown: unknownKey})
+	findings = checker.Check(Result{Chain: []*x509.Certificate{leaf.cert, ca.cert}}, now)
+	require.ElementsMatch(t, []string{"sct-unknown-log", "sct-insufficient"}, findingIDs(findings))
+
+	// no SCT is counted when no log is configured
+	findings = NewSCTChecker(nil, 0).Check(Result{Chain: []*x509.Certificate{leaf.cert, ca.cert}}, now)
+	require.Equal(t, []string{"sct-unverified"}, findingIDs(findings))
+
+	leaf = newTestLeafWithSCTs(t, ca, now.Add(time.Hour), map[*CTLog]crypto.Signer{first: firstKey})
+	findings = checker.Check(Result{Chain: []*x509.Certificate{leaf.cert, ca.cert}}, now)
+	require.Equal(t, []string{"sct-invalid", "sct-insufficient"}, findingIDs(findings))
+	require.Equal(t, StatusCritical, findings[0].Status)
+
+	// the SCT was not signed by the log it claims
+	leaf = newTestLeafWithSCTs(t, ca, now.Add(-time.Hour), map[*CTLog]crypto.Signer{first: secondKey})
+	findings = checker.Check(Result{Chain: []*x509.Certificate{leaf.cert, ca.cert}}, now)
+	require.Equal(t, []string{"sct-invalid", "sct-insufficient"}, findingIDs(findings))
+
+	findings = checker.Check(Result{Chain: []*x509.Certificate{newTestLeaf(t, ca, "www.example.com").cert, ca.cert}}, now)
+	require.Equal(t, []string{"sct-missing"}, findingIDs(findings))
+}
@@ -0,0 +1,250 @@
+package cert
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var snapshotsBucket = []byte("snapshots")
+
+// types of the changes detected between two snapshots
+const (
+	EventNewCertificate = "new-certificate"
+	EventRotated        = "rotated"
+	EventIssuerChanged  = "issuer-changed"
+	EventDisappeared    = "disappeared"
+	EventTLSStopped     = "tls-stopped"
+)
+
+// the endpoints answering TLS during a scan and the chains they presented
+type Snapshot struct {
+	ID uint64 `json:"id"`
+	// what was scanned, only the snapshots of the same scope are compared
+	Scope     string             `json:"scope"`
+	Time      time.Time          `json:"time"`
+	Endpoints []SnapshotEndpoint `json:"endpoints"`
+}
+
+// an endpoint of a snapshot and the chain it presented
+type SnapshotEndpoint struct {
+	Host       string `json:"host"`
+	Port       int    `json:"port"`
+	ServerName string `json:"serverName,omitempty"`
+	// fingerprints of the presented chain, the leaf first
+	Chain   []string `json:"chain"`
+	Subject string   `json:"subject"`
+	Issuer  string   `json:"issuer"`
+}
+
+// a change detected on an endpoint between two snapshots
+type ChangeEvent struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Host       string    `json:"host"`
+	Port       int       `json:"port"`
+	ServerName string    `json:"serverName,omitempty"`
+	// leaf presented before and after the change
+	PreviousFingerprint string `json:"previousFingerprint,omitempty"`
+	Fingerprint         string `json:"fingerprint,omitempty"`
+	PreviousIssuer      string `json:"previousIssuer,omitempty"`
+	Issuer              string `json:"issuer,omitempty"`
+	Message             string `json:"message"`
+}
+
+// returns the snapshot of the results of a scan
+func NewSnapshot(scope string, results []Result, now time.Time) *Snapshot {
+	snapshot := &Snapshot{Scope: scope, Time: now, Endpoints: []SnapshotEndpoint{}}
+	for _, result := range results {
+		leaf := result.Leaf()
+		if leaf == nil {
+			continue
+		}
+		endpoint := SnapshotEndpoint{
+			Host:       result.Host,
+			Port:       result.Port,
+			ServerName: result.ServerName,
+			Subject:    leaf.Subject.String(),
+			Issuer:     leaf.Issuer.String(),
+		}
+		for _, c := range result.Chain {
+			endpoint.Chain = append(endpoint.Chain, Fingerprint(c))
+		}
+		snapshot.Endpoints = append(snapshot.Endpoints, endpoint)
+	}
+	return snapshot
+}
+
+func (e SnapshotEndpoint) key() string {
+	return fmt.Sprintf("%s\x00%d\x00%s", e.Host, e.Port, e.ServerName)
+}
+
+func (e SnapshotEndpoint) leaf() string {
+	if len(e.Chain) == 0 {
+		return ""
+	}
+	return e.Chain[0]
+}
+
+// returns the changes between the previous and the current snapshots, sorted by endpoint
+func DiffSnapshots(previous *Snapshot, current *Snapshot) []ChangeEvent {
+	events := []ChangeEvent{}
+	newEvent := func(eventType string, endpoint SnapshotEndpoint, format string, args ...interface{}) ChangeEvent {
+		return ChangeEvent{
+			Type:       eventType,
+			Time:       current.Time,
+			Host:       endpoint.Host,
+			Port:       endpoint.Port,
+			ServerName: endpoint.ServerName,
+			Message:    fmt.Sprintf(format, args...),
+		}
+	}
+
+	before := map[string]SnapshotEndpoint{}
+	seenBefore := map[string]bool{}
+	for _, endpoint := range previous.Endpoints {
+		before[endpoint.key()] = endpoint
+		seenBefore[endpoint.leaf()] = true
+	}
+	after := map[string]SnapshotEndpoint{}
+	seenAfter := map[string]bool{}
+	for _, endpoint := range current.Endpoints {
+		after[endpoint.key()] = endpoint
+		seenAfter[endpoint.leaf()] = true
+	}
+
+	for _, endpoint := range current.Endpoints {
+		old, existed := before[endpoint.key()]
+		if !seenBefore[endpoint.leaf()] {
+			event := newEvent(EventNewCertificate, endpoint, "new certificate %s presented", endpoint.Subject)
+			event.Fingerprint, event.Issuer = endpoint.leaf(), endpoint.Issuer
+			events = append(events, event)
+		}
+		if !existed || old.leaf() == endpoint.leaf() {
+			continue
+		}
+		event := newEvent(EventRotated, endpoint, "certificate rotated from %.16s to %.16s", old.leaf(), endpoint.leaf())
+		event.PreviousFingerprint, event.Fingerprint = old.leaf(), endpoint.leaf()
+		event.PreviousIssuer, event.Issuer = old.Issuer, endpoint.Issuer
+		events = append(events, event)
+		if old.Issuer != endpoint.Issuer {
+			event.Type = EventIssuerChanged
+			event.Message = fmt.Sprintf("issuer changed from %s to %s", old.Issuer, endpoint.Issuer)
+			events = append(events, event)
+		}
+	}
+
+	for _, endpoint := range previous.Endpoints {
+		if _, ok := after[endpoint.key()]; !ok {
+			event := newEvent(EventTLSStopped, endpoint, "the endpoint no longer answers TLS")
+			event.PreviousFingerprint, event.PreviousIssuer = endpoint.leaf(), endpoint.Issuer
+			events = append(events, event)
+		}
+		if !seenAfter[endpoint.leaf()] {
+			event := newEvent(EventDisappeared, endpoint, "certificate %s is no longer presented", endpoint.Subject)
+			event.PreviousFingerprint, event.PreviousIssuer = endpoint.leaf(), endpoint.Issuer
+			events = append(events, event)
+		}
+	}
+
+	sort.SliceStable(events, func(i int, j int) bool {
+		if events[i].Host != events[j].Host {
+			return events[i].Host < events[j].Host
+		}
+		if events[i].Port != events[j].Port {
+			return events[i].Port < events[j].Port
+		}
+		return events[i].ServerName < events[j].ServerName
+	})
+	return events
+}
+
+func snapshotKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// stores the snapshot with a new identifier and only keeps the last snapshots of its
+// scope, all of them when keep is not positive
+func (i *Inventory) SaveSnapshot(snapshot *Snapshot, keep int) error {
+	return i.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(snapshotsBucket)
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		snapshot.ID = id
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(snapshotKey(id), data); err != nil {
+			return err
+		}
+		if keep <= 0 {
+			return nil
+		}
+
+		// walks the snapshots from the newest and drops the older ones of the scope
+		kept := 0
+		stale := [][]byte{}
+		cursor := bucket.Cursor()
+		for key, data := cursor.Last(); key != nil; key, data = cursor.Prev() {
+			other := &Snapshot{}
+			if err := json.Unmarshal(data, other); err != nil {
+				return errors.Wrapf(err, "corrupted snapshot %d", binary.BigEndian.Uint64(key))
+			}
+			if other.Scope != snapshot.Scope {
+				continue
+			}
+			kept++
+			if kept > keep {
+				stale = append(stale, append([]byte{}, key...))
+			}
+		}
+		for _, key := range stale {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// returns the snapshot with the given identifier
+func (i *Inventory) Snapshot(id uint64) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	err := i.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(snapshotsBucket).Get(snapshotKey(id))
+		if data == nil {
+			return errors.Errorf("no snapshot %d", id)
+		}
+		return json.Unmarshal(data, snapshot)
+	})
+	return snapshot, err
+}
+
+// returns the snapshots of the scope from the oldest to the newest, all of them when
+// the scope is empty
+func (i *Inventory) Snapshots(scope string) ([]*Snapshot, error) {
+	snapshots := []*Snapshot{}
+	err := i.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(snapshotsBucket).ForEach(func(key []byte, data []byte) error {
+			snapshot := &Snapshot{}
+			if err := json.Unmarshal(data, snapshot); err != nil {
+				return errors.Wrapf(err, "corrupted snapshot %d", binary.BigEndian.Uint64(key))
+			}
+			if scope == "" || snapshot.Scope == scope {
+				snapshots = append(snapshots, snapshot)
+			}
+			return nil
+		})
+	})
+	return snapshots, err
+}
@@ -0,0 +1,84 @@
+package cert
+
+import (
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DiffSnapshots(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	otherCA := newTestCA(t, "Sentinel Other CA")
+	www := newTestLeaf(t, ca, "www.example.com")
+	renewed := newTestLeaf(t, ca, "www.example.com")
+	api := newTestLeaf(t, ca, "api.example.com")
+	rogue := newTestLeaf(t, otherCA, "api.example.com")
+	mail := newTestLeaf(t, ca, "mail.example.com")
+	ldap := newTestLeaf(t, ca, "ldap.example.com")
+
+	now := time.Now()
+	previous := NewSnapshot("test", []Result{
+		{Target: Target{Host: "192.0.2.1", Port: 443}, Chain: []*x509.Certificate{www.cert, ca.cert}},
+		{Target: Target{Host: "192.0.2.2", Port: 443}, Chain: []*x509.Certificate{api.cert, ca.cert}},
+		{Target: Target{Host: "192.0.2.3", Port: 25}, Chain: []*x509.Certificate{mail.cert, ca.cert}},
+		{Target: Target{Host: "192.0.2.4", Port: 636}, Chain: []*x509.Certificate{ldap.cert, ca.cert}},
+	}, now.Add(-time.Hour))
+	current := NewSnapshot("test", []Result{
+		{Target: Target{Host: "192.0.2.1", Port: 443}, Chain: []*x509.Certificate{renewed.cert, ca.cert}},
+		{Target: Target{Host: "192.0.2.2", Port: 443}, Chain: []*x509.Certificate{rogue.cert, otherCA.cert}},
+		{Target: Target{Host: "192.0.2.4", Port: 636}, Chain: []*x509.Certificate{ldap.cert, ca.cert}},
+	}, now)
+
+	events := DiffSnapshots(previous, current)
+	types := []string{}
+	for _, event := range events {
+		types = append(types, event.Host+" "+event.Type)
+	}
+	require.Equal(t, []string{
+		"192.0.2.1 new-certificate",
+		"192.0.2.1 rotated",
+		"192.0.2.1 disappeared",
+		"192.0.2.2 new-certificate",
+		"192.0.2.2 rotated",
+		"192.0.2.2 issuer-changed",
+		"192.0.2.2 disappeared",
+		"192.0.2.3 tls-stopped",
+		"192.0.2.3 disappeared",
+	}, types)
+	require.Equal(t, Fingerprint(api.cert), events[5].PreviousFingerprint)
+	require.Equal(t, Fingerprint(rogue.cert), events[5].Fingerprint)
+	require.Equal(t, "CN=Sentinel Other CA", events[5].Issuer)
+	require.True(t, now.Equal(events[5].Time))
+
+	require.Empty(t, DiffSnapshots(current, current))
+}
+
+func Test_InventorySnapshots(t *testing.T) {
+	inventory, err := OpenInventory(filepath.Join(tempDir(t), "sentinel.db"))
+	require.Nil(t, err)
+	defer inventory.Close()
+
+	for i := 0; i < 4; i++ {
+		require.Nil(t, inventory.SaveSnapshot(&Snapshot{Scope: "subnets", Time: time.Now()}, 2))
+	}
+	require.Nil(t, inventory.SaveSnapshot(&Snapshot{Scope: "domains", Time: time.Now()}, 2))
+
+	snapshots, err := inventory.Snapshots("subnets")
+	require.Nil(t, err)
+	require.Len(t, snapshots, 2)
+	require.Equal(t, uint64(3), snapshots[0].ID)
+	require.Equal(t, uint64(4), snapshots[1].ID)
+
+	snapshots, err = inventory.Snapshots("")
+	require.Nil(t, err)
+	require.Len(t, snapshots, 3)
+
+	snapshot, err := inventory.Snapshot(5)
+	require.Nil(t, err)
+	require.Equal(t, "domains", snapshot.Scope)
+	_, err = inventory.Snapshot(1)
+	require.NotNil(t, err)
+}
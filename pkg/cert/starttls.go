@@ -0,0 +1,466 @@
+package cert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// upgrades a plain text connection to TLS with a protocol specific command,
+// the TLS handshake follows once Negotiate returns
+type Negotiator interface {
+	Name() string
+	Negotiate(conn net.Conn, serverName string) error
+}
+
+var negotiators = map[string]Negotiator{
+	"smtp":        smtpNegotiator{},
+	"imap":        imapNegotiator{},
+	"pop3":        pop3Negotiator{},
+	"ftp":         ftpNegotiator{},
+	"ldap":        ldapNegotiator{},
+	"xmpp":        xmppNegotiator{namespace: "jabber:client"},
+	"xmpp-server": xmppNegotiator{namespace: "jabber:server"},
+	"postgres":    postgresNegotiator{},
+	"mysql":       mysqlNegotiator{},
+}
+
+// well-known ports requiring a STARTTLS upgrade
+var DefaultStartTLSPorts = map[int]string{
+	21:   "ftp",
+	25:   "smtp",
+	110:  "pop3",
+	143:  "imap",
+	389:  "ldap",
+	587:  "smtp",
+	3306: "mysql",
+	5222: "xmpp",
+	5269: "xmpp-server",
+	5432: "postgres",
+}
+
+// returns the negotiator of the given protocol
+func GetNegotiator(protocol string) (Negotiator, error) {
+	negotiator, ok := negotiators[protocol]
+	if !ok {
+		names := []string{}
+		for name := range negotiators {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, errors.Errorf("unknown STARTTLS protocol %s (supported: %s)", protocol, strings.Join(names, ", "))
+	}
+	return negotiator, nil
+}
+
+// merges the configured port to protocol mapping into the default one,
+// the protocol "none" disables STARTTLS on a well-known port
+func StartTLSPorts(overrides map[string]string) (map[int]string, error) {
+	ports := map[int]string{}
+	for port, protocol := range DefaultStartTLSPorts {
+		ports[port] = protocol
+	}
+	for rawPort, protocol := range overrides {
+		port, err := strconv.Atoi(rawPort)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, errors.Errorf("invalid STARTTLS port %s", rawPort)
+		}
+		if protocol == "none" {
+			delete(ports, port)
+			continue
+		}
+		if _, err := GetNegotiator(protocol); err != nil {
+			return nil, err
+		}
+		ports[port] = protocol
+	}
+	return ports, nil
+}
+
+// reads a line terminated by CRLF or LF
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// reads a possibly multi-line reply of a line based protocol ("250-..." continued
+// until "250 ...") and checks its code
+func readReply(reader *bufio.Reader, code string) ([]string, error) {
+	lines := []string{}
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, line)
+		if len(line) < 4 || line[3] != '-' {
+			if !strings.HasPrefix(line, code) {
+				return lines, errors.Errorf("unexpected reply %q, expected %s", line, code)
+			}
+			return lines, nil
+		}
+	}
+}
+
+type smtpNegotiator struct{}
+
+func (smtpNegotiator) Name() string {
+	return "smtp"
+}
+
+func (smtpNegotiator) Negotiate(conn net.Conn, serverName string) error {
+	reader := bufio.NewReader(conn)
+	if _, err := readReply(reader, "220"); err != nil {
+		return errors.Wrap(err, "no SMTP greeting")
+	}
+	if _, err := io.WriteString(conn, "EHLO sentinel\r\n"); err != nil {
+		return err
+	}
+	capabilities, err := readReply(reader, "250")
+	if err != nil {
+		return errors.Wrap(err, "EHLO refused")
+	}
+	supported := false
+	for _, capability := range capabilities {
+		if len(capability) > 4 && strings.EqualFold(strings.TrimSpace(capability[4:]), "STARTTLS") {
+			supported = true
+		}
+	}
+	if !supported {
+		return errors.New("STARTTLS is not advertised by the SMTP server")
+	}
+	if _, err := io.WriteString(conn, "STARTTLS\r\n"); err != nil {
+		return err
+	}
+	_, err = readReply(reader, "220")
+	return err
+}
+
+type imapNegotiator struct{}
+
+func (imapNegotiator) Name() string {
+	return "imap"
+}
+
+func (imapNegotiator) Negotiate(conn net.Conn, serverName string) error {
+	reader := bufio.NewReader(conn)
+	greeting, err := readLine(reader)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		return errors.Errorf("unexpected IMAP greeting %q", greeting)
+	}
+	if _, err := io.WriteString(conn, "s1 STARTTLS\r\n"); err != nil {
+		return err
+	}
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return err
+		}
+		// skip untagged responses
+		if !strings.HasPrefix(line, "s1 ") {
+			continue
+		}
+		if !strings.HasPrefix(line, "s1 OK") {
+			return errors.Errorf("STARTTLS refused: %q", line)
+		}
+		return nil
+	}
+}
+
+type pop3Negotiator struct{}
+
+func (pop3Negotiator) Name() string {
+	return "pop3"
+}
+
+func (pop3Negotiator) Negotiate(conn net.Conn, serverName string) error {
+	reader := bufio.NewReader(conn)
+	greeting, err := readLine(reader)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "+OK") {
+		return errors.Errorf("unexpected POP3 greeting %q", greeting)
+	}
+	if _, err := io.WriteString(conn, "STLS\r\n"); err != nil {
+		return err
+	}
+	line, err := readLine(reader)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return errors.Errorf("STLS refused: %q", line)
+	}
+	return nil
+}
+
+type ftpNegotiator struct{}
+
+func (ftpNegotiator) Name() string {
+	return "ftp"
+}
+
+func (ftpNegotiator) Negotiate(conn net.Conn, serverName string) error {
+	reader := bufio.NewReader(conn)
+	if _, err := readReply(reader, "220"); err != nil {
+		return errors.Wrap(err, "no FTP greeting")
+	}
+	if _, err := io.WriteString(conn, "AUTH TLS\r\n"); err != nil {
+		return err
+	}
+	_, err := readReply(reader, "234")
+	return err
+}
+
+// LDAP StartTLS extended operation (RFC 4511)
+const ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+type ldapNegotiator struct{}
+
+func (ldapNegotiator) Name() string {
+	return "ldap"
+}
+
+func (ldapNegotiator) Negotiate(conn net.Conn, serverName string) error {
+	// LDAPMessage { messageID 1, ExtendedRequest [APPLICATION 23] { requestName [0] OID } }
+	requestName := append([]byte{0x80, byte(len(ldapStartTLSOID))}, ldapStartTLSOID...)
+	extendedRequest := append([]byte{0x77, byte(len(requestName))}, requestName...)
+	message := append([]byte{0x02, 0x01, 0x01}, extendedRequest...)
+	if _, err := conn.Write(append([]byte{0x30, byte(len(message))}, message...)); err != nil {
+		return err
+	}
+
+	response, err := readBERElement(bufio.NewReader(conn))
+	if err != nil {
+		return errors.Wrap(err, "cannot read the LDAP extended response")
+	}
+	// servers may use non minimal BER lengths, which encoding/asn1 rejects
+	_, envelope, _, err := splitBERElement(response)
+	if err != nil {
+		return err
+	}
+	// skip the message ID
+	_, _, operation, err := splitBERElement(envelope)
+	if err != nil {
+		return err
+	}
+	// ExtendedResponse [APPLICATION 24] starts with the result code
+	tag, extendedResponse, _, err := splitBERElement(operation)
+	if err != nil {
+		return err
+	}
+	if tag != 0x78 {
+		return errors.Errorf("unexpected LDAP operation 0x%x", tag)
+	}
+	tag, resultCode, _, err := splitBERElement(extendedResponse)
+	if err != nil {
+		return err
+	}
+	if tag != 0x0a || len(resultCode) == 0 {
+		return errors.New("cannot parse the LDAP result code")
+	}
+	if len(bytes.Trim(resultCode, "\x00")) != 0 {
+		return errors.Errorf("StartTLS refused with LDAP result code %d", resultCode[len(resultCode)-1])
+	}
+	return nil
+}
+
+// returns the tag, the content and the remaining data of the first BER element
+func splitBERElement(data []byte) (byte, []byte, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, nil, errors.New("truncated BER element")
+	}
+	tag, length, offset := data[0], int(data[1]), 2
+	if length&0x80 != 0 {
+		size := length & 0x7f
+		if size > 4 || len(data) < 2+size {
+			return 0, nil, nil, errors.New("invalid BER length")
+		}
+		length = 0
+		for _, b := range data[2 : 2+size] {
+			length = length<<8 | int(b)
+		}
+		offset += size
+	}
+	if len(data) < offset+length {
+		return 0, nil, nil, errors.New("truncated BER element")
+	}
+	return tag, data[offset : offset+length], data[offset+length:], nil
+}
+
+// reads a complete BER encoded element
+func readBERElement(reader *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		lengthBytes := make([]byte, length&0x7f)
+		if len(lengthBytes) > 4 {
+			return nil, errors.New("BER element too large")
+		}
+		if _, err := io.ReadFull(reader, lengthBytes); err != nil {
+			return nil, err
+		}
+		header = append(header, lengthBytes...)
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(reader, content); err != nil {
+		return nil, err
+	}
+	return append(header, content...), nil
+}
+
+type xmppNegotiator struct {
+	namespace string
+}
+
+func (n xmppNegotiator) Name() string {
+	if n.namespace == "jabber:server" {
+		return "xmpp-server"
+	}
+	return "xmpp"
+}
+
+func (n xmppNegotiator) Negotiate(conn net.Conn, serverName string) error {
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(conn.RemoteAddr().String())
+	}
+	header := fmt.Sprintf("<?xml version='1.0'?><stream:stream to='%s' xmlns='%s' "+
+		"xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", serverName, n.namespace)
+	if _, err := io.WriteString(conn, header); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	features, err := readUntil(reader, "</stream:features>")
+	if err != nil {
+		return errors.Wrap(err, "cannot read the XMPP stream features")
+	}
+	if !strings.Contains(features, "urn:ietf:params:xml:ns:xmpp-tls") {
+		return errors.New("STARTTLS is not advertised by the XMPP server")
+	}
+	if _, err := io.WriteString(conn, "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"); err != nil {
+		return err
+	}
+	answer, err := readUntil(reader, ">")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(answer, "<proceed") {
+		return errors.Errorf("STARTTLS refused: %q", answer)
+	}
+	return nil
+}
+
+// reads until the given marker has been received
+func readUntil(reader *bufio.Reader, marker string) (string, error) {
+	data := []byte{}
+	for !bytes.HasSuffix(data, []byte(marker)) {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return string(data), err
+		}
+		data = append(data, b)
+		if len(data) > 64*1024 {
+			return string(data), errors.New("answer too large")
+		}
+	}
+	return string(data), nil
+}
+
+// PostgreSQL SSLRequest code
+const postgresSSLRequest = 80877103
+
+type postgresNegotiator struct{}
+
+func (postgresNegotiator) Name() string {
+	return "postgres"
+}
+
+func (postgresNegotiator) Negotiate(conn net.Conn, serverName string) error {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], postgresSSLRequest)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	answer := make([]byte, 1)
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return err
+	}
+	if answer[0] != 'S' {
+		return errors.Errorf("SSL refused by the PostgreSQL server (%q)", answer[0])
+	}
+	return nil
+}
+
+// MySQL capability flags
+const (
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientSSL              = 0x00000800
+	mysqlClientSecureConnection = 0x00008000
+)
+
+type mysqlNegotiator struct{}
+
+func (mysqlNegotiator) Name() string {
+	return "mysql"
+}
+
+func (mysqlNegotiator) Negotiate(conn net.Conn, serverName string) error {
+	// packet header: 3 bytes of length and the sequence id
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	handshake := make([]byte, length)
+	if _, err := io.ReadFull(conn, handshake); err != nil {
+		return err
+	}
+	if len(handshake) == 0 || handshake[0] != 10 {
+		return errors.New("unsupported MySQL handshake")
+	}
+	// skip the NUL terminated server version, the connection id and the first auth data part
+	versionEnd := bytes.IndexByte(handshake[1:], 0)
+	if versionEnd < 0 || len(handshake) < 1+versionEnd+1+4+8+1+2 {
+		return errors.New("truncated MySQL handshake")
+	}
+	offset := 1 + versionEnd + 1 + 4 + 8 + 1
+	capabilities := binary.LittleEndian.Uint16(handshake[offset : offset+2])
+	if capabilities&mysqlClientSSL == 0 {
+		return errors.New("SSL is not supported by the MySQL server")
+	}
+
+	// SSLRequest: capabilities, max packet size, charset and 23 reserved bytes
+	request := make([]byte, 4+32)
+	request[0] = 32
+	request[3] = header[3] + 1
+	binary.LittleEndian.PutUint32(request[4:8], mysqlClientProtocol41|mysqlClientSSL|mysqlClientSecureConnection)
+	binary.LittleEndian.PutUint32(request[8:12], 16*1024*1024)
+	// utf8_general_ci
+	request[12] = 33
+	_, err := conn.Write(request)
+	return err
+}
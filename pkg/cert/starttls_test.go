@@ -0,0 +1,169 @@
+package cert
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// plays the server side of a STARTTLS dialog before the TLS handshake
+type fakeDialog func(conn net.Conn, reader *bufio.Reader) error
+
+// expects the given line from the client
+func expectLine(t *testing.T, reader *bufio.Reader, expected string) {
+	line, err := reader.ReadString('\n')
+	require.Nil(t, err)
+	require.Equal(t, expected, strings.TrimRight(line, "\r\n"))
+}
+
+// starts a server playing the dialog then completing a TLS handshake
+func startFakeServer(t *testing.T, dialog fakeDialog, certificate tls.Certificate) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err := dialog(conn, bufio.NewReader(conn)); err != nil {
+			return
+		}
+		tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{certificate}}).Handshake()
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func Test_StartTLSPorts(t *testing.T) {
+	ports, err := StartTLSPorts(map[string]string{"2525": "smtp", "25": "none"})
+	require.Nil(t, err)
+	require.Equal(t, "smtp", ports[2525])
+	require.Equal(t, "imap", ports[143])
+	_, ok := ports[25]
+	require.False(t, ok)
+
+	_, err = StartTLSPorts(map[string]string{"2525": "gopher"})
+	require.NotNil(t, err)
+	_, err = StartTLSPorts(map[string]string{"smtp": "smtp"})
+	require.NotNil(t, err)
+}
+
+func Test_Negotiators(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	leaf := newTestLeaf(t, ca, "mail.example.com")
+	certificate := tlsCertificate(leaf)
+
+	dialogs := map[string]fakeDialog{
+		"smtp": func(conn net.Conn, reader *bufio.Reader) error {
+			io.WriteString(conn, "220-mail.example.com ESMTP\r\n220 ready\r\n")
+			expectLine(t, reader, "EHLO sentinel")
+			io.WriteString(conn, "250-mail.example.com\r\n250-PIPELINING\r\n250 STARTTLS\r\n")
+			expectLine(t, reader, "STARTTLS")
+			_, err := io.WriteString(conn, "220 2.0.0 Ready to start TLS\r\n")
+			return err
+		},
+		"imap": func(conn net.Conn, reader *bufio.Reader) error {
+			io.WriteString(conn, "* OK [CAPABILITY IMAP4rev1 STARTTLS] ready\r\n")
+			expectLine(t, reader, "s1 STARTTLS")
+			_, err := io.WriteString(conn, "* BYE not really\r\ns1 OK Begin TLS negotiation now\r\n")
+			return err
+		},
+		"pop3": func(conn net.Conn, reader *bufio.Reader) error {
+			io.WriteString(conn, "+OK POP3 ready\r\n")
+			expectLine(t, reader, "STLS")
+			_, err := io.WriteString(conn, "+OK Begin TLS negotiation\r\n")
+			return err
+		},
+		"ftp": func(conn net.Conn, reader *bufio.Reader) error {
+			io.WriteString(conn, "220 FTP ready\r\n")
+			expectLine(t, reader, "AUTH TLS")
+			_, err := io.WriteString(conn, "234 AUTH TLS OK.\r\n")
+			return err
+		},
+		"ldap": func(conn net.Conn, reader *bufio.Reader) error {
+			request, err := readBERElement(reader)
+			require.Nil(t, err)
+			require.Contains(t, string(request), ldapStartTLSOID)
+			// extended response with a non minimal length as sent by some directories
+			_, err = conn.Write([]byte{0x30, 0x84, 0x00, 0x00, 0x00, 0x0c, 0x02, 0x01, 0x01, 0x78, 0x07, 0x0a, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00})
+			return err
+		},
+		"xmpp": func(conn net.Conn, reader *bufio.Reader) error {
+			header, err := readUntil(reader, "version='1.0'>")
+			require.Nil(t, err)
+			require.Contains(t, header, "to='mail.example.com'")
+			io.WriteString(conn, "<?xml version='1.0'?><stream:stream from='mail.example.com' id='1' version='1.0' "+
+				"xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams'>"+
+				"<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls></stream:features>")
+			_, err = readUntil(reader, "/>")
+			require.Nil(t, err)
+			_, err = io.WriteString(conn, "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+			return err
+		},
+		"postgres": func(conn net.Conn, reader *bufio.Reader) error {
+			request := make([]byte, 8)
+			_, err := io.ReadFull(reader, request)
+			require.Nil(t, err)
+			require.Equal(t, uint32(postgresSSLRequest), binary.BigEndian.Uint32(request[4:]))
+			_, err = conn.Write([]byte("S"))
+			return err
+		},
+		"mysql": func(conn net.Conn, reader *bufio.Reader) error {
+			// protocol 10, version, connection id, auth data, filler, capabilities
+			handshake := append([]byte{10}, "5.7.30\x00"...)
+			handshake = append(handshake, 1, 0, 0, 0)
+			handshake = append(handshake, "abcdefgh\x00"...)
+			handshake = append(handshake, 0xff, 0xff)
+			conn.Write(append([]byte{byte(len(handshake)), 0, 0, 0}, handshake...))
+			// the client hello follows the request without waiting, it must not be buffered
+			request := make([]byte, 36)
+			_, err := io.ReadFull(conn, request)
+			require.Nil(t, err)
+			require.Equal(t, byte(1), request[3])
+			require.NotZero(t, binary.LittleEndian.Uint32(request[4:8])&mysqlClientSSL)
+			return nil
+		},
+	}
+
+	for protocol, dialog := range dialogs {
+		port := startFakeServer(t, dialog, certificate)
+		conf, err := NewScanConfig([]int{port}, 1, 2)
+		require.Nil(t, err)
+		conf.StartTLS[port] = protocol
+
+		result := NewScanner(conf).Probe(context.Background(), Target{Host: "127.0.0.1", Port: port, ServerName: "mail.example.com"})
+		require.Nil(t, result.Err, protocol)
+		require.Equal(t, protocol, result.Protocol)
+		require.Equal(t, leaf.cert.Raw, result.Leaf().Raw, protocol)
+	}
+}
+
+func Test_NegotiatorRefused(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	certificate := tlsCertificate(newTestLeaf(t, ca, "mail.example.com"))
+	port := startFakeServer(t, func(conn net.Conn, reader *bufio.Reader) error {
+		io.WriteString(conn, "220 ready\r\n")
+		expectLine(t, reader, "EHLO sentinel")
+		io.WriteString(conn, "250 mail.example.com\r\n")
+		return io.EOF
+	}, certificate)
+
+	conf, err := NewScanConfig([]int{port}, 1, 2)
+	require.Nil(t, err)
+	conf.StartTLS[port] = "smtp"
+	result := NewScanner(conf).Probe(context.Background(), Target{Host: "127.0.0.1", Port: port})
+	require.NotNil(t, result.Err)
+	require.True(t, result.Reachable)
+	require.Contains(t, result.Err.Error(), "not advertised")
+}
@@ -0,0 +1,178 @@
+package cert
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// a source of candidate hostnames for a given domain
+type SubdomainSource interface {
+	// name used in the configuration and the logs
+	Name() string
+	// returns candidate hostnames, they do not have to be normalized nor deduplicated
+	Subdomains(ctx context.Context, domain string) ([]string, error)
+}
+
+// the subset of net.Resolver used to resolve the discovered hostnames
+type HostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// lowercases the hostname and strips the trailing dot and wildcard label
+func NormalizeHostname(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.TrimSuffix(name, ".")
+	return strings.TrimPrefix(name, "*.")
+}
+
+// returns true if name is the domain itself or one of its sub-domains
+func InDomain(name string, domain string) bool {
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+// queries all the sources for all the domains and returns the deduplicated hostnames
+// belonging to the domains. Failing sources do not stop the discovery, their errors are returned.
+func DiscoverSubdomains(ctx context.Context, sources []SubdomainSource, domains []string) ([]string, []error) {
+	found := map[string]bool{}
+	errs := []error{}
+	for _, domain := range domains {
+		domain = NormalizeHostname(domain)
+		// the domain itself is always a candidate
+		found[domain] = true
+		for _, source := range sources {
+			names, err := source.Subdomains(ctx, domain)
+			if err != nil {
+				errs = append(errs, errors.Wrapf(err, "source %s failed for domain %s", source.Name(), domain))
+			}
+			for _, name := range names {
+				name = NormalizeHostname(name)
+				if InDomain(name, domain) {
+					found[name] = true
+				}
+			}
+		}
+	}
+
+	hosts := []string{}
+	for host := range found {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts, errs
+}
+
+// resolves the hostnames concurrently, unresolvable hostnames are left out of the result
+func ResolveHosts(ctx context.Context, resolver HostResolver, hosts []string, workers int) map[string][]string {
+	resolved := map[string][]string{}
+	lock := &sync.Mutex{}
+	hostChan := make(chan string)
+	wg := &sync.WaitGroup{}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for host := range hostChan {
+				addrs, err := resolver.LookupHost(ctx, host)
+				if err != nil || len(addrs) == 0 {
+					continue
+				}
+				sort.Strings(addrs)
+				lock.Lock()
+				resolved[host] = addrs
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, host := range hosts {
+		hostChan <- host
+	}
+	close(hostChan)
+	wg.Wait()
+	return resolved
+}
+
+// produces a scan target for every address and port of the resolved hostnames,
+// the hostname is sent as SNI
+func HostTargets(ctx context.Context, resolved map[string][]string, ports []int) <-chan Target {
+	hosts := []string{}
+	for host := range resolved {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	targets := make(chan Target)
+	go func() {
+		defer close(targets)
+		for _, host := range hosts {
+			for _, addr := range resolved[host] {
+				for _, port := range ports {
+					select {
+					case targets <- Target{Host: addr, Port: port, ServerName: host}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+	return targets
+}
+
+// returns the configured hostnames of the domain
+type ListSource struct {
+	Hosts []string
+}
+
+func (s *ListSource) Name() string {
+	return "list"
+}
+
+func (s *ListSource) Subdomains(ctx context.Context, domain string) ([]string, error) {
+	return s.Hosts, nil
+}
+
+// returns the names found in the certificates presented by the domain and its
+// already known hosts, they often list sibling sub-domains
+type SANSource struct {
+	Scanner *Scanner
+	Ports   []int
+	// hosts probed beside the domain itself, e.g. www
+	Prefixes []string
+}
+
+func (s *SANSource) Name() string {
+	return "san"
+}
+
+func (s *SANSource) Subdomains(ctx context.Context, domain string) ([]string, error) {
+	hosts := []string{domain}
+	for _, prefix := range s.Prefixes {
+		hosts = append(hosts, prefix+"."+domain)
+	}
+
+	names := []string{}
+	var lastErr error
+	for _, host := range hosts {
+		for _, port := range s.Ports {
+			result := s.Scanner.Probe(ctx, Target{Host: host, Port: port, ServerName: host})
+			if result.Err != nil {
+				lastErr = result.Err
+				continue
+			}
+			leaf := result.Leaf()
+			names = append(names, leaf.DNSNames...)
+			if leaf.Subject.CommonName != "" && net.ParseIP(leaf.Subject.CommonName) == nil {
+				names = append(names, leaf.Subject.CommonName)
+			}
+		}
+	}
+	if len(names) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return names, nil
+}
@@ -0,0 +1,72 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type failingSource struct{}
+
+func (s *failingSource) Name() string {
+	return "failing"
+}
+
+func (s *failingSource) Subdomains(ctx context.Context, domain string) ([]string, error) {
+	return nil, errors.New("unavailable")
+}
+
+type staticResolver map[string][]string
+
+func (r staticResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r[host]; ok {
+		return addrs, nil
+	}
+	return nil, errors.Errorf("no such host %s", host)
+}
+
+func Test_DiscoverSubdomains(t *testing.T) {
+	sources := []SubdomainSource{
+		&ListSource{Hosts: []string{"WWW.example.com.", "*.api.example.com", "www.example.com", "other.org", "notexample.com"}},
+		&failingSource{},
+	}
+	hosts, errs := DiscoverSubdomains(context.Background(), sources, []string{"example.com"})
+	require.Len(t, errs, 1)
+	require.Equal(t, []string{"api.example.com", "example.com", "www.example.com"}, hosts)
+}
+
+func Test_ResolveHostTargets(t *testing.T) {
+	resolver := staticResolver{
+		"example.com":     {"192.0.2.2", "192.0.2.1"},
+		"www.example.com": {"192.0.2.3"},
+	}
+	ctx := context.Background()
+	resolved := ResolveHosts(ctx, resolver, []string{"example.com", "www.example.com", "gone.example.com"}, 2)
+	require.Len(t, resolved, 2)
+
+	targets := []Target{}
+	for target := range HostTargets(ctx, resolved, []int{443}) {
+		targets = append(targets, target)
+	}
+	require.Equal(t, []Target{
+		{Host: "192.0.2.1", Port: 443, ServerName: "example.com"},
+		{Host: "192.0.2.2", Port: 443, ServerName: "example.com"},
+		{Host: "192.0.2.3", Port: 443, ServerName: "www.example.com"},
+	}, targets)
+}
+
+func Test_SANSource(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	leaf := newTestLeaf(t, ca, "localhost", "intranet.localhost", "*.shop.localhost")
+	_, port := startTLSServer(t, &tls.Config{Certificates: []tls.Certificate{tlsCertificate(leaf)}})
+
+	conf, err := NewScanConfig([]int{port}, 1, 2)
+	require.Nil(t, err)
+	source := &SANSource{Scanner: NewScanner(conf), Ports: conf.Ports}
+	hosts, errs := DiscoverSubdomains(context.Background(), []SubdomainSource{source}, []string{"localhost"})
+	require.Empty(t, errs)
+	require.Equal(t, []string{"intranet.localhost", "localhost", "shop.localhost"}, hosts)
+}
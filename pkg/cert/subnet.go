@@ -0,0 +1,130 @@
+package cert
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// maximum number of host bits we accept to expand (a /8 in IPv4, a /104 in IPv6)
+const maxHostBits = 24
+
+// parses the given CIDRs and rejects the ones too large to be scanned
+func ParseSubnets(cidrs []string) ([]*net.IPNet, error) {
+	subnets := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			// a single address is accepted as a /32 or /128
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, errors.Wrapf(err, "invalid subnet %s", cidr)
+			}
+			ipnet = singleHostNet(ip)
+		}
+		ones, bits := ipnet.Mask.Size()
+		if bits-ones > maxHostBits {
+			return nil, errors.Errorf("subnet %s is too large to be scanned (more than %d host bits)", cidr, maxHostBits)
+		}
+		subnets = append(subnets, ipnet)
+	}
+	return subnets, nil
+}
+
+// returns the network containing only the given address
+func singleHostNet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip.To16(), Mask: net.CIDRMask(128, 128)}
+}
+
+// returns the number of addresses of the subnet
+func SubnetSize(ipnet *net.IPNet) uint64 {
+	ones, bits := ipnet.Mask.Size()
+	return uint64(1) << uint(bits-ones)
+}
+
+// returns the number of host addresses of the subnet walked by WalkSubnet
+func SubnetHostCount(ipnet *net.IPNet) uint64 {
+	ones, bits := ipnet.Mask.Size()
+	if bits == 32 && ones < 31 {
+		return SubnetSize(ipnet) - 2
+	}
+	return SubnetSize(ipnet)
+}
+
+// calls f for every host address of the subnet until f returns false.
+// The IPv4 network and broadcast addresses are skipped for prefixes shorter than /31.
+func WalkSubnet(ipnet *net.IPNet, f func(ip net.IP) bool) {
+	walkHosts(ipnet, 0, f)
+}
+
+// calls f for the host addresses of the subnet starting with the one of the given index
+func walkHosts(ipnet *net.IPNet, first uint64, f func(ip net.IP) bool) {
+	ones, bits := ipnet.Mask.Size()
+	offset := uint64(0)
+	if bits == 32 && ones < 31 {
+		offset = 1
+	}
+
+	base := new(big.Int).SetBytes(ipnet.IP.Mask(ipnet.Mask))
+	for i := first; i < SubnetHostCount(ipnet); i++ {
+		if !f(offsetIP(base, offset+i, bits/8)) {
+			return
+		}
+	}
+}
+
+// returns base + offset as an IP address of the given length in bytes
+func offsetIP(base *big.Int, offset uint64, length int) net.IP {
+	value := new(big.Int).Add(base, new(big.Int).SetUint64(offset)).Bytes()
+	ip := make(net.IP, length)
+	copy(ip[length-len(value):], value)
+	return ip
+}
+
+// produces a scan target for every host and port of the given subnets
+func SubnetTargets(ctx context.Context, subnets []*net.IPNet, ports []int) <-chan Target {
+	return SubnetBatchTargets(ctx, subnets, ports, 0, math.MaxUint64)
+}
+
+// produces a scan target for every port of count hosts of the given subnets, starting
+// with the host of the given index. The hosts are numbered in the order of SubnetTargets.
+func SubnetBatchTargets(ctx context.Context, subnets []*net.IPNet, ports []int, first uint64, count uint64) <-chan Target {
+	targets := make(chan Target)
+	go func() {
+		defer close(targets)
+		for _, subnet := range subnets {
+			if hosts := SubnetHostCount(subnet); first >= hosts {
+				first -= hosts
+				continue
+			}
+			keepGoing := true
+			walkHosts(subnet, first, func(ip net.IP) bool {
+				if count == 0 {
+					keepGoing = false
+					return false
+				}
+				count--
+				for _, port := range ports {
+					select {
+					case targets <- Target{Host: ip.String(), Port: port}:
+					case <-ctx.Done():
+						keepGoing = false
+						return false
+					}
+				}
+				return true
+			})
+			if !keepGoing {
+				return
+			}
+			first = 0
+		}
+	}()
+	return targets
+}
@@ -0,0 +1,62 @@
+package cert
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func walk(t *testing.T, cidr string) []string {
+	subnets, err := ParseSubnets([]string{cidr})
+	require.Nil(t, err)
+	hosts := []string{}
+	WalkSubnet(subnets[0], func(ip net.IP) bool {
+		hosts = append(hosts, ip.String())
+		return true
+	})
+	return hosts
+}
+
+func Test_WalkSubnetIPv4(t *testing.T) {
+	require.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, walk(t, "10.0.0.0/30"))
+	require.Equal(t, []string{"10.0.0.0", "10.0.0.1"}, walk(t, "10.0.0.0/31"))
+	require.Equal(t, []string{"10.0.0.7"}, walk(t, "10.0.0.7"))
+	require.Len(t, walk(t, "192.168.0.0/24"), 254)
+}
+
+func Test_WalkSubnetIPv6(t *testing.T) {
+	require.Equal(t, []string{"2001:db8::", "2001:db8::1", "2001:db8::2", "2001:db8::3"}, walk(t, "2001:db8::/126"))
+	require.Equal(t, []string{"2001:db8::ff"}, walk(t, "2001:db8::ff"))
+}
+
+func Test_ParseSubnetsInvalid(t *testing.T) {
+	_, err := ParseSubnets([]string{"not-a-subnet"})
+	require.NotNil(t, err)
+	_, err = ParseSubnets([]string{"2001:db8::/64"})
+	require.NotNil(t, err)
+}
+
+func Test_SubnetBatchTargets(t *testing.T) {
+	subnets, err := ParseSubnets([]string{"10.0.0.0/30", "10.0.1.5", "2001:db8::/127"})
+	require.Nil(t, err)
+	total := uint64(0)
+	for _, subnet := range subnets {
+		total += SubnetHostCount(subnet)
+	}
+	require.Equal(t, uint64(5), total)
+
+	ctx := context.Background()
+	batch := func(first uint64, count uint64) []string {
+		hosts := []string{}
+		for target := range SubnetBatchTargets(ctx, subnets, []int{443, 8443}, first, count) {
+			hosts = append(hosts, target.Address())
+		}
+		return hosts
+	}
+	require.Equal(t, []string{"10.0.0.2:443", "10.0.0.2:8443", "10.0.1.5:443", "10.0.1.5:8443"}, batch(1, 2))
+	require.Equal(t, []string{"[2001:db8::1]:443", "[2001:db8::1]:8443"}, batch(4, 2))
+	require.Empty(t, batch(5, 2))
+	require.Len(t, batch(0, total), 10)
+}
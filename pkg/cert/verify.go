@@ -0,0 +1,133 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const chainCheck = "chain"
+
+// verifies the chains presented by the endpoints against a trust store
+type ChainValidator struct {
+	Roots *x509.CertPool
+}
+
+// creates a validator trusting the given PEM bundles, and the system pool if asked
+func NewChainValidator(useSystemPool bool, bundles []string) (*ChainValidator, error) {
+	roots := x509.NewCertPool()
+	if useSystemPool {
+		systemRoots, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot load the system trust store")
+		}
+		roots = systemRoots
+	}
+	for _, bundle := range bundles {
+		data, err := ioutil.ReadFile(bundle)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read CA bundle %s", bundle)
+		}
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, errors.Errorf("no certificate found in CA bundle %s", bundle)
+		}
+	}
+	return &ChainValidator{Roots: roots}, nil
+}
+
+// returns true if the certificate is self-signed
+func isSelfSigned(c *x509.Certificate) bool {
+	return bytes.Equal(c.RawIssuer, c.RawSubject) && c.CheckSignatureFrom(c) == nil
+}
+
+// returns true if issuer signed c
+func issuedBy(c *x509.Certificate, issuer *x509.Certificate) bool {
+	return bytes.Equal(c.RawIssuer, issuer.RawSubject) && c.CheckSignatureFrom(issuer) == nil
+}
+
+// returns the problems of the chain presented by the endpoint, none if it is valid.
+// The expiry of the leaf is not reported here, it is evaluated separately.
+func (v *ChainValidator) Check(result Result, now time.Time) []Finding {
+	findings := []Finding{}
+	leaf := result.Leaf()
+	if leaf == nil {
+		return findings
+	}
+
+	// every certificate has to be followed by its issuer
+	for i := 0; i < len(result.Chain)-1; i++ {
+		if issuedBy(result.Chain[i], result.Chain[i+1]) {
+			continue
+		}
+		for j := range result.Chain {
+			if j != i && issuedBy(result.Chain[i], result.Chain[j]) {
+				findings = append(findings, newFinding(chainCheck, "chain-order", StatusWarning, i,
+					"the issuer of %s is presented at position %d instead of %d", result.Chain[i].Subject.CommonName, j, i+1))
+				break
+			}
+		}
+	}
+
+	for depth, c := range result.Chain[1:] {
+		if now.After(c.NotAfter) || now.Before(c.NotBefore) {
+			findings = append(findings, newFinding(chainCheck, "expired-intermediate", StatusCritical, depth+1,
+				"%s is not valid at this time (valid from %s to %s)", c.Subject.CommonName, c.NotBefore.UTC().Format(time.RFC3339), c.NotAfter.UTC().Format(time.RFC3339)))
+		}
+	}
+
+	// the trust is evaluated within the validity of the leaf so an expired leaf
+	// still gets its chain checked
+	verifyTime := now
+	if verifyTime.After(leaf.NotAfter) {
+		verifyTime = leaf.NotAfter
+	} else if verifyTime.Before(leaf.NotBefore) {
+		verifyTime = leaf.NotBefore
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range result.Chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         v.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   verifyTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		findings = append(findings, v.classifyError(result, err)...)
+	}
+
+	if result.ServerName != "" {
+		if err := leaf.VerifyHostname(result.ServerName); err != nil {
+			findings = append(findings, newFinding(chainCheck, "hostname-mismatch", StatusCritical, 0, "%s", err))
+		}
+	}
+	return findings
+}
+
+// translates a verification error into findings
+func (v *ChainValidator) classifyError(result Result, err error) []Finding {
+	switch e := err.(type) {
+	case x509.UnknownAuthorityError:
+		top := result.Chain[len(result.Chain)-1]
+		if isSelfSigned(top) {
+			return []Finding{newFinding(chainCheck, "untrusted-root", StatusCritical, len(result.Chain)-1,
+				"the root %s is not trusted", top.Subject.CommonName)}
+		}
+		if len(top.IssuingCertificateURL) != 0 {
+			return []Finding{newFinding(chainCheck, "missing-intermediate", StatusCritical, len(result.Chain)-1,
+				"the issuer of %s is neither presented nor trusted, it is published at %s", top.Subject.CommonName, top.IssuingCertificateURL[0])}
+		}
+		return []Finding{newFinding(chainCheck, "missing-intermediate", StatusCritical, len(result.Chain)-1,
+			"the issuer of %s is neither presented nor trusted", top.Subject.CommonName)}
+	case x509.CertificateInvalidError:
+		// expired intermediates are already reported
+		if e.Reason == x509.Expired {
+			return []Finding{}
+		}
+	}
+	return []Finding{newFinding(chainCheck, "invalid-chain", StatusCritical, -1, "%s", err)}
+}
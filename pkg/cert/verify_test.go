@@ -0,0 +1,105 @@
+package cert
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// returns a root, an intermediate and a leaf for www.example.com
+func testChain(t *testing.T) (*testCert, *testCert, *testCert) {
+	root := newTestCA(t, "Sentinel Root CA")
+	intermediate := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Sentinel Intermediate CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, root)
+	leaf := newTestLeaf(t, intermediate, "www.example.com")
+	return root, intermediate, leaf
+}
+
+func findingIDs(findings []Finding) []string {
+	ids := []string{}
+	for _, finding := range findings {
+		ids = append(ids, finding.ID)
+	}
+	return ids
+}
+
+func Test_NewChainValidator(t *testing.T) {
+	root, _, _ := testChain(t)
+	bundle := filepath.Join(tempDir(t), "ca.pem")
+	require.Nil(t, ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.cert.Raw}), 0600))
+
+	validator, err := NewChainValidator(false, []string{bundle})
+	require.Nil(t, err)
+	require.Len(t, validator.Roots.Subjects(), 1)
+
+	_, err = NewChainValidator(false, []string{filepath.Join(tempDir(t), "missing.pem")})
+	require.NotNil(t, err)
+}
+
+func Test_ChainValidatorCheck(t *testing.T) {
+	root, intermediate, leaf := testChain(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	validator := &ChainValidator{Roots: roots}
+	now := time.Now()
+
+	result := Result{Target: Target{ServerName: "www.example.com"}, Chain: []*x509.Certificate{leaf.cert, intermediate.cert}}
+	require.Empty(t, validator.Check(result, now))
+
+	result.Chain = []*x509.Certificate{leaf.cert}
+	require.Equal(t, []string{"missing-intermediate"}, findingIDs(validator.Check(result, now)))
+
+	// the AIA URL is reported as is
+	published := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "www.example.com"},
+		DNSNames:              []string{"www.example.com"},
+		IssuingCertificateURL: []string{"http://ca.example.com/Sentinel%20Intermediate%20CA.crt"},
+	}, intermediate)
+	findings := validator.Check(Result{Target: Target{ServerName: "www.example.com"}, Chain: []*x509.Certificate{published.cert}}, now)
+	require.Equal(t, []string{"missing-intermediate"}, findingIDs(findings))
+	require.Equal(t, "the issuer of www.example.com is neither presented nor trusted, it is published at http://ca.example.com/Sentinel%20Intermediate%20CA.crt", findings[0].Message)
+
+	result.Chain = []*x509.Certificate{leaf.cert, root.cert, intermediate.cert}
+	require.Equal(t, []string{"chain-order"}, findingIDs(validator.Check(result, now)))
+
+	result.Chain = []*x509.Certificate{leaf.cert, intermediate.cert}
+	result.ServerName = "mail.example.com"
+	require.Equal(t, []string{"hostname-mismatch"}, findingIDs(validator.Check(result, now)))
+
+	untrusted := &ChainValidator{Roots: x509.NewCertPool()}
+	result.Chain = []*x509.Certificate{leaf.cert, intermediate.cert, root.cert}
+	result.ServerName = ""
+	require.Equal(t, []string{"untrusted-root"}, findingIDs(untrusted.Check(result, now)))
+}
+
+func Test_ChainValidatorExpiredIntermediate(t *testing.T) {
+	root := newTestCA(t, "Sentinel Root CA")
+	intermediate := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Sentinel Expired CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		NotBefore:             time.Now().Add(-48 * time.Hour),
+		NotAfter:              time.Now().Add(-24 * time.Hour),
+	}, root)
+	leaf := newTestLeaf(t, intermediate, "www.example.com")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	validator := &ChainValidator{Roots: roots}
+	result := Result{Chain: []*x509.Certificate{leaf.cert, intermediate.cert}}
+	findings := validator.Check(result, time.Now())
+	require.Equal(t, []string{"expired-intermediate"}, findingIDs(findings))
+	require.Equal(t, 1, findings[0].Depth)
+	require.Equal(t, StatusCritical, WorstStatus(findings))
+}
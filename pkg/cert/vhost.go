@@ -0,0 +1,94 @@
+package cert
+
+import (
+	"bytes"
+	"context"
+	"sort"
+)
+
+// resolves the names of an address with PTR lookups
+type AddrResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// the candidate virtual host names sent as SNI to the endpoints of a subnet scan
+type VirtualHosts struct {
+	// names tried on every endpoint
+	Names []string
+	// resolves the names of each address when not nil
+	Resolver AddrResolver
+}
+
+// returns the deduplicated candidate names of the address
+func (v *VirtualHosts) Candidates(ctx context.Context, addr string) []string {
+	names := append([]string{}, v.Names...)
+	if v.Resolver != nil {
+		// addresses without PTR record only get the configured names
+		if ptrs, err := v.Resolver.LookupAddr(ctx, addr); err == nil {
+			names = append(names, ptrs...)
+		}
+	}
+
+	seen := map[string]bool{}
+	candidates := []string{}
+	for _, name := range names {
+		name = NormalizeHostname(name)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			candidates = append(candidates, name)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// produces a target per candidate name of every endpoint that completed a handshake
+// without SNI, the certificate returned for each name can then be compared to the default one
+func (v *VirtualHosts) Targets(ctx context.Context, results []Result) <-chan Target {
+	targets := make(chan Target)
+	go func() {
+		defer close(targets)
+		candidates := map[string][]string{}
+		for _, result := range results {
+			if result.ServerName != "" || result.Err != nil {
+				continue
+			}
+			names, ok := candidates[result.Host]
+			if !ok {
+				names = v.Candidates(ctx, result.Host)
+				candidates[result.Host] = names
+			}
+			for _, name := range names {
+				select {
+				case targets <- Target{Host: result.Host, Port: result.Port, ServerName: name}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return targets
+}
+
+// returns the virtual host results with a certificate, the ones for which the endpoint
+// falls back to its default certificate without it covering the name are marked as
+// fallbacks: the name is not served by the endpoint.
+func VirtualHostResults(defaults []Result, results []Result) []Result {
+	defaultLeaves := map[string][]byte{}
+	for _, result := range defaults {
+		if leaf := result.Leaf(); leaf != nil && result.ServerName == "" {
+			defaultLeaves[result.Address()] = leaf.Raw
+		}
+	}
+
+	vhostResults := []Result{}
+	for _, result := range results {
+		leaf := result.Leaf()
+		if leaf == nil {
+			continue
+		}
+		result.Fallback = bytes.Equal(leaf.Raw, defaultLeaves[result.Address()]) && leaf.VerifyHostname(result.ServerName) != nil
+		vhostResults = append(vhostResults, result)
+	}
+	return vhostResults
+}
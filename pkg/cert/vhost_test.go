@@ -0,0 +1,71 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// answers PTR lookups from a static table
+type staticAddrResolver map[string][]string
+
+func (r staticAddrResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return r[addr], nil
+}
+
+func Test_VirtualHostsCandidates(t *testing.T) {
+	vhosts := &VirtualHosts{
+		Names:    []string{"WWW.example.com", "api.example.com."},
+		Resolver: staticAddrResolver{"127.0.0.1": {"www.example.com.", "host-1.example.net."}},
+	}
+	require.Equal(t, []string{"api.example.com", "host-1.example.net", "www.example.com"}, vhosts.Candidates(context.Background(), "127.0.0.1"))
+	require.Equal(t, []string{"api.example.com", "www.example.com"}, vhosts.Candidates(context.Background(), "127.0.0.2"))
+}
+
+func Test_VirtualHostsScan(t *testing.T) {
+	ca := newTestCA(t, "Sentinel Test CA")
+	fallback := tlsCertificate(newTestLeaf(t, ca, "default.example.com"))
+	www := tlsCertificate(newTestLeaf(t, ca, "www.example.com"))
+	host, port := startTLSServer(t, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName == "www.example.com" {
+				return &www, nil
+			}
+			return &fallback, nil
+		},
+	})
+
+	conf, err := NewScanConfig([]int{port}, 4, 2)
+	require.Nil(t, err)
+	scanner := NewScanner(conf)
+	defaults := []Result{scanner.Probe(context.Background(), Target{Host: host, Port: port})}
+	require.Nil(t, defaults[0].Err)
+
+	vhosts := &VirtualHosts{
+		Names:    []string{"www.example.com", "unknown.example.com"},
+		Resolver: staticAddrResolver{host: {"default.example.com."}},
+	}
+	results := []Result{}
+	for result := range scanner.Run(context.Background(), vhosts.Targets(context.Background(), defaults)) {
+		require.Nil(t, result.Err)
+		results = append(results, result)
+	}
+	require.Len(t, results, 3)
+
+	served := map[string]string{}
+	fallbacks := []string{}
+	for _, result := range VirtualHostResults(defaults, results) {
+		served[result.ServerName] = result.Leaf().Subject.CommonName
+		if result.Fallback {
+			fallbacks = append(fallbacks, result.ServerName)
+		}
+	}
+	require.Equal(t, map[string]string{
+		"www.example.com":     "www.example.com",
+		"default.example.com": "default.example.com",
+		"unknown.example.com": "default.example.com",
+	}, served)
+	require.Equal(t, []string{"unknown.example.com"}, fallbacks)
+}